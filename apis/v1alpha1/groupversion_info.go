@@ -0,0 +1,18 @@
+// Package v1alpha1 contains the API types of the injector.mesher.io group
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group and version of the injector resources
+	GroupVersion = schema.GroupVersion{Group: "injector.mesher.io", Version: "v1alpha1"}
+
+	// SchemeBuilder registers the injector resources into a scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the injector resources to a scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,82 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SidecarTemplate is what gets injected into a pod
+type SidecarTemplate struct {
+	Containers       []corev1.Container            `json:"containers,omitempty"`
+	InitContainers   []corev1.Container            `json:"initContainers,omitempty"`
+	Volumes          []corev1.Volume               `json:"volumes,omitempty"`
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Annotations and Labels are set on the injected pods
+	Annotations []PodMetadata `json:"annotations,omitempty"`
+	Labels      []PodMetadata `json:"labels,omitempty"`
+	// PortsEnv is the env variable of the sidecar containers given the ports of the app containers
+	PortsEnv string `json:"portsEnv,omitempty"`
+	// CNIAnnotations are set on the pods instead of running the init containers, where the mesh
+	// CNI plugin intercepts the traffic
+	CNIAnnotations []PodMetadata `json:"cniAnnotations,omitempty"`
+}
+
+// PodMetadata is an annotation or a label set on the injected pods
+type PodMetadata struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Override replaces the value set on the pod, which is kept otherwise
+	Override bool `json:"override,omitempty"`
+}
+
+// SidecarConfigurationSpec is the base sidecar template plus the named profiles
+type SidecarConfigurationSpec struct {
+	SidecarTemplate `json:",inline"`
+
+	Profiles map[string]SidecarTemplate `json:"profiles,omitempty"`
+}
+
+// StaleWorkload is a controller whose pods run a sidecar injected with another config
+type StaleWorkload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Pods is the number of its stale pods
+	Pods int32 `json:"pods"`
+}
+
+// SidecarConfigurationStatus is the drift of the running pods from the config, written by the
+// drift scan of the injector
+type SidecarConfigurationStatus struct {
+	// ConfigHash is the hash of the config the pods were compared with
+	ConfigHash string `json:"configHash,omitempty"`
+	// InjectedPods is the number of running pods with the sidecar, StalePods of those injected
+	// with another config
+	InjectedPods int32 `json:"injectedPods"`
+	StalePods    int32 `json:"stalePods"`
+	// StaleWorkloads are the controllers of the stale pods, the most stale first
+	StaleWorkloads []StaleWorkload `json:"staleWorkloads,omitempty"`
+	// LastScanTime is when the pods were compared
+	LastScanTime *metav1.Time `json:"lastScanTime,omitempty"`
+}
+
+// SidecarConfiguration holds the sidecar template served by the injector
+type SidecarConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarConfigurationSpec   `json:"spec,omitempty"`
+	Status SidecarConfigurationStatus `json:"status,omitempty"`
+}
+
+// SidecarConfigurationList is a list of SidecarConfiguration
+type SidecarConfigurationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SidecarConfiguration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarConfiguration{}, &SidecarConfigurationList{})
+}
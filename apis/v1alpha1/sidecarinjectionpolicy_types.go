@@ -0,0 +1,48 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InjectionPolicy is the injection decision for pods without the inject annotation
+type InjectionPolicy string
+
+// constant values for InjectionPolicy
+const (
+	InjectionPolicyEnabled  InjectionPolicy = "Enabled"
+	InjectionPolicyDisabled InjectionPolicy = "Disabled"
+)
+
+// SidecarInjectionPolicySpec declares which pods of the cluster get the sidecar
+type SidecarInjectionPolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to, all namespaces if empty
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ExcludedNamespaces are never injected, whatever the pod annotations say
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// ExcludedPodSelector selects pods that are never injected
+	ExcludedPodSelector *metav1.LabelSelector `json:"excludedPodSelector,omitempty"`
+	// Profile is the sidecar profile injected into the matching pods
+	Profile string `json:"profile,omitempty"`
+	// DefaultPolicy applies to matching pods without the inject annotation
+	DefaultPolicy InjectionPolicy `json:"defaultPolicy,omitempty"`
+}
+
+// SidecarInjectionPolicy is a cluster-wide injection rule
+type SidecarInjectionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SidecarInjectionPolicySpec `json:"spec,omitempty"`
+}
+
+// SidecarInjectionPolicyList is a list of SidecarInjectionPolicy
+type SidecarInjectionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SidecarInjectionPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarInjectionPolicy{}, &SidecarInjectionPolicyList{})
+}
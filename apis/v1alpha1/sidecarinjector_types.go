@@ -0,0 +1,75 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespaceInjections counts the admissions of the objects of a namespace since the replicas
+// started
+type NamespaceInjections struct {
+	Namespace string `json:"namespace"`
+	Injected  int64  `json:"injected"`
+	Skipped   int64  `json:"skipped"`
+	Errors    int64  `json:"errors"`
+}
+
+// InjectorReplica is the state of a replica of the injector, written by the replica itself
+type InjectorReplica struct {
+	// Name is the name of the pod of the replica
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	ConfigHash string `json:"configHash,omitempty"`
+	// StagedConfigHash is the config loaded but held back until all the replicas loaded it
+	StagedConfigHash string `json:"stagedConfigHash,omitempty"`
+	// Ready tells whether the replica serves the webhook, NotReady lists the failed checks otherwise
+	Ready    bool     `json:"ready"`
+	NotReady []string `json:"notReady,omitempty"`
+	// Leader tells whether the replica runs the controllers
+	Leader bool `json:"leader,omitempty"`
+	// ConfigError is why the last load of the config failed, empty if it succeeded
+	ConfigError string `json:"configError,omitempty"`
+	// Namespaces are the admissions answered by the replica
+	Namespaces []NamespaceInjections `json:"namespaces,omitempty"`
+	// Errors counts the failed mutations of the replica by status reason, such as Invalid
+	Errors map[string]int64 `json:"errors,omitempty"`
+	// LastUpdateTime is when the replica wrote its state, the replicas not updated for a while
+	// are dropped
+	LastUpdateTime metav1.Time `json:"lastUpdateTime"`
+}
+
+// SidecarInjectorStatus is the health of the injector of a cluster, the sum of its replicas
+type SidecarInjectorStatus struct {
+	// Replicas is the number of replicas reporting, ReadyReplicas of those serving the webhook
+	Replicas      int32 `json:"replicas"`
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// Versions and ConfigHashes are those of the replicas, more than one while they roll out
+	Versions     []string `json:"versions,omitempty"`
+	ConfigHashes []string `json:"configHashes,omitempty"`
+	// Namespaces are the admissions answered by all the replicas
+	Namespaces []NamespaceInjections `json:"namespaces,omitempty"`
+	// Errors counts the failed mutations of all the replicas by status reason
+	Errors map[string]int64 `json:"errors,omitempty"`
+	// ReplicaStatuses are the replicas, by name
+	ReplicaStatuses []InjectorReplica `json:"replicaStatuses,omitempty"`
+}
+
+// SidecarInjector is the cluster-scoped resource reporting the state of the injector, for the
+// management planes of several clusters
+type SidecarInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status SidecarInjectorStatus `json:"status,omitempty"`
+}
+
+// SidecarInjectorList is a list of SidecarInjector
+type SidecarInjectorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SidecarInjector `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarInjector{}, &SidecarInjectorList{})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/spf13/cobra"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// benchTarget answers the AdmissionReview body, returning the error of its answer
+type benchTarget func(body []byte) error
+
+// newBenchCommand returns the bench command: it replays the creation of pods against a running
+// injector, or one in the process, and reports the latency of the reviews
+func newBenchCommand() *cobra.Command {
+	var parms webhook.WebHookParameters
+	var pods, injector, namespace, profile, mutators, mutatorPlugins, wasmMutators string
+	var requests, concurrency int
+	var insecureSkipVerify bool
+	var wasmTimeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Replay admission requests and report their latency",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			if pods == "" {
+				return errors.New("bench needs --pods")
+			}
+			if requests <= 0 || concurrency <= 0 {
+				return errors.New("--requests and --concurrency must be positive")
+			}
+			bodies, err := reviewBodies(pods, namespace)
+			if err != nil {
+				return err
+			}
+
+			var target benchTarget
+			inProcess := injector == ""
+			if inProcess {
+				parms.Mutators, parms.MutatorPlugins = splitList(mutators), splitList(mutatorPlugins)
+				wasmNames, err := registerWASMMutators(wasmMutators, wasmTimeout)
+				if err != nil {
+					return err
+				}
+				parms.Mutators = append(parms.Mutators, wasmNames...)
+				if target, err = handlerTarget(parms, profile); err != nil {
+					return err
+				}
+			} else {
+				target = remoteTarget(injector, concurrency, insecureSkipVerify)
+			}
+			return bench(target, bodies, requests, concurrency, inProcess, os.Stdout)
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&pods, "pods", "", "YAML or JSON file of the pods and workloads created, a document or List each.")
+	fs.StringVar(&injector, "injector", "", "URL of the webhook port of a running injector, such as https://127.0.0.1:8443. The reviews are answered in the process if empty.")
+	fs.BoolVar(&insecureSkipVerify, "insecureSkipVerify", false, "Accept any certificate of -injector, such as one for the Service name through a port-forward.")
+	fs.IntVar(&requests, "requests", 1000, "Number of reviews sent, going through the pods in turn.")
+	fs.IntVar(&concurrency, "concurrency", 10, "Number of reviews in flight.")
+	fs.StringVarP(&namespace, "namespace", "n", "default", "Namespace of the pods without one.")
+	fs.StringVar(&parms.SidecarConfigFile, "sidecarCfgFile", "/etc/webhook/mesher/config/sidecarconfig.yaml", "Config of the injector in the process, file or directory.")
+	fs.StringVar(&parms.SidecarValuesFile, "sidecarValuesFile", "", "Values file rendering -sidecarCfgFile as a template.")
+	fs.StringVar(&profile, "profile", "", "Sidecar profile injected in the process, the base sidecar if empty.")
+	fs.StringVar(&mutators, "mutators", "", "Comma separated mutators of the injector in the process.")
+	fs.StringVar(&mutatorPlugins, "mutatorPlugins", "", "Comma separated Go plugins registering mutators.")
+	fs.StringVar(&wasmMutators, "wasmMutators", "", "Comma separated WASM modules run as mutators after those of -mutators.")
+	fs.DurationVar(&wasmTimeout, "wasmTimeout", time.Second, "Time a WASM mutator has to answer for a pod.")
+	return cmd
+}
+
+// reviewBodies returns the encoded AdmissionReviews of the creation of the objects of file, in
+// namespace unless they have one
+func reviewBodies(file, namespace string) ([][]byte, error) {
+	objects, err := readObjects(file)
+	if err != nil {
+		return nil, err
+	}
+	var bodies [][]byte
+	for _, object := range objects {
+		var list struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(object, &list); err != nil {
+			return nil, err
+		}
+		items := [][]byte{object}
+		if strings.HasSuffix(list.Kind, "List") {
+			items = items[:0]
+			for _, item := range list.Items {
+				items = append(items, item)
+			}
+		}
+		for _, item := range items {
+			review, err := webhook.NewReview(item, namespace)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			body, err := json.Marshal(review)
+			if err != nil {
+				return nil, err
+			}
+			bodies = append(bodies, body)
+		}
+	}
+	if len(bodies) == 0 {
+		return nil, fmt.Errorf("%s has no pod", file)
+	}
+	return bodies, nil
+}
+
+// handlerTarget returns the webhook handler of an injector in the process, injecting all the pods
+// not annotated otherwise with profile
+func handlerTarget(parms webhook.WebHookParameters, profile string) (benchTarget, error) {
+	parms.MaxRequestBytes, parms.RequestTimeout = webhook.DefaultMaxRequestBytes, 10*time.Second
+	wh, err := webhook.NewReplayer(parms, webhook.NewFileSource(parms.SidecarConfigFile, parms.SidecarValuesFile, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the sidecar config: %v", err)
+	}
+	if wh.Policies, err = offlinePolicies(profile, true); err != nil {
+		return nil, err
+	}
+	handler := wh.Handler()
+	return func(body []byte) error {
+		req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return checkAnswer(rec.Code, rec.Body.Bytes())
+	}, nil
+}
+
+// remoteTarget returns the /mutate endpoint of the injector of URL injector
+func remoteTarget(injector string, concurrency int, insecureSkipVerify bool) benchTarget {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+			MaxIdleConnsPerHost: concurrency,
+		},
+	}
+	endpoint := strings.TrimSuffix(injector, "/") + "/mutate"
+	return func(body []byte) error {
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		answer, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return checkAnswer(resp.StatusCode, answer)
+	}
+}
+
+// checkAnswer returns why the answer of a review, of HTTP status status, is a failure if it is one
+func checkAnswer(status int, body []byte) error {
+	if status != http.StatusOK {
+		return fmt.Errorf("answered %d: %s", status, strings.TrimSpace(string(body)))
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil {
+		return err
+	}
+	if review.Response == nil {
+		return errors.New("answered without response")
+	}
+	if !review.Response.Allowed {
+		message := ""
+		if review.Response.Result != nil {
+			message = review.Response.Result.Message
+		}
+		return fmt.Errorf("denied: %s", message)
+	}
+	return nil
+}
+
+// bench sends requests reviews of bodies in turn to target, concurrency at a time, and writes the
+// report to w. The allocations are reported for a target in the process. It fails if a review did.
+func bench(target benchTarget, bodies [][]byte, requests, concurrency int, inProcess bool, w io.Writer) error {
+	latencies := make([]time.Duration, requests)
+	var next, failed int64 = -1, 0
+	var firstErr error
+	var once sync.Once
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if n >= int64(requests) {
+					return
+				}
+				sent := time.Now()
+				err := target(bodies[n%int64(len(bodies))])
+				latencies[n] = time.Since(sent)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					once.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Fprintf(w, "requests:    %d, %d failed\n", requests, failed)
+	fmt.Fprintf(w, "concurrency: %d\n", concurrency)
+	fmt.Fprintf(w, "duration:    %v, %.1f requests/s\n", elapsed.Round(time.Millisecond), float64(requests)/elapsed.Seconds())
+	fmt.Fprintf(w, "latency:     p50 %v, p95 %v, p99 %v, max %v\n",
+		percentile(latencies, 0.5), percentile(latencies, 0.95), percentile(latencies, 0.99), percentile(latencies, 1))
+	if inProcess {
+		fmt.Fprintf(w, "allocations: %d allocs/op, %d B/op\n",
+			(after.Mallocs-before.Mallocs)/uint64(requests), (after.TotalAlloc-before.TotalAlloc)/uint64(requests))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reviews failed, the first one: %v", failed, requests, firstErr)
+	}
+	return nil
+}
+
+// percentile returns the latency under which the share p of the sorted latencies are
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
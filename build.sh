@@ -14,7 +14,14 @@ BUILD_PATH=$(cd $(dirname $0);pwd)
 echo $BUILD_PATH
 cd $BUILD_PATH
 
-CGO_ENABLED=0 GO_EXTLINK_ENABLED=0 go build --ldflags '-s -w -extldflags "-static"' -a -o $appname
+VERSION=${VERSION:-latest}
+GIT_COMMIT=${GIT_COMMIT:-$(git rev-parse HEAD 2>/dev/null || echo unknown)}
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+# e.g. GO_TAGS="wazero jsoniter" for the WASM mutators and the faster JSON
+GO_TAGS=${GO_TAGS:-}
+
+VERSION_PKG=github.com/go-chassis/sidecar-injector/version
+CGO_ENABLED=0 GO_EXTLINK_ENABLED=0 go build --ldflags "-s -w -extldflags \"-static\" -X ${VERSION_PKG}.Version=${VERSION} -X ${VERSION_PKG}.GitCommit=${GIT_COMMIT} -X ${VERSION_PKG}.BuildDate=${BUILD_DATE}" -tags "${GO_TAGS}" -a -o $appname
 
 cp $appname build/; cd $BUILD_PATH/build
 
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/go-chassis/sidecar-injector/version"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix prefixes the env variables setting the flags
+const envPrefix = "SIDECAR_INJECTOR"
+
+// newRootCommand returns the sidecar-injector command and its subcommands
+func newRootCommand() *cobra.Command {
+	var configFile string
+	root := &cobra.Command{
+		Use:   "sidecar-injector",
+		Short: "Kubernetes admission webhook injecting the mesher sidecar",
+		// the commands print their own errors, the usage only follows the flag errors
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if configFile == "" {
+				configFile = os.Getenv(envPrefix + "_CONFIG_FILE")
+			}
+			return bindFlags(cmd.Flags(), configFile)
+		},
+	}
+	root.PersistentFlags().StringVar(&configFile, "configFile", "", "YAML or JSON file setting the flags of the command by name, below the env variables and the command line.")
+	// such as the -kubeconfig of controller-runtime
+	root.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+	root.AddCommand(
+		newServeCommand(),
+		newInjectCommand(),
+		newValidateCommand(),
+		newDescribeCommand(),
+		newBenchCommand(),
+		newVersionCommand(),
+		newGenCertsCommand(),
+		newGenWebhookConfigCommand(),
+	)
+	return root
+}
+
+// newVersionCommand returns the version command, printing the build of the binary
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of the build",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("version: %s\ngitCommit: %s\nbuildDate: %s\ngoVersion: %s\n",
+				version.Version, version.GitCommit, version.BuildDate, version.GoVersion)
+		},
+	}
+}
+
+// bindFlags sets the flags of fs not given on the command line from their env variable, else
+// from configFile when not empty. The command line wins over the env, the env over the file, the
+// file over the defaults.
+func bindFlags(fs *pflag.FlagSet, configFile string) error {
+	v := viper.New()
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read %s: %v", configFile, err)
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		if bindErr := v.BindEnv(f.Name, envName(f.Name)); bindErr != nil {
+			err = bindErr
+			return
+		}
+		if !v.IsSet(f.Name) {
+			return
+		}
+		value := v.Get(f.Name)
+		// the lists of the file are the comma separated values of the flags
+		if list, ok := value.([]interface{}); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			value = strings.Join(items, ",")
+		}
+		if setErr := fs.Set(f.Name, fmt.Sprint(value)); setErr != nil {
+			err = fmt.Errorf("invalid %s: %v", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// envName returns the env variable of the flag name, SIDECAR_INJECTOR_TLS_SNI_CERTS for tlsSNICerts
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	b.WriteRune('_')
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			// a word starts after a lower case letter or digit, or ends an acronym
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// compatArgs returns args, the command line without the program name, as cobra parses it: the
+// flags of the previous releases, such as -port=443, get their second dash, and a command line
+// empty or starting with a flag runs serve, so the existing Deployments keep working
+func compatArgs(args []string) []string {
+	out := make([]string, 0, len(args)+1)
+	for i, arg := range args {
+		if arg == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		name := strings.SplitN(strings.TrimPrefix(arg, "-"), "=", 2)[0]
+		// a single letter is a shorthand, such as -f, a digit a negative value
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(name) > 1 && unicode.IsLetter(rune(name[0])) {
+			arg = "-" + arg
+		}
+		out = append(out, arg)
+	}
+	if len(out) == 0 || strings.HasPrefix(out[0], "-") && out[0] != "-h" && out[0] != "--help" {
+		out = append([]string{"serve"}, out...)
+	}
+	return out
+}
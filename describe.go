@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// pluginName is the name the binary is installed under as the kubectl mesher-inject plugin
+const pluginName = "kubectl-mesher_inject"
+
+// newDescribeCommand returns the describe command: it asks the /check endpoint of a running
+// injector what it would do with a pod of the cluster or the objects of a manifest
+func newDescribeCommand() *cobra.Command {
+	var injector, namespace, file string
+	cmd := &cobra.Command{
+		Use:   "describe [pod/]NAME | -f FILE",
+		Short: "Print the injection decision, profile and patch of a pod or manifest",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			var objects [][]byte
+			var err error
+			switch {
+			case file != "" && len(args) == 0:
+				objects, err = readObjects(file)
+			case file == "" && len(args) == 1:
+				var pod []byte
+				pod, err = fetchPod(namespace, strings.TrimPrefix(args[0], "pod/"))
+				objects = [][]byte{pod}
+			default:
+				return errors.New("describe needs a pod name or -f")
+			}
+			if err != nil {
+				return err
+			}
+			for i, object := range objects {
+				if i > 0 {
+					fmt.Println()
+				}
+				if err := describe(injector, namespace, object, os.Stdout); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&injector, "injector", "http://127.0.0.1:8080", "URL of the admin port of the injector, e.g. through kubectl port-forward.")
+	fs.StringVarP(&namespace, "namespace", "n", "default", "Namespace of the pod, and of the objects of -f without one.")
+	fs.StringVarP(&file, "filename", "f", "", "Manifest to describe, - for the standard input.")
+	return cmd
+}
+
+// readObjects returns the JSON of the YAML documents of file, the standard input if it is -
+func readObjects(file string) ([][]byte, error) {
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	var objects [][]byte
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		object, err := yaml.YAMLToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+}
+
+// fetchPod returns the JSON of the pod name of namespace, as it was created
+func fetchPod(namespace, name string) ([]byte, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pod.APIVersion, pod.Kind = "v1", "Pod"
+	pod.ManagedFields = nil
+	return json.Marshal(pod)
+}
+
+// describe writes to w the check of object by the injector, with its patch as a diff
+func describe(injector, namespace string, object []byte, w io.Writer) error {
+	endpoint := strings.TrimSuffix(injector, "/") + "/check?namespace=" + url.QueryEscape(namespace)
+	resp, err := http.Post(endpoint, "application/json", bytes.NewReader(object))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result webhook.CheckResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s %s/%s\n", result.Kind, result.Namespace, result.Name)
+	if result.Inject {
+		fmt.Fprintf(w, "  Decision: inject, profile %q, config %s\n", result.Profile, result.ConfigHash)
+	} else {
+		fmt.Fprintf(w, "  Decision: skip (%s)\n", result.Reason)
+	}
+	if result.Status != "" {
+		fmt.Fprintf(w, "  Status:   %s\n", result.Status)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "  Warning:  %s\n", warning)
+	}
+	if len(result.Patch) == 0 {
+		return nil
+	}
+
+	patch, err := jsonpatch.DecodePatch(result.Patch)
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(object)
+	if err != nil {
+		return err
+	}
+	before, err := yaml.JSONToYAML(object)
+	if err != nil {
+		return err
+	}
+	after, err := yaml.JSONToYAML(patched)
+	if err != nil {
+		return err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(before)),
+		B:        difflib.SplitLines(string(after)),
+		FromFile: result.Name,
+		ToFile:   result.Name + " (injected)",
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "  Patch:\n%s", diff)
+	return nil
+}
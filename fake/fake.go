@@ -0,0 +1,64 @@
+// Package fake runs an injector in memory on plain HTTP, with the endpoints and behavior of the
+// real one, for the end to end tests of its clients without a cluster.
+package fake
+
+import (
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/policy"
+	"github.com/go-chassis/sidecar-injector/webhook"
+)
+
+// Server is an injector served by httptest
+type Server struct {
+	// URL is the base URL of the webhook endpoints, /mutate, /validate, /-/reload...
+	URL string
+	// AdminURL is the base URL of the admin endpoints, /check, /readyz, /metrics...
+	AdminURL string
+
+	webhook *webhook.WebHookServer
+	source  *webhook.StaticSource
+	servers []*httptest.Server
+}
+
+// NewServer starts an injector with the parameters p, serving the sidecar config, as it would be in
+// the ConfigMap, and deciding with policies, by the annotations of the pods if nil. The TLS, ports
+// and access log of p are ignored. An invalid config is served as by the real injector: the
+// server is not ready and denies the mutations.
+func NewServer(p webhook.WebHookParameters, config []byte, policies *policy.Index) (*Server, error) {
+	// the servers of the webhook are never started, httptest serves its handlers
+	p.DisableTLS, p.InsecurePort, p.AdminPort, p.AccessLogFile = true, 1, 0, ""
+	if p.MaxRequestBytes == 0 {
+		p.MaxRequestBytes = webhook.DefaultMaxRequestBytes
+	}
+	if p.RequestTimeout == 0 {
+		p.RequestTimeout = 10 * time.Second
+	}
+	source := webhook.NewStaticSource(config)
+	wh, err := webhook.NewWebhook(p, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	wh.Policies = policies
+
+	s := &Server{webhook: wh, source: source}
+	s.servers = []*httptest.Server{httptest.NewServer(wh.Handler()), httptest.NewServer(wh.AdminHandler())}
+	s.URL, s.AdminURL = s.servers[0].URL, s.servers[1].URL
+	return s, nil
+}
+
+// SetConfig replaces the sidecar config and reloads it, as a change of the ConfigMap would. It
+// returns why config is invalid, the previous one is still served then.
+func (s *Server) SetConfig(config []byte) error {
+	// the source has no watcher, the reload reports the error of the config
+	_ = s.source.Set(config)
+	return s.webhook.Reload()
+}
+
+// Close stops the servers, once the requests in progress are answered
+func (s *Server) Close() {
+	for _, server := range s.servers {
+		server.Close()
+	}
+}
@@ -0,0 +1,126 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chassis/sidecar-injector/webhook"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// config returns a sidecar config with the sidecar image image
+func config(image string) []byte {
+	return []byte(`apiVersion: injector.mesher.io/v1
+kind: SidecarConfig
+containers:
+- name: mesher
+  image: ` + image + `
+`)
+}
+
+// invalidConfig is rejected by the injector, its container has no name
+var invalidConfig = []byte(`apiVersion: injector.mesher.io/v1
+kind: SidecarConfig
+containers:
+- image: mesher:latest
+`)
+
+// pod is a pod asking for the sidecar
+const pod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web","annotations":{"sidecar-injector-mesher.io/inject":"yes"}},
+"spec":{"containers":[{"name":"web","image":"web:1.0"}]}}`
+
+// mutate returns the answer of s to the creation of pod
+func mutate(t *testing.T, s *Server) *admissionv1.AdmissionResponse {
+	t.Helper()
+	review, err := webhook.NewReview([]byte(pod), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(s.URL+"/mutate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/mutate answered %s", resp.Status)
+	}
+	var answered admissionv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&answered); err != nil {
+		t.Fatal(err)
+	}
+	if answered.Response == nil || answered.Response.UID != review.Request.UID {
+		t.Fatalf("/mutate answered %+v, expected the response to the request", answered)
+	}
+	return answered.Response
+}
+
+// status returns the status code of a GET of url
+func status(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServerMutates(t *testing.T) {
+	s, err := NewServer(webhook.WebHookParameters{}, config("mesher:1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if code := status(t, s.AdminURL+"/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz answered %d with a valid config", code)
+	}
+	resp := mutate(t, s)
+	if !resp.Allowed || !strings.Contains(string(resp.Patch), "mesher:1") {
+		t.Errorf("expected the sidecar mesher:1 injected, got %+v", resp)
+	}
+}
+
+func TestServerInvalidConfig(t *testing.T) {
+	s, err := NewServer(webhook.WebHookParameters{}, invalidConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if code := status(t, s.AdminURL+"/readyz"); code == http.StatusOK {
+		t.Error("/readyz answered 200 without a valid config")
+	}
+	if resp := mutate(t, s); resp.Allowed {
+		t.Errorf("expected the mutation denied without a valid config, got %+v", resp)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	s, err := NewServer(webhook.WebHookParameters{}, config("mesher:1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.SetConfig(invalidConfig); err == nil {
+		t.Error("SetConfig accepted an invalid config")
+	}
+	if resp := mutate(t, s); !strings.Contains(string(resp.Patch), "mesher:1") {
+		t.Errorf("expected the previous config still served, got %+v", resp)
+	}
+
+	if err := s.SetConfig(config("mesher:2")); err != nil {
+		t.Fatal(err)
+	}
+	if resp := mutate(t, s); !strings.Contains(string(resp.Patch), "mesher:2") {
+		t.Errorf("expected the new config served, got %+v", resp)
+	}
+}
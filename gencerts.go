@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/spf13/cobra"
+)
+
+// newGenCertsCommand returns the gen-certs command: it writes a self-signed CA and the serving key
+// pair of the webhook Service to -outDir, and prints the base64 caBundle of the webhook configurations
+func newGenCertsCommand() *cobra.Command {
+	var service, namespace, outDir string
+	var validity time.Duration
+	cmd := &cobra.Command{
+		Use:   "gen-certs",
+		Short: "Issue the certificates of the webhook",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			ca, err := writeCerts(outDir, service, namespace, validity)
+			if err != nil {
+				return fmt.Errorf("failed to issue the certificates: %v", err)
+			}
+			// the caBundle alone on the standard output, for CA_BUNDLE=$(sidecar-injector gen-certs)
+			fmt.Println(base64.StdEncoding.EncodeToString(ca))
+			return nil
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&service, "service", "sidecar-injector-webhook-mesher-svc", "Service of the webhook the certificate is valid for.")
+	fs.StringVar(&namespace, "namespace", "chassis", "Namespace of -service.")
+	fs.StringVar(&outDir, "outDir", ".", "Directory receiving ca.crt, and cert.pem and key.pem as -tlsCertFile and -tlsKeyFile expect them.")
+	fs.DurationVar(&validity, "validity", webhook.DefaultCertValidity, "Validity of the certificates.")
+	return cmd
+}
+
+// writeCerts issues the CA and the serving key pair of service in namespace into dir, as ca.crt,
+// cert.pem and key.pem. It returns the PEM of the CA.
+func writeCerts(dir, service, namespace string, validity time.Duration) ([]byte, error) {
+	ca, cert, key, err := webhook.IssueCerts(service, namespace, validity)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"ca.crt", ca, 0644},
+		{"cert.pem", cert, 0644},
+		{"key.pem", key, 0600},
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f.name), f.data, f.mode); err != nil {
+			return nil, err
+		}
+	}
+	return ca, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// newGenWebhookConfigCommand returns the gen-webhook-config command: it prints the
+// MutatingWebhookConfiguration of the injector, and its ValidatingWebhookConfiguration when named,
+// ready to apply
+func newGenWebhookConfigCommand() *cobra.Command {
+	var reg registrationFlags
+	var service, namespace, mutating, validating, certsDir string
+	var port int
+	var validity time.Duration
+	cmd := &cobra.Command{
+		Use:   "gen-webhook-config",
+		Short: "Print the webhook configurations of the injector",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			if certsDir != "" && reg.caBundleFile != "" {
+				return errors.New("certsDir and caBundleFile are exclusive")
+			}
+			spec, err := reg.spec(mutating, service, namespace, int32(port))
+			if err != nil {
+				return err
+			}
+			if certsDir != "" {
+				if spec.CABundle, err = writeCerts(certsDir, service, namespace, validity); err != nil {
+					return fmt.Errorf("failed to issue the certificates: %v", err)
+				}
+			}
+
+			objects := []interface{}{spec.MutatingWebhookConfiguration()}
+			if validating != "" {
+				objects = append(objects, spec.ValidatingWebhookConfiguration(validating, "validation.sidecar-injector.mesher.io", "/validate"))
+			}
+			for i, o := range objects {
+				data, err := yaml.Marshal(o)
+				if err != nil {
+					return err
+				}
+				if i > 0 {
+					fmt.Println("---")
+				}
+				os.Stdout.Write(data)
+			}
+			return nil
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&service, "service", "sidecar-injector-webhook-mesher-svc", "Service of the webhook.")
+	fs.StringVar(&namespace, "namespace", "chassis", "Namespace of -service.")
+	fs.IntVar(&port, "port", 443, "Port of -service the webhook is served on.")
+	fs.StringVar(&mutating, "mutatingWebhookConfiguration", "sidecar-injector-webhook-mesher-cfg", "Name of the MutatingWebhookConfiguration.")
+	fs.StringVar(&validating, "validatingWebhookConfiguration", "", "Name of the ValidatingWebhookConfiguration checking the injected objects at /validate, none if empty.")
+	fs.StringVar(&certsDir, "certsDir", "", "Issue the certificates of -service as gen-certs does into this directory, and use their CA, instead of -caBundleFile.")
+	fs.DurationVar(&validity, "validity", webhook.DefaultCertValidity, "Validity of the certificates issued into -certsDir.")
+	reg.addFlags(fs)
+	return cmd
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	"github.com/go-chassis/sidecar-injector/policy"
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/spf13/cobra"
+)
+
+// newInjectCommand returns the inject command: it prints the manifest of -f with the sidecar
+// injected, as the webhook would do
+func newInjectCommand() *cobra.Command {
+	var parms webhook.WebHookParameters
+	var file, namespace, profile, output, prefix, aliases, mutators, mutatorPlugins, wasmMutators string
+	var all, remove bool
+	var wasmTimeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "inject",
+		Short: "Print a manifest with the sidecar injected",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			format, err := webhook.ParseOutputFormat(output)
+			if err != nil {
+				return err
+			}
+			if err := webhook.SetAnnotationPrefix(prefix); err != nil {
+				return err
+			}
+			if parms.AnnotationAliases, err = splitPairs(aliases); err != nil {
+				return err
+			}
+			parms.Mutators, parms.MutatorPlugins = splitList(mutators), splitList(mutatorPlugins)
+			wasmNames, err := registerWASMMutators(wasmMutators, wasmTimeout)
+			if err != nil {
+				return err
+			}
+			parms.Mutators = append(parms.Mutators, wasmNames...)
+			return inject(parms, file, namespace, profile, format, all, remove)
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVarP(&file, "filename", "f", "-", "Manifest to inject, - for the standard input.")
+	fs.StringVar(&parms.SidecarConfigFile, "sidecarCfgFile", "/etc/webhook/mesher/config/sidecarconfig.yaml", "File containing the configuration, or directory whose *.yaml and *.json files are merged in name order.")
+	fs.StringVar(&parms.SidecarValuesFile, "sidecarValuesFile", "", "Values file rendering -sidecarCfgFile as a template.")
+	fs.StringVar(&namespace, "namespace", "default", "Namespace of the objects without one.")
+	fs.StringVar(&profile, "profile", "", "Sidecar profile injected, the base sidecar if empty.")
+	fs.BoolVar(&all, "all", true, "Inject the objects without inject annotation, as a namespace where injection is enabled by default. Those annotated \"no\" are left alone.")
+	fs.StringVarP(&output, "output", "o", string(webhook.OutputManifest), "Output of the objects: manifest, patch for their strategic merge patches, or apply for the apply configurations of the fields set. The patches leave the other objects out.")
+	fs.BoolVar(&parms.CNIEnabled, "cni", false, "Inject for the mesh CNI plugin, without the init containers of the sidecar, as -cniEnabled of serve.")
+	fs.BoolVar(&remove, "remove", false, "Remove the sidecar of the config from the objects instead, whatever their annotations, so they leave the mesh.")
+	fs.StringVar(&prefix, "annotationPrefix", webhook.DefaultAnnotationPrefix, "Prefix of the annotation keys of the injector, as -annotationPrefix of serve.")
+	fs.StringVar(&aliases, "annotationAliases", "", "Comma separated alias=key annotation keys honored as the keys of the injector, as -annotationAliases of serve.")
+	fs.StringVar(&mutators, "mutators", "", "Comma separated mutators adding their operations after the sidecar, as -mutators of serve.")
+	fs.StringVar(&mutatorPlugins, "mutatorPlugins", "", "Comma separated Go plugins registering mutators.")
+	fs.StringVar(&wasmMutators, "wasmMutators", "", "Comma separated WASM modules run as mutators after those of -mutators.")
+	fs.DurationVar(&wasmTimeout, "wasmTimeout", time.Second, "Time a WASM mutator has to answer for a pod.")
+	return cmd
+}
+
+// inject writes file, the standard input if it is -, with the sidecar injected to the standard
+// output in format, or removed if remove
+func inject(parms webhook.WebHookParameters, file, namespace, profile string, format webhook.OutputFormat, all, remove bool) error {
+	source := webhook.NewFileSource(parms.SidecarConfigFile, parms.SidecarValuesFile, 0)
+	wh, err := webhook.NewReplayer(parms, source)
+	if err != nil {
+		return fmt.Errorf("failed to load the sidecar config: %v", err)
+	}
+	if wh.Policies, err = offlinePolicies(profile, all); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if remove {
+		if err := wh.Uninject(in, os.Stdout, namespace, format); err != nil {
+			return fmt.Errorf("failed to remove the sidecar from %s: %v", file, err)
+		}
+		return nil
+	}
+	if err := wh.Inject(in, os.Stdout, namespace, format); err != nil {
+		return fmt.Errorf("failed to inject %s: %v", file, err)
+	}
+	return nil
+}
+
+// offlinePolicies returns the policies standing for the defaults of the namespaces, not known
+// offline: the objects get the sidecar of profile if all, else only when annotated
+func offlinePolicies(profile string, all bool) (*policy.Index, error) {
+	spec := v1alpha1.SidecarInjectionPolicySpec{Profile: profile, DefaultPolicy: v1alpha1.InjectionPolicyDisabled}
+	if all {
+		spec.DefaultPolicy = v1alpha1.InjectionPolicyEnabled
+	}
+	index := policy.NewIndex()
+	if err := index.SetPolicy("inject", spec); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
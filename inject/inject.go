@@ -0,0 +1,203 @@
+// Package inject is the core of the sidecar injection: the decision and the JSON patch adding a
+// sidecar to a pod, without the admission webhook around them
+package inject
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Operation is a JSON patch operation
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Decision tells whether a pod gets the sidecar, and which profile of it
+type Decision struct {
+	Inject  bool
+	Profile string
+	// Reason is why the sidecar is not injected, such as annotation_off
+	Reason string
+	// Warnings tell the user why a pod asking for the sidecar doesn't get it
+	Warnings []string
+}
+
+// Injector decides the injection of the pods and patches them, the way the webhook does. Other
+// controllers embed it to inject without calling the webhook.
+type Injector interface {
+	// Decide returns the decision about pod
+	Decide(pod *corev1.Pod) Decision
+	// Patch returns the operations injecting the sidecar in pod, none if it is not injected
+	Patch(pod *corev1.Pod) ([]Operation, error)
+}
+
+// Sidecar is what is added to a pod
+type Sidecar struct {
+	Containers       []corev1.Container
+	InitContainers   []corev1.Container
+	Volumes          []corev1.Volume
+	ImagePullSecrets []corev1.LocalObjectReference
+	// Annotations are set on the pod, such as its injection status, and Labels too
+	Annotations map[string]string
+	Labels      map[string]string
+}
+
+// Patch returns the operations adding s to pod, found at prefix in the patched object, e.g. the
+// pod template of a workload. It is idempotent so the webhook can be reinvoked: what the pod
+// already has is left alone.
+func Patch(pod *corev1.Pod, prefix string, s Sidecar) []Operation {
+	// an operation at most per item of the sidecar, the slice is allocated once
+	p := make([]Operation, 0, len(s.Containers)+len(s.InitContainers)+len(s.Volumes)+len(s.ImagePullSecrets)+len(s.Annotations)+len(s.Labels))
+	p = insertContainer(p, pod.Spec.Containers, s.Containers, prefix+"/spec/containers")
+	p = insertContainer(p, pod.Spec.InitContainers, s.InitContainers, prefix+"/spec/initContainers")
+	p = insertVolume(p, pod.Spec.Volumes, s.Volumes, prefix+"/spec/volumes")
+	p = insertImagePullSecrets(p, pod.Spec.ImagePullSecrets, s.ImagePullSecrets, prefix+"/spec/imagePullSecrets")
+	p = append(p, Annotations(pod.Annotations, s.Annotations, prefix+"/metadata/annotations")...)
+	return append(p, Annotations(pod.Labels, s.Labels, prefix+"/metadata/labels")...)
+}
+
+// HasContainer tells whether containers has one named name
+func HasContainer(containers []corev1.Container, name string) bool {
+	for _, c := range containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSecret(secrets []corev1.LocalObjectReference, name string) bool {
+	for _, s := range secrets {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// insertContainer appends to p the operations adding the containers of add missing from dest, by name
+func insertContainer(p []Operation, dest, add []corev1.Container, path string) []Operation {
+	f := len(dest) == 0
+	var val interface{}
+	for _, add := range add {
+		// reinvoked after other webhooks, the pod may already have it
+		if HasContainer(dest, add.Name) {
+			continue
+		}
+		val = add
+		path := path
+		if f {
+			f = false
+			val = []corev1.Container{add}
+		} else {
+			path = path + "/-"
+		}
+		p = append(p, Operation{
+			Op:    "add",
+			Path:  path,
+			Value: val,
+		})
+	}
+	return p
+}
+
+// insertVolume appends to p the operations adding the volumes of add missing from dest, by name
+func insertVolume(p []Operation, dest, add []corev1.Volume, path string) []Operation {
+	f := len(dest) == 0
+	var val interface{}
+	for _, add := range add {
+		// reinvoked after other webhooks, the pod may already have it
+		if hasVolume(dest, add.Name) {
+			continue
+		}
+		val = add
+		path := path
+		if f {
+			f = false
+			val = []corev1.Volume{add}
+		} else {
+			path = path + "/-"
+		}
+		p = append(p, Operation{
+			Op:    "add",
+			Path:  path,
+			Value: val,
+		})
+	}
+	return p
+}
+
+// insertImagePullSecrets appends to p the operations adding the secrets of add missing from dest
+func insertImagePullSecrets(p []Operation, dest, add []corev1.LocalObjectReference, path string) []Operation {
+	f := len(dest) == 0
+	var val interface{}
+	for _, add := range add {
+		// reinvoked after other webhooks, the pod may already have it
+		if hasSecret(dest, add.Name) {
+			continue
+		}
+		val = add
+		path := path
+		if f {
+			f = false
+			val = []corev1.LocalObjectReference{add}
+		} else {
+			path = path + "/-"
+		}
+		p = append(p, Operation{
+			Op:    "add",
+			Path:  path,
+			Value: val,
+		})
+	}
+	return p
+}
+
+// pointerEscaper escapes the reference tokens of a JSON pointer, RFC 6901
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// EscapePointer returns key escaped as a reference token of a JSON pointer, such as an annotation
+// key holding a /
+func EscapePointer(key string) string {
+	return pointerEscaper.Replace(key)
+}
+
+// Annotations returns the operations setting the annotations add in dest, the annotations at
+// path, in the order of their keys. The other annotations of dest are kept. It sets labels the
+// same way.
+func Annotations(dest map[string]string, add map[string]string, path string) []Operation {
+	if len(add) == 0 {
+		return nil
+	}
+	if len(dest) == 0 {
+		// there may be no object to add the members to, it is added whole
+		return []Operation{{Op: "add", Path: path, Value: add}}
+	}
+	keys := make([]string, 0, len(add))
+	for key := range add {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	p := make([]Operation, 0, len(add))
+	for _, key := range keys {
+		op := "add"
+		if _, ok := dest[key]; ok {
+			op = "replace"
+		}
+		p = append(p, Operation{Op: op, Path: path + "/" + EscapePointer(key), Value: add[key]})
+	}
+	return p
+}
@@ -0,0 +1,195 @@
+package inject
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEscapePointer(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "app", want: "app"},
+		{key: "sidecar-injector-mesher.io/status", want: "sidecar-injector-mesher.io~1status"},
+		{key: "a~b", want: "a~0b"},
+		// ~ is escaped first, or ~1 would read back as /
+		{key: "~1", want: "~01"},
+		{key: "/~/", want: "~1~0~1"},
+		{key: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := EscapePointer(tt.key); got != tt.want {
+			t.Errorf("EscapePointer(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		name string
+		dest map[string]string
+		add  map[string]string
+		path string
+		want []Operation
+	}{
+		{
+			name: "nothing to add",
+			dest: map[string]string{"a": "1"},
+			path: "/metadata/annotations",
+		},
+		{
+			name: "no annotations, the map is added whole",
+			add:  map[string]string{"mesher.io/status": "injected", "a~b": "1"},
+			path: "/metadata/annotations",
+			want: []Operation{{Op: "add", Path: "/metadata/annotations", Value: map[string]string{"mesher.io/status": "injected", "a~b": "1"}}},
+		},
+		{
+			name: "empty annotations, the map is replaced whole",
+			dest: map[string]string{},
+			add:  map[string]string{"mesher.io/status": "injected"},
+			path: "/metadata/annotations",
+			want: []Operation{{Op: "add", Path: "/metadata/annotations", Value: map[string]string{"mesher.io/status": "injected"}}},
+		},
+		{
+			name: "keys escaped, in order",
+			dest: map[string]string{"app": "web"},
+			add:  map[string]string{"mesher.io/status": "injected", "a~b": "1", "x/~y": "2"},
+			path: "/metadata/annotations",
+			want: []Operation{
+				{Op: "add", Path: "/metadata/annotations/a~0b", Value: "1"},
+				{Op: "add", Path: "/metadata/annotations/mesher.io~1status", Value: "injected"},
+				{Op: "add", Path: "/metadata/annotations/x~1~0y", Value: "2"},
+			},
+		},
+		{
+			name: "existing keys replaced",
+			dest: map[string]string{"mesher.io/status": "skipped", "a~b": "0"},
+			add:  map[string]string{"mesher.io/status": "injected", "a~b": "1"},
+			path: "/metadata/annotations",
+			want: []Operation{
+				{Op: "replace", Path: "/metadata/annotations/a~0b", Value: "1"},
+				{Op: "replace", Path: "/metadata/annotations/mesher.io~1status", Value: "injected"},
+			},
+		},
+		{
+			name: "labels of a pod template",
+			dest: map[string]string{"app": "web"},
+			add:  map[string]string{"mesher.io/injected": "true"},
+			path: "/spec/template/metadata/labels",
+			want: []Operation{{Op: "add", Path: "/spec/template/metadata/labels/mesher.io~1injected", Value: "true"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Annotations(tt.dest, tt.add, tt.path)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Annotations() = %+v, want %+v", got, tt.want)
+			}
+			if len(got) == 0 || tt.path != "/metadata/annotations" {
+				return
+			}
+
+			// the patch applied to the pod gives the keys as they are
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: tt.dest}}
+			patched := applyPatch(t, pod, got)
+			want := map[string]string{}
+			for k, v := range tt.dest {
+				want[k] = v
+			}
+			for k, v := range tt.add {
+				want[k] = v
+			}
+			if len(want) == 0 {
+				want = nil
+			}
+			if !reflect.DeepEqual(patched.Annotations, want) {
+				t.Errorf("patched annotations = %v, want %v", patched.Annotations, want)
+			}
+		})
+	}
+}
+
+// applyPatch returns pod patched with ops
+func applyPatch(t *testing.T, pod *corev1.Pod, ops []Operation) *corev1.Pod {
+	t.Helper()
+	doc, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := jsonpatch.DecodePatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, err = patch.Apply(doc); err != nil {
+		t.Fatalf("can't apply %s: %v", data, err)
+	}
+	var patched corev1.Pod
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		t.Fatal(err)
+	}
+	return &patched
+}
+
+// benchPod is a pod of a usual application, with a container, a volume and annotations
+func benchPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{"prometheus.io/scrape": "true"},
+			Labels:      map[string]string{"app": "web"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "web:1.0"}},
+			Volumes:    []corev1.Volume{{Name: "data"}},
+		},
+	}
+}
+
+// benchSidecar is a sidecar as the configs usually have, with an init container redirecting the traffic
+func benchSidecar() Sidecar {
+	return Sidecar{
+		Containers: []corev1.Container{{
+			Name:  "mesher",
+			Image: "mesher:latest",
+			Env:   []corev1.EnvVar{{Name: "CSE_REGISTRY_ADDR", Value: "http://registry:30100"}},
+		}},
+		InitContainers:   []corev1.Container{{Name: "mesher-init", Image: "mesher-init:latest"}},
+		Volumes:          []corev1.Volume{{Name: "mesher-conf"}},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+		Annotations:      map[string]string{"sidecar-injector-mesher.io/status": "injected"},
+		Labels:           map[string]string{"mesher.io/injected": "true"},
+	}
+}
+
+func BenchmarkPatch(b *testing.B) {
+	pod, sidecar := benchPod(), benchSidecar()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Patch(pod, "", sidecar)
+	}
+}
+
+func BenchmarkUnpatch(b *testing.B) {
+	sidecar := benchSidecar()
+	pod := benchPod()
+	pod.Spec.Containers = append(pod.Spec.Containers, sidecar.Containers...)
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, sidecar.InitContainers...)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, sidecar.Volumes...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Unpatch(pod, "", sidecar)
+	}
+}
@@ -0,0 +1,160 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// sidecarOwner names the operations of the sidecar in the conflicts
+const sidecarOwner = "sidecar"
+
+// Mutator contributes operations to the patch of the injected pods, after the sidecar, such as
+// adding a log shipper or topology labels. Mutators register themselves from their init, compiled
+// in with a blank import or loaded as a Go plugin by the binary embedding the package.
+type Mutator interface {
+	// Name identifies the mutator in the flags and errors
+	Name() string
+	// Mutate returns the operations for pod, with the paths of the pod itself. It sees the pod
+	// patched by the sidecar and the mutators before it.
+	Mutate(pod *corev1.Pod) ([]Operation, error)
+}
+
+// registered is a mutator and its rank
+type registered struct {
+	mutator Mutator
+	order   int
+}
+
+var registry = struct {
+	lock     sync.RWMutex
+	mutators map[string]registered
+}{mutators: map[string]registered{}}
+
+// Register makes m available to Lookup. The mutators run in increasing order, then by name.
+func Register(m Mutator, order int) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, ok := registry.mutators[m.Name()]; ok {
+		return fmt.Errorf("mutator %s is already registered", m.Name())
+	}
+	registry.mutators[m.Name()] = registered{mutator: m, order: order}
+	return nil
+}
+
+// Lookup returns the mutators of names in the order they run, it fails if one is not registered
+func Lookup(names []string) ([]Mutator, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	selected := make([]registered, 0, len(names))
+	for _, name := range names {
+		r, ok := registry.mutators[name]
+		if !ok {
+			return nil, fmt.Errorf("mutator %s is not registered", name)
+		}
+		selected = append(selected, r)
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].order != selected[j].order {
+			return selected[i].order < selected[j].order
+		}
+		return selected[i].mutator.Name() < selected[j].mutator.Name()
+	})
+	mutators := make([]Mutator, len(selected))
+	for i, r := range selected {
+		mutators[i] = r.mutator
+	}
+	return mutators, nil
+}
+
+// owned is an operation and the name of who contributed it
+type owned struct {
+	op    Operation
+	owner string
+}
+
+// Chain returns the operations of the sidecar, base, followed by those of mutators, each run on
+// pod patched by the operations before. All are moved under prefix, where the pod is in the
+// patched object. It fails if an operation overwrites what another contributor set.
+func Chain(pod *corev1.Pod, prefix string, base []Operation, mutators []Mutator) ([]Operation, error) {
+	ops := make([]owned, 0, len(base))
+	for _, op := range base {
+		ops = append(ops, owned{op: op, owner: sidecarOwner})
+	}
+	for _, m := range mutators {
+		current := pod
+		if len(ops) > 0 {
+			var err error
+			if current, err = apply(pod, ops); err != nil {
+				return nil, fmt.Errorf("can't patch the pod for mutator %s: %v", m.Name(), err)
+			}
+		}
+		contributed, err := m.Mutate(current)
+		if err != nil {
+			return nil, fmt.Errorf("mutator %s failed: %v", m.Name(), err)
+		}
+		for _, op := range contributed {
+			if earlier, ok := overwritten(ops, op, m.Name()); ok {
+				return nil, fmt.Errorf("mutator %s conflicts with %s: %s %s overwrites %s", m.Name(), earlier.owner, op.Op, op.Path, earlier.op.Path)
+			}
+			ops = append(ops, owned{op: op, owner: m.Name()})
+		}
+	}
+
+	p := make([]Operation, len(ops))
+	for i, o := range ops {
+		p[i] = o.op
+		p[i].Path = prefix + o.op.Path
+	}
+	return p, nil
+}
+
+// apply returns pod patched by ops
+func apply(pod *corev1.Pod, ops []owned) (*corev1.Pod, error) {
+	p := make([]Operation, len(ops))
+	for i, o := range ops {
+		p[i] = o.op
+	}
+	patch, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err = decoded.Apply(doc); err != nil {
+		return nil, err
+	}
+	var patched corev1.Pod
+	if err := json.Unmarshal(doc, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
+
+// overwritten returns the operation of another contributor than owner that op overwrites: one at
+// the same path, or below it. Appends to an array, at its /- path, overwrite nothing.
+func overwritten(ops []owned, op Operation, owner string) (owned, bool) {
+	if strings.HasSuffix(op.Path, "/-") {
+		return owned{}, false
+	}
+	for _, o := range ops {
+		if o.owner == owner {
+			continue
+		}
+		if o.op.Path == op.Path || strings.HasPrefix(o.op.Path, op.Path+"/") {
+			return o, true
+		}
+	}
+	return owned{}, false
+}
@@ -0,0 +1,56 @@
+package inject
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Unpatch returns the operations removing s from pod, found at prefix in the patched object: the
+// containers, volumes and pull secrets of s by name, and its annotations and labels. It undoes Patch, what
+// the pod doesn't have is left alone.
+func Unpatch(pod *corev1.Pod, prefix string, s Sidecar) []Operation {
+	var p []Operation
+	p = removeItems(p, len(pod.Spec.Containers), func(i int) bool {
+		return HasContainer(s.Containers, pod.Spec.Containers[i].Name)
+	}, prefix+"/spec/containers")
+	p = removeItems(p, len(pod.Spec.InitContainers), func(i int) bool {
+		return HasContainer(s.InitContainers, pod.Spec.InitContainers[i].Name)
+	}, prefix+"/spec/initContainers")
+	p = removeItems(p, len(pod.Spec.Volumes), func(i int) bool {
+		return hasVolume(s.Volumes, pod.Spec.Volumes[i].Name)
+	}, prefix+"/spec/volumes")
+	p = removeItems(p, len(pod.Spec.ImagePullSecrets), func(i int) bool {
+		return hasSecret(s.ImagePullSecrets, pod.Spec.ImagePullSecrets[i].Name)
+	}, prefix+"/spec/imagePullSecrets")
+	p = removeKeys(p, pod.Annotations, s.Annotations, prefix+"/metadata/annotations")
+	return removeKeys(p, pod.Labels, s.Labels, prefix+"/metadata/labels")
+}
+
+// removeKeys appends to p the operations removing the keys of remove that dest has, dest being
+// the annotations or labels at path
+func removeKeys(p []Operation, dest, remove map[string]string, path string) []Operation {
+	keys := make([]string, 0, len(remove))
+	for key := range remove {
+		if _, ok := dest[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p = append(p, Operation{Op: "remove", Path: path + "/" + EscapePointer(key)})
+	}
+	return p
+}
+
+// removeItems appends to p the operations removing the items of the list of n items at path
+// matched by remove, the last one first so the indexes of the others still hold
+func removeItems(p []Operation, n int, remove func(i int) bool, path string) []Operation {
+	for i := n - 1; i >= 0; i-- {
+		if remove(i) {
+			p = append(p, Operation{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+	}
+	return p
+}
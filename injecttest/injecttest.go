@@ -0,0 +1,177 @@
+// Package injecttest runs pod and workload fixtures through the mutation handler of the injector
+// and compares the patches answered with golden files, to unit test sidecar configs and templates.
+package injecttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/policy"
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/pmezard/go-difflib/difflib"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// goldenSuffix ends the names of the golden files of GoldenDir
+const goldenSuffix = ".patch.json"
+
+// Harness answers the AdmissionReviews of fixtures with the /mutate handler of an injector
+type Harness struct {
+	handler http.Handler
+	// Namespace is the namespace of the fixtures without one, default if empty
+	Namespace string
+	// Update writes the patches to the golden files instead of comparing them, such as from an
+	// -update flag of the tests
+	Update bool
+}
+
+// New returns a harness injecting the sidecar of configFile, a file or directory rendered with
+// valuesFile if not empty, and deciding with policies, by the annotations of the pods if nil
+func New(configFile, valuesFile string, policies *policy.Index) (*Harness, error) {
+	p := webhook.WebHookParameters{
+		MaxRequestBytes: webhook.DefaultMaxRequestBytes,
+		RequestTimeout:  10 * time.Second,
+	}
+	wh, err := webhook.NewReplayer(p, webhook.NewFileSource(configFile, valuesFile, 0))
+	if err != nil {
+		return nil, err
+	}
+	wh.Policies = policies
+	return &Harness{handler: wh.Handler()}, nil
+}
+
+// Review returns the answer of /mutate to the creation of the object of fixture, a YAML or JSON
+// file of a pod or workload
+func (h *Harness) Review(fixture string) (*admissionv1.AdmissionResponse, error) {
+	doc, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		return nil, err
+	}
+	object, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fixture, err)
+	}
+	namespace := h.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	review, err := webhook.NewReview(object, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fixture, err)
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("/mutate answered %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	var answered admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &answered); err != nil {
+		return nil, err
+	}
+	if answered.Response == nil {
+		return nil, fmt.Errorf("/mutate answered %s without response", fixture)
+	}
+	return answered.Response, nil
+}
+
+// Patch returns the indented JSON patch answered to fixture, [] if it is not mutated. It fails
+// if the creation is denied.
+func (h *Harness) Patch(fixture string) ([]byte, error) {
+	resp, err := h.Review(fixture)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Allowed {
+		message := ""
+		if resp.Result != nil {
+			message = resp.Result.Message
+		}
+		return nil, fmt.Errorf("%s denied: %s", fixture, message)
+	}
+	if len(resp.Patch) == 0 {
+		return []byte("[]\n"), nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, resp.Patch, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Golden fails t if the patch of fixture differs from the golden file, printing their diff. With
+// Update it writes the golden file instead.
+func (h *Harness) Golden(t testing.TB, fixture, golden string) {
+	t.Helper()
+	got, err := h.Patch(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v, update the golden files to create it", err)
+	}
+	if bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+		return
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(want)),
+		B:        difflib.SplitLines(string(got)),
+		FromFile: golden,
+		ToFile:   fixture,
+		Context:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("patch of %s differs from %s:\n%s", fixture, golden, diff)
+}
+
+// GoldenDir runs Golden in a subtest for each .yaml, .yml and .json fixture of fixtures, with the
+// golden file of the same name ending in .patch.json in goldens, which may be the same directory
+func (h *Harness) GoldenDir(t *testing.T, fixtures, goldens string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || strings.HasSuffix(name, goldenSuffix) || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ext)
+		fixture, golden := filepath.Join(fixtures, name), filepath.Join(goldens, base+goldenSuffix)
+		t.Run(base, func(t *testing.T) {
+			h.Golden(t, fixture, golden)
+		})
+	}
+}
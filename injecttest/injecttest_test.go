@@ -0,0 +1,83 @@
+package injecttest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newHarness returns the harness of the config of testdata
+func newHarness(t *testing.T) *Harness {
+	h, err := New(filepath.Join("testdata", "sidecarconfig.yaml"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// recorder is a testing.TB recording the errors of Golden instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestReview(t *testing.T) {
+	h := newHarness(t)
+	resp, err := h.Review(filepath.Join("testdata", "fixtures", "web.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Allowed || !bytes.Contains(resp.Patch, []byte(`"mesher:latest"`)) {
+		t.Errorf("expected web to be injected, got %+v", resp)
+	}
+}
+
+func TestPatchNotMutated(t *testing.T) {
+	h := newHarness(t)
+	patch, err := h.Patch(filepath.Join("testdata", "fixtures", "opted-out.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch) != "[]\n" {
+		t.Errorf("expected no patch of the Deployment opted out, got %s", patch)
+	}
+}
+
+func TestGoldenDir(t *testing.T) {
+	h := newHarness(t)
+	fixtures, goldens := filepath.Join("testdata", "fixtures"), t.TempDir()
+
+	h.Update = true
+	h.GoldenDir(t, fixtures, goldens)
+	for _, name := range []string{"web.patch.json", "opted-out.patch.json"} {
+		if _, err := ioutil.ReadFile(filepath.Join(goldens, name)); err != nil {
+			t.Fatalf("the golden file was not written: %v", err)
+		}
+	}
+
+	// the patches written are those answered
+	h.Update = false
+	h.GoldenDir(t, fixtures, goldens)
+
+	// a patch differing from its golden file fails with their diff
+	golden := filepath.Join(goldens, "web.patch.json")
+	data, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(golden, bytes.Replace(data, []byte("mesher:latest"), []byte("mesher:old"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &recorder{TB: t}
+	h.Golden(r, filepath.Join(fixtures, "web.yaml"), golden)
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "mesher:old") || !strings.Contains(r.errors[0], "mesher:latest") {
+		t.Errorf("expected the diff of the image, got %q", r.errors)
+	}
+}
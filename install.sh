@@ -7,10 +7,15 @@ bash -x deploy/signed-cert.sh --service sidecar-injector-webhook-mesher-svc --se
 export CA_BUNDLE=$(kubectl get configmap -n kube-system extension-apiserver-authentication -o=jsonpath='{.data.client-ca-file}' | base64 | tr -d '\n')
 
 sed 's/${CA_BUNDLE}/'"$CA_BUNDLE"'/g' deploy/mutatingwebhook.yaml > deploy/webhook_cabundle.yaml
+sed 's/${CA_BUNDLE}/'"$CA_BUNDLE"'/g' deploy/validatingwebhook.yaml > deploy/validatingwebhook_cabundle.yaml
 
+kubectl create -f deploy/sidecarinjectionpolicy-crd.yaml
+kubectl create -f deploy/sidecarconfiguration-crd.yaml
+kubectl create -f deploy/rbac.yaml -n chassis
 kubectl create -f deploy/mesherconfigmap.yaml -n chassis
 kubectl create -f deploy/configmap.yaml -n chassis
 kubectl create -f deploy/deployment.yaml -n chassis
 kubectl create -f deploy/service.yaml -n chassis
 kubectl create -f deploy/webhook_cabundle.yaml -n chassis
+kubectl create -f deploy/validatingwebhook_cabundle.yaml -n chassis
 
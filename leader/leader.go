@@ -0,0 +1,120 @@
+// Package leader runs the controllers of the injector on a single replica, the holder of a
+// coordination.k8s.io Lease, while the webhook is served by all of them
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Timings of the election, those of the controllers of kube-controller-manager
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Runnable is a controller run while the replica leads, it returns once ctx is done
+type Runnable func(ctx context.Context)
+
+// Elector stands for a Lease and runs its controllers while it holds it. A replica losing the
+// lease stops them and stands for it again.
+type Elector struct {
+	client    kubernetes.Interface
+	namespace string
+	lease     string
+	identity  string
+	// LeaseDuration, RenewDeadline and RetryPeriod time the election, the defaults if zero
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	runnables map[string]Runnable
+	leading   int32
+}
+
+// NewElector returns an elector standing for the Lease lease of namespace as identity, usually
+// the name of the pod
+func NewElector(client kubernetes.Interface, namespace, lease, identity string) *Elector {
+	return &Elector{
+		client:        client,
+		namespace:     namespace,
+		lease:         lease,
+		identity:      identity,
+		LeaseDuration: DefaultLeaseDuration,
+		RenewDeadline: DefaultRenewDeadline,
+		RetryPeriod:   DefaultRetryPeriod,
+		runnables:     map[string]Runnable{},
+	}
+}
+
+// Add registers the controller run, named name in the logs, before Run
+func (e *Elector) Add(name string, run Runnable) {
+	e.runnables[name] = run
+}
+
+// Len returns the number of controllers added
+func (e *Elector) Len() int {
+	return len(e.runnables)
+}
+
+// Leading tells whether the replica holds the lease
+func (e *Elector) Leading() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run stands for the lease and runs the controllers while it holds it, until ctx is done
+func (e *Elector) Run(ctx context.Context) error {
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, e.namespace, e.lease,
+		e.client.CoreV1(), e.client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: e.identity})
+	if err != nil {
+		return err
+	}
+
+	// a replica losing the lease stands for it again
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   e.LeaseDuration,
+			RenewDeadline:   e.RenewDeadline,
+			RetryPeriod:     e.RetryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: e.lead,
+				OnStoppedLeading: func() {
+					atomic.StoreInt32(&e.leading, 0)
+					metrics.Leader.WithLabelValues(e.lease).Set(0)
+					log.Infof("%s lost Lease %s/%s", e.identity, e.namespace, e.lease)
+				},
+			},
+		})
+	}
+	return nil
+}
+
+// lead runs the controllers until ctx is done, when the lease is lost
+func (e *Elector) lead(ctx context.Context) {
+	atomic.StoreInt32(&e.leading, 1)
+	metrics.Leader.WithLabelValues(e.lease).Set(1)
+	log.Infof("%s holds Lease %s/%s, starting %d controllers", e.identity, e.namespace, e.lease, len(e.runnables))
+
+	var wg sync.WaitGroup
+	for name, run := range e.runnables {
+		wg.Add(1)
+		go func(name string, run Runnable) {
+			defer wg.Done()
+			log.Infof("Starting controller %s", name)
+			run(ctx)
+			log.Infof("Stopped controller %s", name)
+		}(name, run)
+	}
+	wg.Wait()
+}
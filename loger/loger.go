@@ -55,6 +55,25 @@ func initLogRotate(logFilePath string) {
 	}()
 }
 
+// constant values for the log formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// SetFormat selects the format of the log lines, FormatJSON or FormatText
+func SetFormat(format string) error {
+	switch format {
+	case FormatJSON:
+		log.SetFormatter(new(log.JSONFormatter))
+	case FormatText:
+		log.SetFormatter(&log.TextFormatter{DisableColors: true})
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatJSON, FormatText)
+	}
+	return nil
+}
+
 //GetLogDir is a function used to get the logging directory
 func GetLogDir() string {
 	once.Do(initDir)
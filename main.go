@@ -2,42 +2,657 @@ package main
 
 import (
 	"context"
-	"flag"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	"github.com/go-chassis/sidecar-injector/leader"
 	"github.com/go-chassis/sidecar-injector/loger"
+	"github.com/go-chassis/sidecar-injector/policy"
+	"github.com/go-chassis/sidecar-injector/registration"
+	"github.com/go-chassis/sidecar-injector/version"
 	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// newManager creates the controller manager watching the injector resources
+func newManager() (ctrl.Manager, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	// the webhook server has its own listener, no need for the manager metrics one
+	return ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme, MetricsBindAddress: "0"})
+}
+
+// newConfigSource returns the source of the sidecar config selected by the parameters,
+// the file by default
+func newConfigSource(parms webhook.WebHookParameters, mgr ctrl.Manager) (webhook.ConfigSource, error) {
+	switch {
+	case parms.SidecarConfigResource != "":
+		namespace, name, err := cache.SplitMetaNamespaceKey(parms.SidecarConfigResource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sidecarConfigResource %q: %v", parms.SidecarConfigResource, err)
+		}
+		return webhook.NewResourceSource(mgr, types.NamespacedName{Namespace: namespace, Name: name})
+	case parms.SidecarConfigMap != "":
+		namespace, name, err := cache.SplitMetaNamespaceKey(parms.SidecarConfigMap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sidecarConfigMap %q: %v", parms.SidecarConfigMap, err)
+		}
+		client, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		return webhook.NewConfigMapSource(client, namespace, name, parms.SidecarConfigMapKey), nil
+	case parms.SidecarConfigURL != "":
+		return webhook.NewHTTPSource(parms)
+	default:
+		return webhook.NewFileSource(parms.SidecarConfigFile, parms.SidecarValuesFile, parms.ReloadDebounce), nil
+	}
+}
+
+// newCertSource returns the source of the serving key pair, nil for the files of the parameters.
+// When the certificates are bootstrapped, it issues them first and patches the caBundles until
+// stop is closed.
+func newCertSource(parms webhook.WebHookParameters, stop <-chan struct{}) (webhook.CertSource, error) {
+	if parms.SPIFFESocket != "" {
+		if parms.CSRSignerName != "" || parms.CertSecret != "" || parms.BootstrapCerts {
+			return nil, errors.New("spiffeSocket excludes csrSignerName, tlsSecret and bootstrapCerts")
+		}
+		return webhook.NewSPIFFECertSource(parms.SPIFFESocket), nil
+	}
+	if parms.CSRSignerName != "" {
+		if parms.CertSecret != "" || parms.BootstrapCerts {
+			return nil, errors.New("csrSignerName excludes tlsSecret and bootstrapCerts")
+		}
+		client, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		return webhook.NewCSRCertSource(client, parms.CSRSignerName, parms.ServiceName, parms.ServiceNamespace, parms.CSRTimeout), nil
+	}
+	if parms.CertSecret == "" {
+		if parms.BootstrapCerts {
+			return nil, errors.New("bootstrapCerts needs the tlsSecret to store the certificates in")
+		}
+		return nil, nil
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(parms.CertSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tlsSecret %q: %v", parms.CertSecret, err)
+	}
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if parms.BootstrapCerts {
+		b := webhook.CertBootstrap{
+			Service:                        parms.ServiceName,
+			Namespace:                      namespace,
+			Secret:                         name,
+			MutatingWebhookConfiguration:   parms.MutatingWebhookConfiguration,
+			ValidatingWebhookConfiguration: parms.ValidatingWebhookConfiguration,
+			Validity:                       parms.CertValidity,
+		}
+		ca, err := webhook.BootstrapCerts(client, b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bootstrap the certificates: %v", err)
+		}
+		go func() {
+			if err := webhook.PatchCABundles(client, b, ca, 10*time.Second, stop); err != nil {
+				log.Errorf("caBundles not patched: %v", err)
+			}
+		}()
+	}
+	return webhook.NewSecretCertSource(client, namespace, name), nil
+}
+
+// newClient creates a clientset from the kubeconfig or the service account of the pod
+func newClient() (kubernetes.Interface, error) {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// registrationFlags are the flags of the MutatingWebhookConfiguration reconciler
+type registrationFlags struct {
+	enabled           bool
+	namespaceSelector string
+	objectSelector    string
+	failurePolicy     string
+	timeoutSeconds    int
+	caBundleFile      string
+}
+
+// addFlags registers the flags of the webhook, but enabled, on fs
+func (f *registrationFlags) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&f.namespaceSelector, "webhookNamespaceSelector", "sidecar-injector=enabled", "Label selector of the namespaces whose objects are sent to the webhook.")
+	fs.StringVar(&f.objectSelector, "webhookObjectSelector", "", "Label selector of the objects sent to the webhook, all of them if empty.")
+	fs.StringVar(&f.failurePolicy, "webhookFailurePolicy", "Fail", "What the API server does when the webhook can't be called: Fail or Ignore.")
+	fs.IntVar(&f.timeoutSeconds, "webhookTimeoutSeconds", 10, "Timeout of the webhook calls, keep it at -requestTimeout.")
+	fs.StringVar(&f.caBundleFile, "caBundleFile", "", "CA certificates of the webhook, the caBundle already set is kept if empty.")
+}
+
+// spec returns the spec of the MutatingWebhookConfiguration name of the webhook served at
+// /mutate by service in namespace on port
+func (f registrationFlags) spec(name, service, namespace string, port int32) (registration.Spec, error) {
+	spec := registration.Spec{
+		Name:           name,
+		WebhookName:    "sidecar-injector.mesher.io",
+		Service:        service,
+		Namespace:      namespace,
+		Port:           port,
+		Path:           "/mutate",
+		FailurePolicy:  admissionregistrationv1.FailurePolicyType(f.failurePolicy),
+		TimeoutSeconds: int32(f.timeoutSeconds),
+	}
+	switch spec.FailurePolicy {
+	case admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+	default:
+		return spec, fmt.Errorf("invalid webhookFailurePolicy %q, expected Fail or Ignore", f.failurePolicy)
+	}
+	var err error
+	if spec.NamespaceSelector, err = metav1.ParseToLabelSelector(f.namespaceSelector); err != nil {
+		return spec, fmt.Errorf("invalid webhookNamespaceSelector: %v", err)
+	}
+	if spec.ObjectSelector, err = metav1.ParseToLabelSelector(f.objectSelector); err != nil {
+		return spec, fmt.Errorf("invalid webhookObjectSelector: %v", err)
+	}
+	if f.caBundleFile != "" {
+		if spec.CABundle, err = ioutil.ReadFile(f.caBundleFile); err != nil {
+			return spec, err
+		}
+	}
+	return spec, nil
+}
+
+// reportConfigStatus returns the Report of a DriftScanner writing the status of the
+// SidecarConfiguration key, namespace/name, read and written by the client of mgr
+func reportConfigStatus(mgr ctrl.Manager, key string) (func(context.Context, v1alpha1.SidecarConfigurationStatus) error, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sidecarConfigResource %q: %v", key, err)
+	}
+	return func(ctx context.Context, status v1alpha1.SidecarConfigurationStatus) error {
+		var sc v1alpha1.SidecarConfiguration
+		if err := mgr.GetAPIReader().Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &sc); err != nil {
+			return err
+		}
+		sc.Status = status
+		return mgr.GetClient().Status().Update(ctx, &sc)
+	}, nil
+}
+
+// newReconciler returns the reconciler of the MutatingWebhookConfiguration of the injector
+func newReconciler(parms webhook.WebHookParameters, f registrationFlags) (*registration.Reconciler, error) {
+	if parms.MutatingWebhookConfiguration == "" {
+		return nil, errors.New("reconcileWebhookConfiguration needs the mutatingWebhookConfiguration name")
+	}
+	spec, err := f.spec(parms.MutatingWebhookConfiguration, parms.ServiceName, parms.ServiceNamespace, 443)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return &registration.Reconciler{Client: client, Spec: spec}, nil
+}
+
+// replayLine is the outcome of the replay of a captured review
+type replayLine struct {
+	File     string          `json:"file"`
+	Error    string          `json:"error,omitempty"`
+	Allowed  bool            `json:"allowed"`
+	Message  string          `json:"message,omitempty"`
+	Warnings []string        `json:"warnings,omitempty"`
+	Patch    json.RawMessage `json:"patch,omitempty"`
+	// CapturedPatch is the patch answered when the review was captured, redacted
+	CapturedPatch json.RawMessage `json:"capturedPatch,omitempty"`
+}
+
+// replay runs the reviews captured in path, a file or a directory of them, through the mutation
+// and writes a JSON line per review to the standard output. It fails if one of them can't be read.
+func replay(parms webhook.WebHookParameters, source webhook.ConfigSource, path string) error {
+	wh, err := webhook.NewReplayer(parms, source)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		// named after their capture time, in order
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	encoder := json.NewEncoder(os.Stdout)
+	for _, file := range files {
+		line := replayLine{File: file}
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			var resp, captured *admissionv1.AdmissionResponse
+			if resp, captured, err = wh.Replay(data); err == nil {
+				line.Allowed, line.Warnings, line.Patch = resp.Allowed, resp.Warnings, resp.Patch
+				if resp.Result != nil {
+					line.Message = resp.Result.Message
+				}
+				if captured != nil {
+					line.CapturedPatch = captured.Patch
+				}
+			}
+		}
+		if err != nil {
+			line.Error = err.Error()
+			failed++
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reviews not replayed", failed, len(files))
+	}
+	return nil
+}
+
+// splitList returns the items of a comma separated flag, nil if it is empty
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// splitPairs returns the key=value items of a comma separated flag, nil if it is empty
+func splitPairs(value string) (map[string]string, error) {
+	var pairs map[string]string
+	for _, item := range splitList(value) {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid pair %q, expected key=value", item)
+		}
+		if pairs == nil {
+			pairs = map[string]string{}
+		}
+		pairs[kv[0]] = kv[1]
+	}
+	return pairs, nil
+}
+
 func main() {
-	var parms webhook.WebHookParameters
-	// TODO use "github.com/urfave/cli"
 	loger.Initialize()
-	// get command line parameters
-	flag.IntVar(&parms.Port, "port", 443, "Webhook server port.")
-	flag.StringVar(&parms.CertFile, "tlsCertFile", "/etc/webhook/mesher/certs/cert.pem", "File containing the x509 Certificate for HTTPS.")
-	flag.StringVar(&parms.KeyFile, "tlsKeyFile", "/etc/webhook/mesher/certs/key.pem", "File containing the x509 private key to --tlsCertFile.")
-	flag.StringVar(&parms.SidecarConfigFile, "sidecarCfgFile", "/etc/webhook/mesher/config/sidecarconfig.yaml", "File containing the configuration.")
-	flag.DurationVar(&parms.HealthCheckInterval, "healthCheckInterval", 0, "Configure how frequently the health chek interval updated.")
-	flag.StringVar(&parms.HealthCheckFile, "healthCheckFile", "", "File that should be periodically updated if health check is enabled.")
-	flag.Parse()
+	root := newRootCommand()
+	args := os.Args[1:]
+	// installed as a kubectl plugin, kubectl mesher-inject is sidecar-injector describe
+	if filepath.Base(os.Args[0]) == pluginName {
+		args = append([]string{"describe"}, args...)
+	}
+	root.SetArgs(compatArgs(args))
+	if err := root.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
 
-	wh, err := webhook.NewWebhook(parms)
-	if err != nil {
-		log.Errorf("failed to create webhook injection", err)
+// newServeCommand returns the serve command, running the webhook server
+func newServeCommand() *cobra.Command {
+	var parms webhook.WebHookParameters
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the webhook server",
+		Args:  cobra.NoArgs,
 	}
+	fs := cmd.Flags()
+	var logFormat, logLevel string
+	fs.StringVar(&logFormat, "logFormat", loger.FormatJSON, "Format of the log lines: json or text.")
+	fs.StringVar(&logLevel, "logLevel", "info", "Lowest level logged: debug, info, warning, error, fatal or panic. PUT /-/loglevel changes it at runtime.")
+	redactPatterns := strings.Join(webhook.DefaultRedactPatterns, ",")
+	fs.StringVar(&redactPatterns, "logRedactPatterns", redactPatterns, "Comma separated regular expressions of the env variable names and annotation keys whose values are masked in the logged patches.")
+	fs.StringVar(&parms.AccessLogFile, "accessLog", "", "File receiving a JSON line per request of the webhook port, - for the standard output, none if empty.")
+	fs.Float64Var(&parms.AccessLogSampling, "accessLogSampling", 1, "Share of the successful requests written to -accessLog, the failed ones always are.")
+	fs.StringVar(&parms.AuditFile, "auditFile", "", "File receiving a JSON line per mutation with the pod, user, profile, config hash and full patch, - for the standard output, none if empty.")
+	fs.IntVar(&parms.AuditMaxSizeMB, "auditMaxSizeMB", 100, "Size in megabytes -auditFile is rotated at, 0 never rotates it.")
+	fs.IntVar(&parms.AuditMaxBackups, "auditMaxBackups", 7, "Rotated -auditFile files kept.")
+	fs.StringVar(&parms.CaptureDir, "captureDir", "", "Directory receiving a sample of the AdmissionReviews of the mutations, sanitized, to replay them. None if empty.")
+	fs.Float64Var(&parms.CaptureSampling, "captureSampling", 0.01, "Share of the mutations written to -captureDir.")
+	fs.IntVar(&parms.CaptureMaxFiles, "captureMaxFiles", 1000, "Reviews written to -captureDir before the capture stops.")
+	var replayPath string
+	fs.StringVar(&replayPath, "replay", "", "Replay the reviews captured in this file or directory with the sidecar config, print the outcomes and exit.")
+	fs.IntVar(&parms.Port, "port", 443, "Webhook server port.")
+	fs.BoolVar(&parms.DisableHTTP2, "disableHTTP2", false, "Serve HTTP/1.1 only, for intermediaries between the API server and the injector that break HTTP/2.")
+	var maxStreams uint
+	fs.UintVar(&maxStreams, "http2MaxConcurrentStreams", 250, "Maximum concurrent streams of an HTTP/2 connection.")
+	fs.StringVar(&parms.BindAddress, "bindAddress", "", "IPv4 or IPv6 address the webhook server listens on, all the interfaces if empty.")
+	fs.StringVar(&parms.CertFile, "tlsCertFile", "/etc/webhook/mesher/certs/cert.pem", "File containing the x509 Certificate for HTTPS.")
+	fs.StringVar(&parms.KeyFile, "tlsKeyFile", "/etc/webhook/mesher/certs/key.pem", "File containing the x509 private key to --tlsCertFile.")
+	var sniCerts string
+	fs.StringVar(&sniCerts, "tlsSNICerts", "", "Comma separated certFile:keyFile pairs served instead of -tlsCertFile to the clients asking for a name they are valid for.")
+	fs.StringVar(&parms.CertSecret, "tlsSecret", "", "namespace/name of the kubernetes.io/tls Secret to watch instead of -tlsCertFile and -tlsKeyFile.")
+	fs.BoolVar(&parms.BootstrapCerts, "bootstrapCerts", false, "Issue a self-signed certificate into -tlsSecret and patch the caBundle of the webhook configurations.")
+	fs.StringVar(&parms.ServiceName, "serviceName", "sidecar-injector-webhook-mesher-svc", "Service of the webhook, in the namespace of -tlsSecret, the bootstrapped certificate is valid for.")
+	fs.StringVar(&parms.MutatingWebhookConfiguration, "mutatingWebhookConfiguration", "sidecar-injector-webhook-mesher-cfg", "MutatingWebhookConfiguration whose caBundle is patched by -bootstrapCerts, empty to skip it.")
+	fs.StringVar(&parms.ValidatingWebhookConfiguration, "validatingWebhookConfiguration", "sidecar-injector-webhook-mesher-validation-cfg", "ValidatingWebhookConfiguration whose caBundle is patched by -bootstrapCerts, empty to skip it.")
+	fs.StringVar(&parms.SPIFFESocket, "spiffeSocket", "", "Address of the SPIFFE Workload API serving the certificate, e.g. unix:///run/spire/sockets/agent.sock, instead of -tlsCertFile.")
+	fs.StringVar(&parms.CSRSignerName, "csrSignerName", "", "Signer of the CertificateSigningRequests requesting the certificate of -serviceName, instead of -tlsCertFile or -tlsSecret.")
+	fs.StringVar(&parms.ServiceNamespace, "serviceNamespace", "chassis", "Namespace of -serviceName, for -csrSignerName and -reconcileWebhookConfiguration.")
+	fs.DurationVar(&parms.CSRTimeout, "csrTimeout", 5*time.Minute, "Time allowed to a CertificateSigningRequest to be approved and issued.")
+	fs.DurationVar(&parms.CertValidity, "certValidity", webhook.DefaultCertValidity, "Validity of the bootstrapped certificates, they are issued again at startup once two thirds of it passed.")
+	fs.StringVar(&parms.SidecarConfigFile, "sidecarCfgFile", "/etc/webhook/mesher/config/sidecarconfig.yaml", "File containing the configuration, or directory whose *.yaml and *.json files are merged in name order.")
+	fs.StringVar(&parms.SidecarValuesFile, "sidecarValuesFile", "", "Values file rendering -sidecarCfgFile as a template.")
+	fs.IntVar(&parms.InsecurePort, "insecurePort", 0, "Plain HTTP port serving the webhook on localhost, for development and tests, 0 disables it.")
+	fs.BoolVar(&parms.DisableTLS, "disableTLS", false, "Serve the webhook on -insecurePort only, without certificate.")
+	fs.IntVar(&parms.AdminPort, "adminPort", 8080, "Plain HTTP port of /healthz, /readyz, /metrics, /configz, /statz and /version, 0 disables it.")
+	fs.StringVar(&parms.HealthCheckFile, "healthCheckFile", "", "File written with ok while /healthz passes, for exec probes, removed while the replica is unhealthy; legacy, prefer the HTTP probe.")
+	fs.DurationVar(&parms.HealthCheckInterval, "healthCheckInterval", 10*time.Second, "How often -healthCheckFile is written.")
+	fs.BoolVar(&parms.EnableDebugHandlers, "enableDebugHandlers", false, "Serve the pprof profiles under /debug/pprof/ and the expvar variables at /debug/vars on -adminPort, unauthenticated.")
+	fs.StringVar(&parms.AdminBindAddress, "adminBindAddress", "", "Address the admin server listens on, all the interfaces if empty. The kubelet probes need the pod IP.")
+	fs.DurationVar(&parms.ReloadDebounce, "reloadDebounce", 2*time.Second, "Quiet period after the last file change before the configuration and certificates are reloaded.")
+	fs.StringVar(&parms.AdminTokenFile, "adminTokenFile", "", "File holding the bearer token of the admin endpoints such as /-/reload, they are disabled without it.")
+	fs.StringVar(&parms.WebhookTokenFile, "webhookTokenFile", "", "File holding the token the admission requests must carry in -webhookTokenHeader, they are not authenticated if empty.")
+	fs.StringVar(&parms.WebhookTokenHeader, "webhookTokenHeader", "Authorization", "Header of the webhook token, a bearer token when it is Authorization.")
+	fs.DurationVar(&parms.ReadTimeout, "readTimeout", 10*time.Second, "Time allowed to read a request, headers and body.")
+	fs.DurationVar(&parms.WriteTimeout, "writeTimeout", 30*time.Second, "Time allowed to answer a request once its headers are read.")
+	fs.DurationVar(&parms.IdleTimeout, "idleTimeout", 90*time.Second, "Time an idle keep-alive connection is kept open.")
+	fs.DurationVar(&parms.RequestTimeout, "requestTimeout", 10*time.Second, "Deadline of a mutation when the API server doesn't send its timeout, keep it at the timeoutSeconds of the webhook.")
+	fs.DurationVar(&parms.ShutdownDelay, "shutdownDelay", 5*time.Second, "Time the server keeps serving after SIGTERM, so the API server stops sending it reviews.")
+	fs.DurationVar(&parms.ShutdownTimeout, "shutdownTimeout", 20*time.Second, "Time allowed to the in-flight reviews to finish once the server stops accepting connections.")
+	fs.IntVar(&parms.MaxInflightRequests, "maxInflightRequests", 200, "Admission requests served at once, more are answered 429 right away. 0 for no limit.")
+	var namespaceQPS float64
+	fs.Float64Var(&namespaceQPS, "namespaceQPS", 0, "Mutations per second allowed in each namespace, the others are answered 429. 0 for no limit.")
+	fs.IntVar(&parms.NamespaceBurst, "namespaceBurst", 20, "Mutations a namespace can burst above -namespaceQPS.")
+	fs.Int64Var(&parms.MaxRequestBytes, "maxRequestBytes", webhook.DefaultMaxRequestBytes, "Largest AdmissionReview accepted, bigger requests are answered 413.")
+	fs.DurationVar(&parms.InjectionBudget, "injectionBudget", 0, "Time allowed to render and patch a sidecar, e.g. 2s, below -requestTimeout. 0 for no budget.")
+	fs.StringVar(&parms.BudgetAction, "injectionBudgetAction", webhook.BudgetAllow, "What is done with the objects not injected within -injectionBudget: allow them without sidecar, or deny them.")
+	fs.IntVar(&parms.PatchCacheSize, "patchCacheSize", 1000, "Patches kept by config, profile and pod, so the pods of a workload are rendered once. 0 disables the cache, needed by mutators depending on more than the pod.")
+	fs.BoolVar(&parms.FailOpen, "failOpen", false, "Admit the pods the injector fails on without sidecar instead of rejecting them.")
+	var emitEvents bool
+	fs.BoolVar(&emitEvents, "emitEvents", false, "Record Events on the objects the sidecar is injected in, skipped by their opt-out or a policy, or failed on.")
+	var excludedOwnerKinds, annotationAliases, annotationPrefix string
+	fs.BoolVar(&parms.CNIEnabled, "cniEnabled", false, "The mesh CNI plugin intercepts the traffic of all the namespaces: the pods are injected without the init containers of the sidecar, annotated for the plugin. Otherwise only in the namespaces labeled <annotationPrefix>/cni=enabled, with -enablePolicyCRD.")
+	fs.StringVar(&annotationPrefix, "annotationPrefix", webhook.DefaultAnnotationPrefix, "Prefix of the annotation keys of the injector, such as <prefix>/inject, so several injectors don't collide.")
+	fs.StringVar(&annotationAliases, "annotationAliases", "", "Comma separated alias=key annotation keys honored as the inject key of the injector, e.g. sidecar.istio.io/inject=sidecar-injector-mesher.io/inject.")
+	fs.StringVar(&excludedOwnerKinds, "excludedOwnerKinds", "", "Comma separated kinds of the controllers whose pods are never injected, e.g. Job,DaemonSet.")
+	var mutators, mutatorPlugins string
+	fs.StringVar(&mutators, "mutators", "", "Comma separated mutators adding their operations to the patch after the sidecar, run in their registration order.")
+	fs.StringVar(&mutatorPlugins, "mutatorPlugins", "", "Comma separated Go plugins registering mutators, for binaries built with cgo.")
+	var wasmMutators string
+	fs.StringVar(&wasmMutators, "wasmMutators", "", "Comma separated WASM modules run as mutators after those of -mutators, for binaries built with the wazero tag.")
+	wasmTimeout := fs.Duration("wasmTimeout", time.Second, "Time a WASM mutator has to answer for a pod.")
+	fs.BoolVar(&parms.EnablePolicyCRD, "enablePolicyCRD", false, "Decide injection with the SidecarInjectionPolicy resources of the cluster.")
+	fs.StringVar(&parms.SidecarConfigResource, "sidecarConfigResource", "", "namespace/name of the SidecarConfiguration resource to use instead of -sidecarCfgFile.")
+	fs.StringVar(&parms.SidecarConfigMap, "sidecarConfigMap", "", "namespace/name of the ConfigMap to watch instead of -sidecarCfgFile.")
+	fs.StringVar(&parms.SidecarConfigMapKey, "sidecarConfigMapKey", webhook.DefaultConfigMapKey, "Key of -sidecarConfigMap holding the configuration.")
+	fs.StringVar(&parms.SidecarConfigURL, "sidecarConfigURL", "", "HTTPS URL to poll the configuration from instead of -sidecarCfgFile.")
+	fs.StringVar(&parms.SidecarConfigURLCAFile, "sidecarConfigURLCAFile", "", "CA certificates verifying -sidecarConfigURL, the system ones if empty.")
+	fs.DurationVar(&parms.SidecarConfigPollInterval, "sidecarConfigPollInterval", 30*time.Second, "How often -sidecarConfigURL is polled.")
+	fs.StringVar(&parms.SidecarConfigCache, "sidecarConfigCache", "", "File keeping the last good configuration, used when its source is unreadable at startup.")
+	fs.StringVar(&parms.ClientCAFile, "clientCAFile", "", "CA certificates the client certificates of the TLS port must be signed by, no client certificate is asked if empty.")
+	var allowedCNs string
+	fs.StringVar(&allowedCNs, "allowedClientCNs", "", "Comma separated common names of the client certificates accepted with -clientCAFile, any if empty.")
+	fs.DurationVar(&parms.CertExpiryWarning, "certExpiryWarning", 30*24*time.Hour, "How long before the expiry of the serving certificate warnings are logged, 0 disables them.")
+	fs.DurationVar(&parms.CertExpiryUnready, "certExpiryUnready", 0, "How long before the expiry of the serving certificate /readyz fails, 0 only fails once it expired.")
+	fs.StringVar(&parms.TLSMinVersion, "tlsMinVersion", webhook.DefaultTLSMinVersion, "Oldest TLS version accepted: 1.0, 1.1, 1.2 or 1.3.")
+	var cipherSuites, curves string
+	fs.StringVar(&cipherSuites, "tlsCipherSuites", "", "Comma separated cipher suites allowed below TLS 1.3, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, the Go defaults if empty.")
+	fs.StringVar(&curves, "tlsCurvePreferences", "", "Comma separated elliptic curves in order of preference: X25519, P256, P384 or P521, the Go defaults if empty.")
+	var reg registrationFlags
+	fs.BoolVar(&reg.enabled, "reconcileWebhookConfiguration", false, "Create -mutatingWebhookConfiguration from the flags below and repair it when it changes, from the leader replica.")
+	reg.addFlags(fs)
+	var statusResource string
+	var statusInterval time.Duration
+	fs.StringVar(&statusResource, "statusResource", "", "Cluster-scoped SidecarInjector resource each replica writes its version, config hash, injection counts and errors to, created if missing. Empty disables it.")
+	fs.DurationVar(&statusInterval, "statusInterval", time.Minute, "How often the replicas write -statusResource.")
+	fs.BoolVar(&parms.ConfigFencing, "configFencing", false, "Hold a reloaded config back until all the replicas reporting to -statusResource loaded it, so they switch to it together.")
+	fs.DurationVar(&parms.ConfigFenceTimeout, "configFenceTimeout", 5*time.Minute, "Time a config is held back at most by -configFencing, before switching to it without all the replicas.")
+	var leaderElectionLease string
+	var leaderElectionLeaseDuration time.Duration
+	fs.StringVar(&leaderElectionLease, "leaderElectionLease", "sidecar-injector-leader", "Lease of -serviceNamespace held by the replica running the controllers, such as -reconcileWebhookConfiguration and -driftScanInterval.")
+	fs.DurationVar(&leaderElectionLeaseDuration, "leaderElectionLeaseDuration", leader.DefaultLeaseDuration, "Time the other replicas wait before taking over the lease of a leader that stopped renewing it.")
+	var driftScanInterval time.Duration
+	fs.DurationVar(&driftScanInterval, "driftScanInterval", 0, "How often the running injected pods are compared with the config served, from the leader replica, e.g. 10m. 0 disables the scan.")
+	var restartStale bool
+	var restartMaxInProgress int
+	fs.BoolVar(&restartStale, "restartStaleWorkloads", false, "Roll out the Deployments and StatefulSets with stale pods found by -driftScanInterval, in the namespaces annotated <annotationPrefix>/restart=enabled.")
+	fs.IntVar(&restartMaxInProgress, "restartMaxInProgress", 1, "Stale workloads rolling out at once with -restartStaleWorkloads.")
+	cmd.Run = func(*cobra.Command, []string) {
+		if err := loger.SetFormat(logFormat); err != nil {
+			log.Fatalf("invalid logFormat: %v", err)
+		}
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			log.Fatalf("invalid logLevel: %v", err)
+		}
+		log.SetLevel(level)
+		log.WithFields(log.Fields{
+			"version":   version.Version,
+			"gitCommit": version.GitCommit,
+			"buildDate": version.BuildDate,
+			"goVersion": version.GoVersion,
+		}).Info("Starting sidecar-injector")
+		parms.HTTP2MaxConcurrentStreams = uint32(maxStreams)
+		parms.NamespaceQPS = float32(namespaceQPS)
+		for _, pair := range splitList(sniCerts) {
+			files := strings.SplitN(pair, ":", 2)
+			if len(files) != 2 {
+				log.Fatalf("invalid tlsSNICerts pair %q, expected certFile:keyFile", pair)
+			}
+			parms.SNICerts = append(parms.SNICerts, webhook.CertKeyFiles{CertFile: files[0], KeyFile: files[1]})
+		}
+		parms.AllowedClientCNs = splitList(allowedCNs)
+		parms.ExcludedOwnerKinds = splitList(excludedOwnerKinds)
+		if err := webhook.SetAnnotationPrefix(annotationPrefix); err != nil {
+			log.Fatalf("invalid annotationPrefix: %v", err)
+		}
+		if parms.AnnotationAliases, err = splitPairs(annotationAliases); err != nil {
+			log.Fatalf("invalid annotationAliases: %v", err)
+		}
+		parms.Mutators = splitList(mutators)
+		parms.MutatorPlugins = splitList(mutatorPlugins)
+		wasmNames, err := registerWASMMutators(wasmMutators, *wasmTimeout)
+		if err != nil {
+			log.Fatalf("failed to load the WASM mutators: %v", err)
+		}
+		parms.Mutators = append(parms.Mutators, wasmNames...)
+		parms.LogRedactPatterns = splitList(redactPatterns)
+		parms.TLSCipherSuites = splitList(cipherSuites)
+		parms.TLSCurvePreferences = splitList(curves)
 
-	stop := make(chan struct{})
-	go wh.Run(stop, parms)
+		if parms.ConfigFencing && statusResource == "" {
+			log.Fatalf("configFencing needs a statusResource")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		var mgr ctrl.Manager
+		if parms.EnablePolicyCRD || parms.SidecarConfigResource != "" || statusResource != "" {
+			var err error
+			mgr, err = newManager()
+			if err != nil {
+				log.Fatalf("failed to create controller manager: %v", err)
+			}
+		}
 
-	signalC := make(chan os.Signal, 1)
-	signal.Notify(signalC, syscall.SIGINT, syscall.SIGTERM)
-	<-signalC
+		source, err := newConfigSource(parms, mgr)
+		if err != nil {
+			log.Fatalf("failed to create config source: %v", err)
+		}
+		if parms.SidecarConfigCache != "" {
+			source = webhook.WithLastGood(source, parms.SidecarConfigCache)
+		}
+		if replayPath != "" {
+			if err := replay(parms, source, replayPath); err != nil {
+				log.Fatalf("replay failed: %v", err)
+			}
+			return
+		}
 
-	log.Infof("Shutting down wenhook server gracefully")
-	wh.Server.Shutdown(context.Background())
-	close(stop)
+		stop := make(chan struct{})
+		if restartStale && driftScanInterval <= 0 {
+			log.Fatalf("restartStaleWorkloads needs a driftScanInterval")
+		}
+		// the controllers run on the replica holding the lease, the webhook on all of them
+		var elector *leader.Elector
+		var controllerClient kubernetes.Interface
+		if reg.enabled || driftScanInterval > 0 {
+			if controllerClient, err = newClient(); err != nil {
+				log.Fatalf("failed to create the controllers client: %v", err)
+			}
+			identity, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("failed to get the hostname: %v", err)
+			}
+			elector = leader.NewElector(controllerClient, parms.ServiceNamespace, leaderElectionLease, identity)
+			elector.LeaseDuration = leaderElectionLeaseDuration
+		}
+		if reg.enabled {
+			reconciler, err := newReconciler(parms, reg)
+			if err != nil {
+				log.Fatalf("failed to create webhook configuration reconciler: %v", err)
+			}
+			elector.Add("webhook-configuration", reconciler.Watch)
+		}
+
+		certSource, err := newCertSource(parms, stop)
+		if err != nil {
+			log.Fatalf("failed to create certificate source: %v", err)
+		}
+
+		wh, err := webhook.NewWebhook(parms, source, certSource)
+		if err != nil {
+			log.Fatalf("failed to create webhook injection: %v", err)
+		}
+
+		if emitEvents {
+			client, err := newClient()
+			if err != nil {
+				log.Fatalf("failed to create the Events client: %v", err)
+			}
+			wh.Events = webhook.NewEventRecorder(client, stop)
+		}
+
+		if mgr != nil {
+			if parms.EnablePolicyCRD {
+				index := policy.NewIndex()
+				if err := policy.Setup(mgr, index); err != nil {
+					log.Fatalf("failed to setup policy controller: %v", err)
+				}
+				wh.Policies = index
+			}
+			go func() {
+				if err := mgr.Start(ctx); err != nil {
+					log.Errorf("controller manager stopped: %v", err)
+				}
+			}()
+			// injection decisions read the caches, don't take reviews before they are filled
+			var synced int32
+			go func() {
+				if mgr.GetCache().WaitForCacheSync(ctx) {
+					atomic.StoreInt32(&synced, 1)
+				}
+			}()
+			wh.AddReadyCheck("informers", func() error {
+				if atomic.LoadInt32(&synced) == 0 {
+					return errors.New("caches not synced")
+				}
+				return nil
+			})
+		}
+
+		if driftScanInterval > 0 {
+			scanner := webhook.NewDriftScanner(wh, controllerClient, driftScanInterval)
+			if restartStale {
+				scanner.Restarter = webhook.NewRestarter(controllerClient, restartMaxInProgress)
+			}
+			if parms.SidecarConfigResource != "" {
+				if scanner.Report, err = reportConfigStatus(mgr, parms.SidecarConfigResource); err != nil {
+					log.Fatalf("failed to create the drift scan: %v", err)
+				}
+			}
+			elector.Add("drift-scan", scanner.Run)
+		}
+		if statusResource != "" {
+			identity, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("failed to get the hostname: %v", err)
+			}
+			reporter := webhook.NewStatusReporter(wh, mgr, statusResource, identity, statusInterval)
+			if elector != nil {
+				reporter.Leading = elector.Leading
+			}
+			go reporter.Run(ctx)
+		}
+		if elector != nil {
+			go func() {
+				if err := elector.Run(ctx); err != nil {
+					log.Errorf("leader election stopped: %v", err)
+				}
+			}()
+		}
+
+		go wh.Run(stop, parms)
+
+		hupC := make(chan os.Signal, 1)
+		signal.Notify(hupC, syscall.SIGHUP)
+		go func() {
+			for range hupC {
+				log.Infof("SIGHUP received, reloading")
+				if err := wh.Reload(); err != nil {
+					log.Errorf("reload failed: %v", err)
+				}
+			}
+		}()
+
+		signalC := make(chan os.Signal, 1)
+		signal.Notify(signalC, syscall.SIGINT, syscall.SIGTERM)
+		<-signalC
+
+		log.Infof("Shutting down wenhook server gracefully")
+		if err := wh.Shutdown(parms.ShutdownDelay, parms.ShutdownTimeout); err != nil {
+			log.Errorf("failed to drain the webhook server: %v", err)
+		}
+		cancel()
+		close(stop)
+	}
+	return cmd
 }
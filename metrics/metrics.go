@@ -0,0 +1,251 @@
+// Package metrics holds the prometheus metrics of the injector
+package metrics
+
+import (
+	"github.com/go-chassis/sidecar-injector/version"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "sidecar_injector"
+
+// constant values for the result label
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// constant values for the reason label of the throttled requests
+const (
+	ReasonOverload    = "overload"
+	ReasonRateLimited = "rate_limited"
+)
+
+// constant values for the webhook label
+const (
+	WebhookMutate   = "mutate"
+	WebhookValidate = "validate"
+)
+
+// SkipReason tells why an object is left without sidecar. It is the reason label of the skips,
+// and is found in the logs, the warnings and the status annotation of the skipped pods.
+type SkipReason string
+
+// constant values of SkipReason
+const (
+	// SkipInjected is a pod carrying the sidecar already
+	SkipInjected SkipReason = "already_injected"
+	// SkipAnnotation is a pod opting out with its annotation
+	SkipAnnotation SkipReason = "annotation_off"
+	// SkipNamespace is a pod of a namespace excluded by a SidecarInjectionPolicy
+	SkipNamespace SkipReason = "namespace_excluded"
+	// SkipOwnerKind is a pod of a controller whose kind is excluded
+	SkipOwnerKind SkipReason = "owner_kind_excluded"
+	// SkipPolicy is a pod excluded by the pod selector of a SidecarInjectionPolicy
+	SkipPolicy SkipReason = "policy_rule"
+	// SkipDisabled is a pod not opting in where injection is disabled by default
+	SkipDisabled SkipReason = "not_enabled"
+	// SkipError is a pod the injector failed on, admitted without sidecar as it fails open
+	SkipError SkipReason = "error"
+)
+
+var (
+	// Injections counts the objects injected by namespace and profile
+	Injections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injections_total",
+		Help:      "Number of objects injected with the sidecar by namespace and profile.",
+	}, []string{"namespace", "profile"})
+
+	// Removals counts the objects whose sidecar was removed by namespace and profile
+	Removals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "removals_total",
+		Help:      "Number of objects whose sidecar was removed by namespace and profile.",
+	}, []string{"namespace", "profile"})
+
+	// WorkloadInjections counts the objects injected by namespace and workload, the controller of
+	// the pods, a series per workload: the Jobs of a CronJob and the rollouts of a ReplicaSet
+	// share the series of their workload
+	WorkloadInjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workload_injections_total",
+		Help:      "Number of objects injected with the sidecar by namespace and workload, kind/name of the controller of the pods.",
+	}, []string{"namespace", "workload"})
+
+	// InjectionSkips counts the objects left without sidecar by namespace and reason
+	InjectionSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injection_skips_total",
+		Help:      "Number of objects not injected by namespace and reason.",
+	}, []string{"namespace", "reason"})
+
+	// InjectionErrors counts the mutations that failed by namespace and status reason
+	InjectionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injection_errors_total",
+		Help:      "Number of failed mutations by namespace and status reason.",
+	}, []string{"namespace", "reason"})
+
+	// PatchOperations counts the JSON patch operations of the injections by namespace and profile
+	PatchOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "patch_operations_total",
+		Help:      "Number of JSON patch operations of the injections by namespace and profile.",
+	}, []string{"namespace", "profile"})
+
+	// PatchDuration observes the time spent rendering the sidecar and generating the patch
+	PatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "patch_generation_duration_seconds",
+		Help:      "Time spent rendering the sidecar config and generating the patch by namespace and profile.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"namespace", "profile"})
+
+	// PatchCacheLookups counts the lookups of the patch cache by whether they hit
+	PatchCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "patch_cache_lookups_total",
+		Help:      "Number of lookups of the patch cache by hit, true or false.",
+	}, []string{"hit"})
+
+	// AdmissionDuration observes the time from the reception of an AdmissionReview to its answer
+	AdmissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Time from the reception of an AdmissionReview to its answer by webhook and namespace.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"webhook", "namespace"})
+
+	// ConfigReloads counts the sidecar config reloads by result
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reload_total",
+		Help:      "Number of sidecar config reloads by result.",
+	}, []string{"result"})
+
+	// ConfigValidationErrors is the number of errors of the last config load, 0 when it was valid
+	ConfigValidationErrors = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_validation_errors",
+		Help:      "Number of errors found by the last sidecar config load.",
+	})
+
+	// ConfigLastReloadSuccess is the time of the last successful config load, in seconds since the epoch
+	ConfigLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful sidecar config load in seconds since the epoch.",
+	})
+
+	// ConfigInfo is 1 for the hash of the sidecar config served
+	ConfigInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_info",
+		Help:      "Sidecar config served, labeled with its hash, always 1.",
+	}, []string{"hash"})
+
+	// InflightRequests is the number of admission requests being served
+	InflightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "admission_inflight_requests",
+		Help:      "Number of admission requests being served.",
+	})
+
+	// ThrottledRequests counts the admission requests turned away by reason
+	ThrottledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_throttled_total",
+		Help:      "Number of admission requests turned away by reason.",
+	}, []string{"reason"})
+
+	// CertificateExpiry is the end of validity of the serving certificates by source, in seconds
+	// since the epoch
+	CertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cert_expiry_timestamp_seconds",
+		Help:      "End of validity of the serving certificates by source in seconds since the epoch.",
+	}, []string{"source"})
+
+	// BudgetExceeded counts the mutations not done within the injection budget by namespace and
+	// action taken
+	BudgetExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injection_budget_exceeded_total",
+		Help:      "Number of mutations not done within the injection budget by namespace and action.",
+	}, []string{"namespace", "action"})
+
+	// WatcherHealthy is 1 while the files of a watcher are watched, 0 while its watch is broken
+	WatcherHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "watcher_healthy",
+		Help:      "Whether the files of the config and certificate watchers are watched, by watcher.",
+	}, []string{"watcher"})
+
+	// Leader is 1 while the replica holds the lease of the controllers, by lease
+	Leader = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether the replica holds the lease running the controllers, by lease.",
+	}, []string{"lease"})
+
+	// InjectedPods is the number of running injected pods by namespace, as of the last drift scan
+	InjectedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "injected_pods",
+		Help:      "Number of running injected pods by namespace, as of the last drift scan.",
+	}, []string{"namespace"})
+
+	// StalePods is the number of running pods whose sidecar differs from the config served by
+	// namespace and reason, as of the last drift scan
+	StalePods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stale_pods",
+		Help:      "Number of running pods with a stale sidecar by namespace and reason, missing, image or config, as of the last drift scan.",
+	}, []string{"namespace", "reason"})
+
+	// BuildInfo is 1 for the build of the injector
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build of the injector, labeled with its version, git commit, build date and Go version, always 1.",
+	}, []string{"version", "revision", "build_date", "goversion"})
+)
+
+func init() {
+	prometheus.MustRegister(Injections, WorkloadInjections, Removals, InjectionSkips, InjectionErrors, PatchOperations, PatchDuration, PatchCacheLookups, AdmissionDuration)
+	prometheus.MustRegister(ConfigReloads, ConfigValidationErrors, ConfigLastReloadSuccess, ConfigInfo, InflightRequests, ThrottledRequests, CertificateExpiry)
+	prometheus.MustRegister(BudgetExceeded, WatcherHealthy, Leader, InjectedPods, StalePods, BuildInfo)
+	BuildInfo.WithLabelValues(version.Version, version.GitCommit, version.BuildDate, version.GoVersion).Set(1)
+}
+
+// Totals returns the values of the counters of vec summed by their labels key and value, such as
+// the namespace and reason of InjectionErrors
+func Totals(vec *prometheus.CounterVec, key, value string) map[string]map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+	totals := map[string]map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil || pb.Counter == nil {
+			continue
+		}
+		var k, v string
+		for _, l := range pb.Label {
+			switch l.GetName() {
+			case key:
+				k = l.GetValue()
+			case value:
+				v = l.GetValue()
+			}
+		}
+		if totals[k] == nil {
+			totals[k] = map[string]float64{}
+		}
+		totals[k][v] += pb.Counter.GetValue()
+	}
+	return totals
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PolicyReconciler keeps the index in sync with the SidecarInjectionPolicy resources
+type PolicyReconciler struct {
+	Client client.Client
+	Index  *Index
+}
+
+// Reconcile updates the index with the current state of a policy
+func (r *PolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var p v1alpha1.SidecarInjectionPolicy
+	if err := r.Client.Get(ctx, req.NamespacedName, &p); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Index.DeletePolicy(req.Name)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Index.SetPolicy(p.Name, p.Spec); err != nil {
+		// an invalid selector won't get better by retrying
+		log.Errorf("ignoring SidecarInjectionPolicy %s: %v", p.Name, err)
+		r.Index.DeletePolicy(p.Name)
+	}
+	return ctrl.Result{}, nil
+}
+
+// NamespaceReconciler keeps the namespace labels of the index up to date
+type NamespaceReconciler struct {
+	Client client.Client
+	Index  *Index
+}
+
+// Reconcile updates the index with the current labels of a namespace
+func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var ns corev1.Namespace
+	if err := r.Client.Get(ctx, req.NamespacedName, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Index.DeleteNamespace(req.Name)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	r.Index.SetNamespace(ns.Name, ns.Labels)
+	return ctrl.Result{}, nil
+}
+
+// Setup registers the controllers feeding the index with the manager
+func Setup(mgr ctrl.Manager, index *Index) error {
+	err := ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.SidecarInjectionPolicy{}).
+		Complete(&PolicyReconciler{Client: mgr.GetClient(), Index: index})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Namespace{}).
+		Complete(&NamespaceReconciler{Client: mgr.GetClient(), Index: index})
+}
@@ -0,0 +1,142 @@
+// Package policy builds an in-memory index of the SidecarInjectionPolicy resources
+package policy
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Decision is the result of evaluating the policies for a pod
+type Decision struct {
+	// Policy is the name of the matching policy, empty if no policy matched
+	Policy string
+	// Excluded tells the pod must not be injected, Reason says why
+	Excluded      bool
+	Reason        string
+	DefaultPolicy v1alpha1.InjectionPolicy
+	Profile       string
+}
+
+// constant values for the Reason of a Decision excluding the pod
+const (
+	ReasonNamespaceExcluded = "namespace excluded"
+	ReasonPodExcluded       = "pod excluded"
+)
+
+type entry struct {
+	spec              v1alpha1.SidecarInjectionPolicySpec
+	namespaceSelector labels.Selector
+	excludedPods      labels.Selector
+}
+
+// Index holds the policies and the namespace labels needed to evaluate them
+type Index struct {
+	lock       sync.RWMutex
+	policies   map[string]*entry
+	names      []string
+	namespaces map[string]labels.Set
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{
+		policies:   map[string]*entry{},
+		namespaces: map[string]labels.Set{},
+	}
+}
+
+// SetPolicy adds or replaces a policy
+func (i *Index) SetPolicy(name string, spec v1alpha1.SidecarInjectionPolicySpec) error {
+	e := &entry{spec: spec, namespaceSelector: labels.Everything()}
+	if spec.NamespaceSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+		if err != nil {
+			return err
+		}
+		e.namespaceSelector = s
+	}
+	// a nil selector selects nothing
+	s, err := metav1.LabelSelectorAsSelector(spec.ExcludedPodSelector)
+	if err != nil {
+		return err
+	}
+	e.excludedPods = s
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.policies[name] = e
+	i.sortNames()
+	return nil
+}
+
+// DeletePolicy removes a policy
+func (i *Index) DeletePolicy(name string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.policies, name)
+	i.sortNames()
+}
+
+// SetNamespace records the labels of a namespace
+func (i *Index) SetNamespace(name string, l map[string]string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.namespaces[name] = labels.Set(l)
+}
+
+// NamespaceLabels returns the labels of a namespace, nil if it is unknown. They must not be modified.
+func (i *Index) NamespaceLabels(name string) map[string]string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.namespaces[name]
+}
+
+// DeleteNamespace forgets a namespace
+func (i *Index) DeleteNamespace(name string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.namespaces, name)
+}
+
+// sortNames keeps the evaluation order deterministic, caller must hold the lock
+func (i *Index) sortNames() {
+	i.names = i.names[:0]
+	for name := range i.policies {
+		i.names = append(i.names, name)
+	}
+	sort.Strings(i.names)
+}
+
+// Evaluate returns the decision of the first policy, in name order, matching the pod
+func (i *Index) Evaluate(namespace string, podLabels map[string]string) Decision {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	nsLabels := i.namespaces[namespace]
+	for _, name := range i.names {
+		e := i.policies[name]
+		for _, ns := range e.spec.ExcludedNamespaces {
+			if ns == namespace {
+				return Decision{Policy: name, Excluded: true, Reason: ReasonNamespaceExcluded}
+			}
+		}
+		if !e.namespaceSelector.Matches(nsLabels) {
+			continue
+		}
+		d := Decision{
+			Policy:        name,
+			Excluded:      e.excludedPods.Matches(labels.Set(podLabels)),
+			DefaultPolicy: e.spec.DefaultPolicy,
+			Profile:       e.spec.Profile,
+		}
+		if d.Excluded {
+			d.Reason = ReasonPodExcluded
+		}
+		return d
+	}
+	return Decision{}
+}
@@ -0,0 +1,220 @@
+// Package registration keeps the MutatingWebhookConfiguration of the injector in line with its flags,
+// and generates its webhook configurations
+package registration
+
+import (
+	"context"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resyncPeriod is how often the configuration is compared with the spec without any event
+const resyncPeriod = 10 * time.Minute
+
+// Spec is the MutatingWebhookConfiguration the injector wants
+type Spec struct {
+	// Name is the name of the MutatingWebhookConfiguration, WebhookName the one of its webhook
+	Name        string
+	WebhookName string
+	// Service, Namespace, Port and Path locate the webhook
+	Service   string
+	Namespace string
+	Port      int32
+	Path      string
+	// NamespaceSelector and ObjectSelector restrict the objects sent to the webhook
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+	FailurePolicy     admissionregistrationv1.FailurePolicyType
+	TimeoutSeconds    int32
+	// CABundle verifies the certificate of the webhook, the one of the cluster object is kept
+	// when empty, e.g. when -bootstrapCerts or a CA injector set it
+	CABundle []byte
+}
+
+// rules are the objects the injector mutates, the pods and the pod templates of the workloads
+var rules = []admissionregistrationv1.RuleWithOperations{
+	rule("", []string{"v1"}, "pods"),
+	rule("apps", []string{"v1"}, "deployments", "statefulsets", "daemonsets"),
+	rule("batch", []string{"v1", "v1beta1"}, "jobs", "cronjobs"),
+}
+
+// rule returns the rule for the creations and updates of resources
+func rule(group string, versions []string, resources ...string) admissionregistrationv1.RuleWithOperations {
+	scope := admissionregistrationv1.AllScopes
+	return admissionregistrationv1.RuleWithOperations{
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: versions,
+			Resources:   resources,
+			Scope:       &scope,
+		},
+	}
+}
+
+// webhook returns the webhook of the spec, with the values the API server would default so it
+// compares equal with the stored one. caBundle is the one to use.
+func (s Spec) webhook(caBundle []byte) admissionregistrationv1.MutatingWebhook {
+	path, port := s.Path, s.Port
+	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	reinvocation := admissionregistrationv1.IfNeededReinvocationPolicy
+	matchPolicy := admissionregistrationv1.Equivalent
+	failurePolicy, timeout := s.FailurePolicy, s.TimeoutSeconds
+	namespaceSelector, objectSelector := s.NamespaceSelector, s.ObjectSelector
+	if namespaceSelector == nil {
+		namespaceSelector = &metav1.LabelSelector{}
+	}
+	if objectSelector == nil {
+		objectSelector = &metav1.LabelSelector{}
+	}
+
+	return admissionregistrationv1.MutatingWebhook{
+		Name: s.WebhookName,
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{
+				Name:      s.Service,
+				Namespace: s.Namespace,
+				Path:      &path,
+				Port:      &port,
+			},
+			CABundle: caBundle,
+		},
+		Rules:                   rules,
+		FailurePolicy:           &failurePolicy,
+		MatchPolicy:             &matchPolicy,
+		NamespaceSelector:       namespaceSelector,
+		ObjectSelector:          objectSelector,
+		SideEffects:             &sideEffects,
+		TimeoutSeconds:          &timeout,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+		ReinvocationPolicy:      &reinvocation,
+	}
+}
+
+// MutatingWebhookConfiguration returns the configuration of the spec, with its CABundle
+func (s Spec) MutatingWebhookConfiguration() *admissionregistrationv1.MutatingWebhookConfiguration {
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   s.Name,
+			Labels: map[string]string{"app": "sidecar-injector"},
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{s.webhook(s.CABundle)},
+	}
+	config.SetGroupVersionKind(admissionregistrationv1.SchemeGroupVersion.WithKind("MutatingWebhookConfiguration"))
+	return config
+}
+
+// ValidatingWebhookConfiguration returns the configuration named name of the validating webhook
+// of the injector, calling path with the rules, selectors and CABundle of the spec
+func (s Spec) ValidatingWebhookConfiguration(name, webhookName, path string) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	m := s.webhook(s.CABundle)
+	m.ClientConfig.Service.Path = &path
+	config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"app": "sidecar-injector"},
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    webhookName,
+			ClientConfig:            m.ClientConfig,
+			Rules:                   m.Rules,
+			FailurePolicy:           m.FailurePolicy,
+			MatchPolicy:             m.MatchPolicy,
+			NamespaceSelector:       m.NamespaceSelector,
+			ObjectSelector:          m.ObjectSelector,
+			SideEffects:             m.SideEffects,
+			TimeoutSeconds:          m.TimeoutSeconds,
+			AdmissionReviewVersions: m.AdmissionReviewVersions,
+		}},
+	}
+	config.SetGroupVersionKind(admissionregistrationv1.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration"))
+	return config
+}
+
+// Reconciler creates the MutatingWebhookConfiguration of a spec, and repairs it when it is
+// edited or deleted
+type Reconciler struct {
+	Client kubernetes.Interface
+	Spec   Spec
+}
+
+// Reconcile makes the cluster object match the spec
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	configs := r.Client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	current, err := configs.Get(ctx, r.Spec.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := configs.Create(ctx, r.Spec.MutatingWebhookConfiguration(), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Infof("Created MutatingWebhookConfiguration %s", r.Spec.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	caBundle := r.Spec.CABundle
+	if len(caBundle) == 0 {
+		for _, w := range current.Webhooks {
+			if w.Name == r.Spec.WebhookName {
+				caBundle = w.ClientConfig.CABundle
+			}
+		}
+	}
+	want := []admissionregistrationv1.MutatingWebhook{r.Spec.webhook(caBundle)}
+	if apiequality.Semantic.DeepEqual(current.Webhooks, want) {
+		return nil
+	}
+	config := current.DeepCopy()
+	config.Webhooks = want
+	if _, err := configs.Update(ctx, config, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Infof("Repaired MutatingWebhookConfiguration %s", r.Spec.Name)
+	return nil
+}
+
+// Watch reconciles on each change of the configuration, and every resyncPeriod, until ctx is
+// done. A single replica runs it, see package leader.
+func (r *Reconciler) Watch(ctx context.Context) {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(r.Client, resyncPeriod,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", r.Spec.Name).String()
+		}))
+	factory.Admissionregistration().V1().MutatingWebhookConfigurations().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(_, _ interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	factory.Start(ctx.Done())
+	notify()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			if err := r.Reconcile(ctx); err != nil {
+				log.Errorf("failed to reconcile MutatingWebhookConfiguration %s: %v", r.Spec.Name, err)
+				// the next event or resync tries again
+			}
+		}
+	}
+}
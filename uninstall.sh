@@ -5,6 +5,10 @@ kubectl delete svc sidecar-injector-webhook-mesher-svc -n chassis
 kubectl delete configmap mesher-configmap sidecar-injector-webhook-mesher-configmap -n chassis
 kubectl delete pod client -n chassis
 kubectl delete MutatingWebhookConfiguration sidecar-injector-webhook-mesher-cfg
+kubectl delete ValidatingWebhookConfiguration sidecar-injector-webhook-mesher-validation-cfg
 kubectl delete secrets sidecar-injector-webhook-mesher-certs -n chassis
+kubectl delete -f deploy/rbac.yaml -n chassis
+kubectl delete -f deploy/sidecarinjectionpolicy-crd.yaml
+kubectl delete -f deploy/sidecarconfiguration-crd.yaml
 
 kubectl delete ns chassis
\ No newline at end of file
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-chassis/sidecar-injector/webhook"
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// newValidateCommand returns the validate command: it loads the sidecar config of -config as the
+// webhook would, failing with each error on its own line if the config is invalid
+func newValidateCommand() *cobra.Command {
+	var file, valuesFile string
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check a sidecar config",
+		Args:  cobra.NoArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return validate(file, valuesFile)
+		},
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&file, "config", "sidecarconfig.yaml", "File containing the configuration, or directory whose *.yaml and *.json files are merged in name order.")
+	fs.StringVar(&valuesFile, "values", "", "Values file rendering -config as a template.")
+	return cmd
+}
+
+// validate loads file rendered with valuesFile and prints a summary of it
+func validate(file, valuesFile string) error {
+	config, err := webhook.NewFileSource(file, valuesFile, 0).Load()
+	if err != nil {
+		errs := []error{err}
+		if agg, ok := err.(utilerrors.Aggregate); ok {
+			errs = agg.Errors()
+		}
+		lines := make([]string, len(errs))
+		for i, e := range errs {
+			lines[i] = "  " + e.Error()
+		}
+		return fmt.Errorf("%s is invalid:\n%s", file, strings.Join(lines, "\n"))
+	}
+
+	profiles := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	fmt.Printf("%s is valid: %d containers, %d init containers, profiles %v, hash %s\n",
+		file, len(config.Containers), len(config.InitContainers), profiles, config.Hash())
+	return nil
+}
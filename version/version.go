@@ -0,0 +1,16 @@
+// Package version holds the version of the injector build
+package version
+
+import "runtime"
+
+// Version of the injector, GitCommit the commit it is built from and BuildDate when, in RFC 3339.
+// They are overridden at build time, see build.sh, with
+// -ldflags "-X github.com/go-chassis/sidecar-injector/version.Version=<version> -X ...GitCommit=<sha>"
+var (
+	Version   = "latest"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// GoVersion is the version of Go the injector is built with
+var GoVersion = runtime.Version()
@@ -0,0 +1,114 @@
+//go:build wazero
+// +build wazero
+
+// Package wasm runs mutators compiled to WebAssembly in the wazero sandbox, built with the
+// wazero tag. A module is a WASI command reading the pod JSON on its standard input and writing
+// the JSON array of its patch operations on its standard output; it has no access to the
+// network nor the files of the injector.
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/inject"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// memoryLimitPages bounds the memory of a module, 64 KiB pages: 64 MiB
+const memoryLimitPages = 1024
+
+// mutator runs a compiled module once per pod
+type mutator struct {
+	name    string
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	timeout time.Duration
+}
+
+// Register compiles the module of path and registers it as the mutator named after the file,
+// topology for topology.wasm, with order. Each run is stopped after timeout.
+func Register(path string, order int, timeout time.Duration) (string, error) {
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(memoryLimitPages))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return "", err
+	}
+	compiled, err := r.CompileModule(ctx, code)
+	if err != nil {
+		r.Close(ctx)
+		return "", fmt.Errorf("failed to compile %s: %v", path, err)
+	}
+	m := &mutator{
+		name:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		runtime: r,
+		module:  compiled,
+		timeout: timeout,
+	}
+	if err := inject.Register(m, order); err != nil {
+		r.Close(ctx)
+		return "", err
+	}
+	return m.name, nil
+}
+
+func (m *mutator) Name() string {
+	return m.name
+}
+
+func (m *mutator) Mutate(pod *corev1.Pod) ([]inject.Operation, error) {
+	in, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	// a fresh instance per pod, nothing is kept from a run to the next
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStdin(bytes.NewReader(in)).
+		WithStdout(&stdout).
+		WithStderr(&stderr)
+	instance, err := m.runtime.InstantiateModule(ctx, m.module, config)
+	if instance != nil {
+		defer instance.Close(ctx)
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("no answer within %v", m.timeout)
+		}
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return nil, nil
+	}
+	var ops []inject.Operation
+	if err := json.Unmarshal(stdout.Bytes(), &ops); err != nil {
+		return nil, fmt.Errorf("invalid operations: %v", err)
+	}
+	return ops, nil
+}
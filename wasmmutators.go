@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// wasmOrder is the order of the first WASM mutator, they run after the compiled in ones
+const wasmOrder = 1000
+
+// registerWASM registers the WASM module of path as a mutator and returns its name, nil in the
+// binaries built without the wazero tag
+var registerWASM func(path string, order int, timeout time.Duration) (string, error)
+
+// registerWASMMutators registers the WASM modules of the comma separated files, in order, and
+// returns the names of their mutators
+func registerWASMMutators(files string, timeout time.Duration) ([]string, error) {
+	paths := splitList(files)
+	if len(paths) > 0 && registerWASM == nil {
+		return nil, errors.New("wasmMutators needs a binary built with the wazero tag")
+	}
+	var names []string
+	for i, path := range paths {
+		name, err := registerWASM(path, wasmOrder+i, timeout)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -0,0 +1,10 @@
+//go:build wazero
+// +build wazero
+
+package main
+
+import "github.com/go-chassis/sidecar-injector/wasm"
+
+func init() {
+	registerWASM = wasm.Register
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// accessLog logs the requests of a handler to its own output, apart from the application logs
+type accessLog struct {
+	logger *log.Logger
+	// sampling is the share of the successful requests logged, the failed ones always are
+	sampling float64
+}
+
+// newAccessLog returns an access log appending JSON lines to file, the standard output if it is "-"
+func newAccessLog(file string, sampling float64) (*accessLog, error) {
+	out := os.Stdout
+	if file != "-" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	logger := log.New()
+	logger.Out = out
+	logger.Formatter = new(log.JSONFormatter)
+	return &accessLog{logger: logger, sampling: sampling}, nil
+}
+
+// statusRecorder keeps the status written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// wrap returns h logging each request once it is answered
+func (a *accessLog) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		if rec.status < http.StatusBadRequest && rand.Float64() >= a.sampling {
+			return
+		}
+		fields := log.Fields{
+			"method":       r.Method,
+			"path":         r.URL.Path,
+			"status":       rec.status,
+			"duration":     time.Since(start).String(),
+			"remoteAddr":   r.RemoteAddr,
+			"requestBytes": r.ContentLength,
+			"userAgent":    r.UserAgent(),
+		}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			fields["clientCN"] = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		a.logger.WithFields(fields).Info("access")
+	})
+}
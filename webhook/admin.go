@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// constant values for the result of an admin action
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+// authorized checks the bearer token of an admin request, writing the error response if it is not
+func (wh *WebHookServer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(wh.adminToken) == 0 {
+		http.Error(w, "admin endpoints are disabled, no admin token configured", http.StatusForbidden)
+		return false
+	}
+
+	if !tokenMatches(r, "Authorization", wh.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// tokenMatches tells whether header of r holds token, after the "Bearer " prefix of an
+// Authorization header
+func tokenMatches(r *http.Request, header string, token []byte) bool {
+	value := r.Header.Get(header)
+	if http.CanonicalHeaderKey(header) == "Authorization" {
+		value = strings.TrimPrefix(value, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(value), token) == 1
+}
+
+// writeJSON writes v as the JSON response body with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(resp); err != nil {
+		log.Errorf("Can't write response: %v", err)
+	}
+}
+
+// logLevelHandler serves GET /-/loglevel, answering with the log level, and PUT /-/loglevel
+// setting it from the level query parameter or the body, e.g. debug during an incident
+func (wh *WebHookServer) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPut)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !wh.authorized(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		value := r.URL.Query().Get("level")
+		if value == "" {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			value = strings.TrimSpace(string(body))
+		}
+		level, err := log.ParseLevel(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Warnf("log level set to %s by %s", level, r.RemoteAddr)
+		log.SetLevel(level)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Level string `json:"level"`
+	}{Level: log.GetLevel().String()})
+}
+
+// reloadHandler serves POST /-/reload, answering with the result of the reload
+func (wh *WebHookServer) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !wh.authorized(w, r) {
+		return
+	}
+
+	log.Infof("reload requested by %s", r.RemoteAddr)
+	result := struct {
+		Result string `json:"result"`
+		Error  string `json:"error,omitempty"`
+	}{Result: resultSuccess}
+	status := http.StatusOK
+	if err := wh.Reload(); err != nil {
+		result.Result, result.Error = resultFailure, err.Error()
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, result)
+}
+
+// AdminHandler serves the probes, metrics, config status, statistics, version and injection checks
+func (wh *WebHookServer) AdminHandler() http.Handler {
+	h := http.NewServeMux()
+	h.HandleFunc("/healthz", wh.healthz)
+	h.HandleFunc("/readyz", wh.readyz)
+	h.Handle("/metrics", promhttp.Handler())
+	h.HandleFunc("/configz", wh.configz)
+	h.HandleFunc("/configversion", wh.configVersionHandler)
+	h.HandleFunc("/statz", wh.statz)
+	h.HandleFunc("/debug/config", wh.debugConfig)
+	h.HandleFunc("/version", versionHandler)
+	h.HandleFunc("/check", wh.checkHandler)
+	if wh.parms.EnableDebugHandlers {
+		wh.registerDebugHandlers(h)
+	}
+	return h
+}
+
+// versionHandler serves the version and build of the injector
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Version   string `json:"version"`
+		GitCommit string `json:"gitCommit"`
+		BuildDate string `json:"buildDate"`
+		GoVersion string `json:"goVersion"`
+	}{
+		Version:   version.Version,
+		GitCommit: version.GitCommit,
+		BuildDate: version.BuildDate,
+		GoVersion: version.GoVersion,
+	})
+}
+
+// configVersion is the config in effect, answered by /configversion
+type configVersion struct {
+	Hash string `json:"hash"`
+	// Generation is the version of the config in its source, such as the resourceVersion of the
+	// ConfigMap or the generation of the SidecarConfiguration
+	Generation string    `json:"generation,omitempty"`
+	Source     string    `json:"source"`
+	LoadedAt   time.Time `json:"loadedAt"`
+	// Acknowledged tells whether the hash and generation asked for are in effect
+	Acknowledged *bool `json:"acknowledged,omitempty"`
+}
+
+// configVersionHandler serves the hash and generation of the config in effect. Given the hash or
+// generation query parameters it answers 409 unless they are in effect, so rollout tools can
+// wait for every replica to acknowledge a push.
+func (wh *WebHookServer) configVersionHandler(w http.ResponseWriter, r *http.Request) {
+	c := wh.SidecarConfig()
+	if c == nil {
+		http.Error(w, "no sidecar config loaded", http.StatusServiceUnavailable)
+		return
+	}
+	v := configVersion{Hash: c.hash, Generation: c.generation, Source: c.origin, LoadedAt: c.loadedAt}
+	status := http.StatusOK
+	query := r.URL.Query()
+	hash, generation := query.Get("hash"), query.Get("generation")
+	if hash != "" || generation != "" {
+		acknowledged := (hash == "" || hash == c.hash) && (generation == "" || generation == c.generation)
+		v.Acknowledged = &acknowledged
+		if !acknowledged {
+			status = http.StatusConflict
+		}
+	}
+	writeJSON(w, status, v)
+}
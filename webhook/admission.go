@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultMaxRequestBytes is the default bound of the AdmissionReviews read: room for the object
+// and the old object of an update, each up to the 3MiB request body kube-apiserver accepts
+const DefaultMaxRequestBytes = 6 << 20
+
+// reviewV1beta1 is answered when the version of a review can't be told, as before admission/v1
+var reviewV1beta1 = v1beta1.SchemeGroupVersion.WithKind("AdmissionReview")
+
+// decodeReview decodes an admission/v1 or v1beta1 AdmissionReview, in JSON or protobuf. The request is returned as
+// admission/v1, the kind is the one the response must be sent as.
+func decodeReview(body []byte) (*admissionv1.AdmissionRequest, schema.GroupVersionKind, error) {
+	obj, gvk, err := deserializer.Decode(body, nil, nil)
+	if err != nil {
+		if gvk != nil {
+			return nil, *gvk, err
+		}
+		return nil, reviewV1beta1, err
+	}
+
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		if review.Request == nil {
+			return nil, *gvk, fmt.Errorf("%s has no request", gvk)
+		}
+		return review.Request, *gvk, nil
+	case *v1beta1.AdmissionReview:
+		if review.Request == nil {
+			return nil, *gvk, fmt.Errorf("%s has no request", gvk)
+		}
+		// both versions share their fields, v1 only makes some of them mandatory
+		var req admissionv1.AdmissionRequest
+		if err := convertReview(review.Request, &req); err != nil {
+			return nil, *gvk, err
+		}
+		return &req, *gvk, nil
+	default:
+		return nil, reviewV1beta1, fmt.Errorf("unexpected %s, expected an AdmissionReview", gvk)
+	}
+}
+
+// reviewMediaTypes are the encodings of the AdmissionReviews read and written, the first is the default
+var reviewMediaTypes = []string{runtime.ContentTypeJSON, runtime.ContentTypeProtobuf}
+
+// reviewMediaType returns the supported media type of a Content-Type or Accept header, if it is one
+func reviewMediaType(header string) (string, bool) {
+	for _, value := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		for _, supported := range reviewMediaTypes {
+			if mediaType == supported {
+				return mediaType, true
+			}
+		}
+	}
+	return "", false
+}
+
+// validateRequest checks a request is one the webhook is registered for, before processing it
+func validateRequest(req *admissionv1.AdmissionRequest) error {
+	if req.UID == "" {
+		return errors.New("request has no UID")
+	}
+	gk := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+	if !supportedKind(gk) {
+		return fmt.Errorf("kind %s is not supported", gk)
+	}
+	if req.SubResource != "" {
+		return fmt.Errorf("subresource %s of %s is not supported", req.SubResource, req.Resource.Resource)
+	}
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return fmt.Errorf("operation %s is not supported", req.Operation)
+	}
+	if len(req.Object.Raw) == 0 {
+		return errors.New("request has no object")
+	}
+	return nil
+}
+
+// encodeReview wraps resp in an AdmissionReview of the version given by gvk, encoded as mediaType
+// to buf
+func encodeReview(buf *bytes.Buffer, gvk schema.GroupVersionKind, mediaType string, resp *admissionv1.AdmissionResponse) error {
+	var review runtime.Object
+	if gvk.GroupVersion() == admissionv1.SchemeGroupVersion {
+		v1Review := &admissionv1.AdmissionReview{Response: resp}
+		// admission/v1 responses must carry their apiVersion and kind
+		v1Review.SetGroupVersionKind(gvk)
+		review = v1Review
+	} else {
+		v1beta1Review := &v1beta1.AdmissionReview{Response: &v1beta1.AdmissionResponse{}}
+		if err := convertReview(resp, v1beta1Review.Response); err != nil {
+			return err
+		}
+		v1beta1Review.SetGroupVersionKind(reviewV1beta1)
+		review = v1beta1Review
+	}
+
+	info, ok := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), mediaType)
+	if !ok {
+		return fmt.Errorf("unsupported media type %s", mediaType)
+	}
+	return info.Serializer.Encode(review, buf)
+}
+
+// convertReview converts between the admission/v1 and v1beta1 requests or responses
+func convertReview(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// failure answers a request the injector failed on. The object is rejected with a status
+// telling why, or admitted without sidecar when the injector fails open.
+func (wh *WebHookServer) failure(code int32, reason metav1.StatusReason, message string, err error) *admissionv1.AdmissionResponse {
+	message = fmt.Sprintf("sidecar injection failed: %s: %v", message, err)
+	if wh.parms.FailOpen {
+		log.WithField("reason", metrics.SkipError).Warnf("%s, admitting without sidecar", message)
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{skipWarning(metrics.SkipError, "%s, admitted without sidecar", message)},
+		}
+	}
+
+	log.Errorf("%s, rejecting", message)
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
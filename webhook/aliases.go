@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// annotationAlias is an annotation key honored as the inject key of the injector. Only that key is
+// looked up through the aliases, there are no prefix aliases.
+type annotationAlias struct {
+	alias string
+	key   string
+}
+
+// newAnnotationAliases returns the aliases of the alias to key map, in alias order so the lookups
+// are deterministic
+func newAnnotationAliases(aliases map[string]string) ([]annotationAlias, error) {
+	a := make([]annotationAlias, 0, len(aliases))
+	for alias, key := range aliases {
+		if alias == "" || key == "" || strings.HasSuffix(alias, "/") || strings.HasSuffix(key, "/") {
+			return nil, fmt.Errorf("invalid annotation alias %s=%s, expected two annotation keys", alias, key)
+		}
+		a = append(a, annotationAlias{alias: alias, key: key})
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].alias < a[j].alias })
+	return a, nil
+}
+
+// annotation returns the value of the annotation key of the injector in annotations, or of its
+// first alias there, and the key it was found at. The key of the injector wins over its aliases.
+func (wh *WebHookServer) annotation(annotations map[string]string, key string) (string, string) {
+	if v, ok := annotations[key]; ok {
+		return v, key
+	}
+	for _, a := range wh.aliases {
+		if a.key != key {
+			continue
+		}
+		if v, ok := annotations[a.alias]; ok {
+			return v, a.alias
+		}
+	}
+	return "", key
+}
@@ -0,0 +1,47 @@
+package webhook
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDecideAliases(t *testing.T) {
+	wh := newTestWebhook(t)
+	var err error
+	if wh.aliases, err = newAnnotationAliases(map[string]string{"sidecar.istio.io/inject": webhookInjectKey}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+		wantWarning bool
+	}{
+		{name: "yes", annotations: map[string]string{webhookInjectKey: "yes"}, want: true},
+		{name: "no", annotations: map[string]string{webhookInjectKey: "no"}},
+		// true and false are the values of the other meshes, not of the key of the injector
+		{name: "true", annotations: map[string]string{webhookInjectKey: "true"}, wantWarning: true},
+		{name: "alias true", annotations: map[string]string{"sidecar.istio.io/inject": "true"}, want: true},
+		{name: "alias false", annotations: map[string]string{"sidecar.istio.io/inject": "false"}},
+		{name: "alias yes", annotations: map[string]string{"sidecar.istio.io/inject": "yes"}, want: true},
+		{name: "key wins", annotations: map[string]string{webhookInjectKey: "no", "sidecar.istio.io/inject": "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			required, _, _, warnings := wh.decide(&metav1.ObjectMeta{Namespace: "default", Annotations: tt.annotations})
+			if required != tt.want {
+				t.Errorf("required is %v, expected %v", required, tt.want)
+			}
+			if (len(warnings) != 0) != tt.wantWarning {
+				t.Errorf("warnings %v", warnings)
+			}
+		})
+	}
+}
+
+func TestPrefixAliasRejected(t *testing.T) {
+	if _, err := newAnnotationAliases(map[string]string{"mesh.example.com/": "sidecar-injector-mesher.io/"}); err == nil {
+		t.Error("expected the prefix alias rejected")
+	}
+}
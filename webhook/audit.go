@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// auditRecord is the line of the audit trail about a mutation
+type auditRecord struct {
+	Time         time.Time       `json:"time"`
+	UID          string          `json:"uid"`
+	Kind         string          `json:"kind"`
+	Namespace    string          `json:"namespace"`
+	Name         string          `json:"name,omitempty"`
+	GenerateName string          `json:"generateName,omitempty"`
+	Identity     string          `json:"identity"`
+	Workload     string          `json:"workload,omitempty"`
+	User         string          `json:"user"`
+	Groups       []string        `json:"groups,omitempty"`
+	Profile      string          `json:"profile"`
+	ConfigHash   string          `json:"configHash"`
+	Patch        json.RawMessage `json:"patch"`
+}
+
+// auditSink writes a JSON line per mutation, with the full patch, to a file or the standard
+// output. The file is rotated under the lock of the writes, so no line is split or lost: once it
+// grows over maxBytes it is renamed to file.1, file.1 to file.2, and so on up to backups.
+type auditSink struct {
+	lock     sync.Mutex
+	file     string
+	out      io.Writer
+	size     int64
+	maxBytes int64
+	backups  int
+}
+
+// newAuditSink returns a sink appending to file, the standard output if it is "-". The file is
+// rotated once it reaches maxMB megabytes, never if 0, keeping backups old files.
+func newAuditSink(file string, maxMB, backups int) (*auditSink, error) {
+	a := &auditSink{file: file, maxBytes: int64(maxMB) << 20, backups: backups}
+	if file == "-" {
+		a.out = os.Stdout
+		a.maxBytes = 0
+		return a, nil
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open opens the file for appending
+func (a *auditSink) open() error {
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.out, a.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts the old files and starts a new one, the lock is held. The file is reopened even
+// when it can't be renamed, the lines keep being written.
+func (a *auditSink) rotate() error {
+	if c, ok := a.out.(io.Closer); ok {
+		c.Close()
+	}
+	var err error
+	if a.backups > 0 {
+		for i := a.backups - 1; i > 0; i-- {
+			// the missing backups are not an error
+			_ = os.Rename(fmt.Sprintf("%s.%d", a.file, i), fmt.Sprintf("%s.%d", a.file, i+1))
+		}
+		err = os.Rename(a.file, a.file+".1")
+	} else {
+		err = os.Remove(a.file)
+	}
+	if openErr := a.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// record writes the line of the mutation of the object of req, whose pod is pod, by patch
+func (a *auditSink) record(req *admissionv1.AdmissionRequest, pod *corev1.Pod, profile, configHash string, patch []byte) error {
+	// the name of a workload, or of the pod when it has one already
+	name := req.Name
+	if name == "" {
+		name = pod.Name
+	}
+	line, err := json.Marshal(auditRecord{
+		Time:         time.Now().UTC(),
+		UID:          string(req.UID),
+		Kind:         req.Kind.Kind,
+		Namespace:    req.Namespace,
+		Name:         name,
+		GenerateName: pod.GenerateName,
+		Identity:     podIdentity(req, pod),
+		Workload:     podWorkload(req, pod),
+		User:         req.UserInfo.Username,
+		Groups:       req.UserInfo.Groups,
+		Profile:      profile,
+		ConfigHash:   configHash,
+		Patch:        patch,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var rotateErr error
+	if a.maxBytes > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxBytes {
+		if rotateErr = a.rotate(); rotateErr != nil {
+			rotateErr = fmt.Errorf("failed to rotate %s: %v", a.file, rotateErr)
+		}
+	}
+	n, err := a.out.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
@@ -0,0 +1,286 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// DefaultCertValidity is the validity of the self-signed certificates
+const DefaultCertValidity = 365 * 24 * time.Hour
+
+// caCertKey is the Secret key of the CA certificate, as in the Secrets of cert-manager
+const caCertKey = "ca.crt"
+
+// CertBootstrap describes the self-signed CA and serving certificate the injector issues itself,
+// instead of the ones of an external script or cert-manager
+type CertBootstrap struct {
+	// Service is the name of the Service of the webhook, the certificate is valid for its DNS names
+	Service string
+	// Namespace is the namespace of the Service and of the Secret
+	Namespace string
+	// Secret is the name of the kubernetes.io/tls Secret storing the key pair and the CA
+	Secret string
+	// MutatingWebhookConfiguration and ValidatingWebhookConfiguration get the CA as caBundle,
+	// empty names are skipped
+	MutatingWebhookConfiguration   string
+	ValidatingWebhookConfiguration string
+	// Validity is the validity of the certificates, they are issued again once two thirds of it passed
+	Validity time.Duration
+}
+
+// serviceDNSNames returns the names the API server may use to reach a Service, the most
+// qualified last
+func serviceDNSNames(service, namespace string) []string {
+	return []string{
+		service,
+		service + "." + namespace,
+		service + "." + namespace + ".svc",
+	}
+}
+
+// dnsNames returns the names the certificate is valid for
+func (b CertBootstrap) dnsNames() []string {
+	return serviceDNSNames(b.Service, b.Namespace)
+}
+
+// BootstrapCerts makes sure the Secret holds a valid self-signed key pair for the Service, issuing
+// a new one if needed, and returns the PEM of its CA. Replicas starting together agree on the
+// Secret created first.
+func BootstrapCerts(client kubernetes.Interface, b CertBootstrap) ([]byte, error) {
+	secrets := client.CoreV1().Secrets(b.Namespace)
+	var ca []byte
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(context.TODO(), b.Secret, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: b.Secret, Namespace: b.Namespace},
+				Type:       corev1.SecretTypeTLS,
+			}
+		case err != nil:
+			return err
+		default:
+			reason := b.check(secret)
+			if reason == "" {
+				ca = secret.Data[caCertKey]
+				return nil
+			}
+			log.Infof("Issuing a new certificate in Secret %s/%s: %s", b.Namespace, b.Secret, reason)
+		}
+
+		if secret.Data, err = b.issue(); err != nil {
+			return err
+		}
+		if secret.ResourceVersion == "" {
+			_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// another replica was faster, use its certificate
+				return apierrors.NewConflict(corev1.Resource("secrets"), b.Secret, err)
+			}
+		} else {
+			_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		ca = secret.Data[caCertKey]
+		return nil
+	})
+	return ca, err
+}
+
+// check returns why the key pair of secret can't be served, empty if it can
+func (b CertBootstrap) check(secret *corev1.Secret) string {
+	if len(secret.Data[caCertKey]) == 0 {
+		return "no CA"
+	}
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		return "no certificate"
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err.Error()
+	}
+	if time.Until(leaf.NotAfter) < b.Validity/3 {
+		return fmt.Sprintf("expires at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	for _, name := range b.dnsNames() {
+		if err := leaf.VerifyHostname(name); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// issue generates a CA and a serving certificate signed by it, as the data of a Secret
+func (b CertBootstrap) issue() (map[string][]byte, error) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "sidecar-injector-ca@" + b.Namespace},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(b.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	names := b.dnsNames()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: names[len(names)-1]},
+		DNSNames:     names,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(b.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		caCertKey:               pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// IssueCerts generates a self-signed CA and a serving certificate signed by it, valid for the DNS
+// names of service in namespace during validity. It returns the PEM of the CA, certificate and key.
+func IssueCerts(service, namespace string, validity time.Duration) ([]byte, []byte, []byte, error) {
+	b := CertBootstrap{Service: service, Namespace: namespace, Validity: validity}
+	data, err := b.issue()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return data[caCertKey], data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey], nil
+}
+
+// serialNumber returns a random certificate serial number
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		// the system random source is broken, nothing else will work either
+		panic(err)
+	}
+	return n
+}
+
+// PatchCABundles sets ca as the caBundle of the webhooks of the configurations of b. The
+// configurations may be created after the injector, it tries again every interval until both
+// are patched or stop is closed.
+func PatchCABundles(client kubernetes.Interface, b CertBootstrap, ca []byte, interval time.Duration, stop <-chan struct{}) error {
+	mutating := b.MutatingWebhookConfiguration == ""
+	validating := b.ValidatingWebhookConfiguration == ""
+	return wait.PollImmediateUntil(interval, func() (bool, error) {
+		if !mutating {
+			mutating = patched("MutatingWebhookConfiguration", b.MutatingWebhookConfiguration,
+				patchMutating(client, b.MutatingWebhookConfiguration, ca))
+		}
+		if !validating {
+			validating = patched("ValidatingWebhookConfiguration", b.ValidatingWebhookConfiguration,
+				patchValidating(client, b.ValidatingWebhookConfiguration, ca))
+		}
+		return mutating && validating, nil
+	}, stop)
+}
+
+// patched logs the result of patching the caBundle of a configuration and tells whether it succeeded
+func patched(kind, name string, err error) bool {
+	switch {
+	case err == nil:
+		log.Infof("caBundle of %s %s is up to date", kind, name)
+		return true
+	case apierrors.IsNotFound(err):
+		log.Warnf("%s %s not found, its caBundle is patched once it exists", kind, name)
+	default:
+		log.Errorf("failed to patch the caBundle of %s %s: %v", kind, name, err)
+	}
+	return false
+}
+
+// patchMutating sets ca as the caBundle of the webhooks of a MutatingWebhookConfiguration
+func patchMutating(client kubernetes.Interface, name string, ca []byte) error {
+	configs := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config, err := configs.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, ca) {
+				config.Webhooks[i].ClientConfig.CABundle = ca
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		_, err = configs.Update(context.TODO(), config, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// patchValidating sets ca as the caBundle of the webhooks of a ValidatingWebhookConfiguration
+func patchValidating(client kubernetes.Interface, name string, ca []byte) error {
+	configs := client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config, err := configs.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, ca) {
+				config.Webhooks[i].ClientConfig.CABundle = ca
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		_, err = configs.Update(context.TODO(), config, metav1.UpdateOptions{})
+		return err
+	})
+}
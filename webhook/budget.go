@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// constant values for BudgetAction
+const (
+	// BudgetAllow admits the objects not injected within the budget without sidecar
+	BudgetAllow = "allow"
+	// BudgetDeny rejects them
+	BudgetDeny = "deny"
+)
+
+// withinBudget returns admit bounded by the injection budget of the parameters: an object not
+// mutated in time is admitted without sidecar, skipped with the error reason, or rejected, so a slow render can't hold the pod
+// creations up to the timeout of the API server, and its work is cancelled. admit is returned as
+// is without budget.
+func (wh *WebHookServer) withinBudget(admit admitFunc) admitFunc {
+	budget := wh.parms.InjectionBudget
+	if budget <= 0 {
+		return admit
+	}
+	return func(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		resp, err := runWithin(ctx, budget, req, admit)
+		if err == nil {
+			return resp
+		}
+		// the outer deadline passed or the API server went away, not the budget
+		if resp := givenUp(ctx, req); resp != nil {
+			return resp
+		}
+
+		action := wh.parms.BudgetAction
+		wh.effect(ctx, req, func() {
+			metrics.BudgetExceeded.WithLabelValues(req.Namespace, action).Inc()
+		})
+		requestLogger(req).WithFields(log.Fields{"budget": budget.String(), "action": action}).Warn("Injection budget exceeded")
+		if action == BudgetDeny {
+			return &admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusGatewayTimeout,
+					Reason:  metav1.StatusReasonTimeout,
+					Message: fmt.Sprintf("sidecar injection failed: not done within the budget of %v", budget),
+				},
+			}
+		}
+		wh.effect(ctx, req, func() {
+			metrics.InjectionSkips.WithLabelValues(req.Namespace, string(metrics.SkipError)).Inc()
+		})
+		warnings := []string{skipWarning(metrics.SkipError, "not done within the budget of %v, admitted without sidecar", budget)}
+		pod, prefix, err := podOf(req)
+		if err != nil {
+			return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+		}
+		// the status annotation tells the validating webhook the pod was skipped on purpose
+		return wh.skipped(req, pod, prefix, metrics.SkipError, warnings)
+	}
+}
+
+// validateBudgetAction checks the action taken once the budget is exceeded
+func validateBudgetAction(action string) error {
+	switch action {
+	case BudgetAllow, BudgetDeny:
+		return nil
+	default:
+		return fmt.Errorf("invalid injection budget action %q, expected allow or deny", action)
+	}
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// captureSink dumps a sample of the admission/v1 AdmissionReviews of the mutations, request and
+// response, sanitized by a redactor, one file each. They are replayed by Replay.
+type captureSink struct {
+	dir      string
+	sampling float64
+	// maxFiles bounds the files written since the start, so a forgotten capture can't fill the disk
+	maxFiles int32
+	written  int32
+	redactor *redactor
+}
+
+// newCaptureSink returns a sink writing to dir a share sampling of the reviews, up to maxFiles
+func newCaptureSink(dir string, sampling float64, maxFiles int, r *redactor) (*captureSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &captureSink{dir: dir, sampling: sampling, maxFiles: int32(maxFiles), redactor: r}, nil
+}
+
+// record writes the review of req answered by resp, if it is sampled
+func (c *captureSink) record(req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) error {
+	if rand.Float64() >= c.sampling {
+		return nil
+	}
+	if atomic.AddInt32(&c.written, 1) > c.maxFiles {
+		return nil
+	}
+
+	sanitized := req.DeepCopy()
+	// the extra user info may carry the ids of the credentials
+	sanitized.UserInfo.Extra = nil
+	sanitized.Object = runtime.RawExtension{Raw: c.redactor.document(req.Object.Raw)}
+	if len(req.OldObject.Raw) > 0 {
+		sanitized.OldObject = runtime.RawExtension{Raw: c.redactor.document(req.OldObject.Raw)}
+	}
+	review := admissionv1.AdmissionReview{Request: sanitized}
+	review.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
+	if resp != nil {
+		review.Response = resp.DeepCopy()
+		if len(resp.Patch) > 0 {
+			review.Response.Patch = []byte(c.redactor.patch(resp.Patch))
+		}
+	}
+	data, err := json.MarshalIndent(review, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// written aside and renamed, a file of the directory is always complete
+	name := filepath.Join(c.dir, fmt.Sprintf("%d-%s.json", time.Now().UnixNano(), req.UID))
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// NewReplayer returns a server without listener, running the mutation with the config of source
+// for Replay and Inject
+func NewReplayer(p WebHookParameters, source ConfigSource) (*WebHookServer, error) {
+	wh := &WebHookServer{source: source, parms: p}
+	var err error
+	if wh.redactor, err = newRedactor(p.LogRedactPatterns); err != nil {
+		return nil, fmt.Errorf("invalid log redaction pattern: %v", err)
+	}
+	if wh.mutators, err = loadMutators(p); err != nil {
+		return nil, err
+	}
+	if wh.aliases, err = newAnnotationAliases(p.AnnotationAliases); err != nil {
+		return nil, err
+	}
+	wh.reloadConfig(source.Load())
+	if wh.SidecarConfig() == nil {
+		wh.Lock.RLock()
+		defer wh.Lock.RUnlock()
+		return nil, fmt.Errorf("no config loaded from %s: %v", source, wh.configError)
+	}
+	return wh, nil
+}
+
+// Replay runs the request of a captured AdmissionReview through the mutation again, as a dry run
+// so no Event is recorded. It returns the response of the replay and the captured one, nil if the
+// review has none.
+func (wh *WebHookServer) Replay(review []byte) (*admissionv1.AdmissionResponse, *admissionv1.AdmissionResponse, error) {
+	var captured admissionv1.AdmissionReview
+	if err := json.Unmarshal(review, &captured); err != nil {
+		return nil, nil, err
+	}
+	if captured.Request == nil {
+		return nil, nil, fmt.Errorf("%s has no request", captured.GroupVersionKind())
+	}
+	req := captured.Request
+	dryRun := true
+	req.DryRun = &dryRun
+	if err := validateRequest(req); err != nil {
+		return nil, nil, err
+	}
+	return wh.mutation(context.Background(), req), captured.Response, nil
+}
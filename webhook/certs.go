@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/metrics"
+)
+
+// CertSource provides the serving key pair of the webhook, like ConfigSource provides its config
+type CertSource interface {
+	// Load loads the key pair now
+	Load() (*tls.Certificate, error)
+	// Watch calls notify with the result of each load following a change of the source,
+	// until stop is closed
+	Watch(notify func(*tls.Certificate, error), stop <-chan struct{}) error
+	// String describes the source in logs
+	String() string
+}
+
+// fileCertSource reads the key pair from PEM files, such as a mounted Secret
+type fileCertSource struct {
+	certFile string
+	keyFile  string
+	debounce time.Duration
+}
+
+// NewFileCertSource returns a source reading the key pair from PEM files, and reloading it
+// debounce after the files stopped changing
+func NewFileCertSource(certFile, keyFile string, debounce time.Duration) CertSource {
+	return &fileCertSource{certFile: certFile, keyFile: keyFile, debounce: debounce}
+}
+
+func (s *fileCertSource) Load() (*tls.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+func (s *fileCertSource) Watch(notify func(*tls.Certificate, error), stop <-chan struct{}) error {
+	fw, err := NewFileWatcher(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	go fw.Run(s.debounce, func() {
+		notify(s.Load())
+	}, stop)
+	return nil
+}
+
+func (s *fileCertSource) String() string {
+	return "files " + s.certFile + " and " + s.keyFile
+}
+
+// servingCert is a key pair and the end of validity of its leaf certificate
+type servingCert struct {
+	pair     *tls.Certificate
+	notAfter time.Time
+}
+
+// certProvider hands the current key pair of a source to the TLS handshakes. The pair is swapped
+// atomically, so a renewed certificate is served without restarting the listener.
+type certProvider struct {
+	source CertSource
+	cert   atomic.Value // servingCert
+}
+
+// newCertProvider returns a provider of the key pairs of source, it serves none until updated
+func newCertProvider(source CertSource) *certProvider {
+	return &certProvider{source: source}
+}
+
+// set validates pair and serves it from now on
+func (p *certProvider) set(pair *tls.Certificate) error {
+	leaf, err := leafOf(pair)
+	if err != nil {
+		return err
+	}
+	// parsed once for the handshakes matching the SNI
+	pair.Leaf = leaf
+	p.cert.Store(servingCert{pair: pair, notAfter: leaf.NotAfter})
+	metrics.CertificateExpiry.WithLabelValues(p.source.String()).Set(float64(leaf.NotAfter.Unix()))
+	return nil
+}
+
+// update serves the key pair loaded from the source, the previous pair is kept when the load failed
+func (p *certProvider) update(pair *tls.Certificate, err error) error {
+	if err == nil {
+		err = p.set(pair)
+	}
+	if err != nil {
+		log.Errorf("Failed to load the key pair from %s: %v", p.source, err)
+		return err
+	}
+	log.Infof("Serving the certificate of %s valid until %s", p.source, p.NotAfter().Format(time.RFC3339))
+	return nil
+}
+
+// reload loads the key pair of the source again
+func (p *certProvider) reload() error {
+	return p.update(p.source.Load())
+}
+
+// watch serves the key pairs of the source as it changes, until stop is closed
+func (p *certProvider) watch(stop <-chan struct{}) {
+	if err := p.source.Watch(func(pair *tls.Certificate, err error) {
+		_ = p.update(pair, err)
+	}, stop); err != nil {
+		log.Errorf("failed to watch %s: %v", p.source, err)
+	}
+}
+
+// current returns the served key pair, nil if there is none
+func (p *certProvider) current() *tls.Certificate {
+	c, _ := p.cert.Load().(servingCert)
+	return c.pair
+}
+
+// GetCertificate is the tls.Config hook returning the current key pair
+func (p *certProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	pair := p.current()
+	if pair == nil {
+		return nil, errors.New("no serving certificate loaded")
+	}
+	return pair, nil
+}
+
+// NotAfter returns the end of validity of the served certificate, zero if there is none
+func (p *certProvider) NotAfter() time.Time {
+	c, _ := p.cert.Load().(servingCert)
+	return c.notAfter
+}
+
+// CertKeyFiles are the PEM files of a key pair
+type CertKeyFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// getCertificate returns the first SNI key pair valid for the server name of hello, the
+// default one when none is
+func (wh *WebHookServer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName != "" {
+		for _, p := range wh.sniCerts {
+			if pair := p.current(); pair != nil && hello.SupportsCertificate(pair) == nil {
+				return pair, nil
+			}
+		}
+	}
+	return wh.certs.GetCertificate(hello)
+}
+
+// allCerts returns the providers of the key pairs served, the default one first
+func (wh *WebHookServer) allCerts() []*certProvider {
+	if wh.certs == nil {
+		return nil
+	}
+	return append([]*certProvider{wh.certs}, wh.sniCerts...)
+}
+
+// requireClientCert makes config verify the client certificates against the CA certificates of
+// caFile, and accept only the common names of allowedCNs when it is not empty
+func requireClientCert(config *tls.Config, caFile string, allowedCNs []string) error {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("no certificate in %s", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(allowedCNs) == 0 {
+		return nil
+	}
+
+	allowed := map[string]bool{}
+	for _, cn := range allowedCNs {
+		allowed[cn] = true
+	}
+	config.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+		// the chains are verified already, only their leaf matters
+		for _, chain := range chains {
+			if len(chain) > 0 && allowed[chain[0].Subject.CommonName] {
+				return nil
+			}
+		}
+		if len(chains) > 0 && len(chains[0]) > 0 {
+			return fmt.Errorf("client certificate common name %q not allowed", chains[0][0].Subject.CommonName)
+		}
+		return errors.New("no verified client certificate")
+	}
+	return nil
+}
+
+// certCheckInterval is how often the expiry of the served certificate is checked
+const certCheckInterval = time.Hour
+
+// monitorCert logs warnings, then errors, as the expiry of the served certificate approaches,
+// until stop is closed
+func (wh *WebHookServer) monitorCert(stop <-chan struct{}) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+	for {
+		wh.checkCertExpiry()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkCertExpiry logs how soon the served certificates expire when they are within the warning window
+func (wh *WebHookServer) checkCertExpiry() {
+	window := wh.parms.CertExpiryWarning
+	for _, p := range wh.allCerts() {
+		notAfter := p.NotAfter()
+		left := time.Until(notAfter)
+		switch {
+		case window <= 0 || left >= window:
+		case left <= 0:
+			log.Errorf("The certificate of %s expired at %s, the API server can't call the webhook", p.source, notAfter.Format(time.RFC3339))
+		case left < window/4:
+			log.Errorf("The certificate of %s expires in %s, at %s", p.source, left.Round(time.Minute), notAfter.Format(time.RFC3339))
+		default:
+			log.Warnf("The certificate of %s expires in %s, at %s", p.source, left.Round(time.Minute), notAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// leafOf returns the leaf certificate of pair
+func leafOf(pair *tls.Certificate) (*x509.Certificate, error) {
+	if len(pair.Certificate) == 0 {
+		return nil, errors.New("no certificate in the key pair")
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
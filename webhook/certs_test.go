@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKeyPair returns the PEM key pair of a self-signed certificate for localhost with serial
+func testKeyPair(t testing.TB, serial int64) ([]byte, []byte, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "sidecar-injector"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), cert
+}
+
+// TestCertRotationUnderLoad rotates the mounted key pair while clients keep handshaking: none of
+// the handshakes fails, and the new certificate is served once the files are swapped
+func TestCertRotationUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	oldCert, oldKey, oldLeaf := testKeyPair(t, 1)
+	newCert, newKey, newLeaf := testKeyPair(t, 2)
+	mountVolume(t, dir, "1", map[string][]byte{"tls.crt": oldCert, "tls.key": oldKey})
+
+	p := newCertProvider(NewFileCertSource(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), 50*time.Millisecond))
+	if err := p.reload(); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	p.watch(stop)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: p.GetCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	// the clients trust both certificates, as the API server trusts the CA of both
+	roots := x509.NewCertPool()
+	roots.AddCert(oldLeaf)
+	roots.AddCert(newLeaf)
+	handshake := func() (*big.Int, error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber, nil
+	}
+
+	var handshakes, failures int64
+	var firstErr atomic.Value
+	load := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-load:
+					return
+				default:
+				}
+				if _, err := handshake(); err != nil {
+					atomic.AddInt64(&failures, 1)
+					firstErr.Store(err)
+					continue
+				}
+				atomic.AddInt64(&handshakes, 1)
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	mountVolume(t, dir, "2", map[string][]byte{"tls.crt": newCert, "tls.key": newKey})
+
+	rotated := false
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if serial, err := handshake(); err == nil && serial.Cmp(newLeaf.SerialNumber) == 0 {
+			rotated = true
+			break
+		}
+	}
+	// keep the load on the new certificate for a while
+	time.Sleep(100 * time.Millisecond)
+	close(load)
+	wg.Wait()
+
+	if !rotated {
+		t.Error("the new certificate is not served")
+	}
+	if failures != 0 {
+		t.Errorf("%d of %d handshakes failed during the rotation, first: %v", failures, failures+handshakes, firstErr.Load())
+	}
+	if handshakes == 0 {
+		t.Error("no handshake completed")
+	}
+	if got := p.NotAfter(); !got.Equal(newLeaf.NotAfter) {
+		t.Errorf("NotAfter is %v, expected the one of the new certificate %v", got, newLeaf.NotAfter)
+	}
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretCertSource reads the key pair from a kubernetes.io/tls Secret through the API, so a
+// renewal is served as soon as the informer sees it instead of when kubelet syncs the volume
+type secretCertSource struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretCertSource returns a source reading the key pair from the tls.crt and tls.key
+// keys of a Secret, such as the ones issued by cert-manager
+func NewSecretCertSource(client kubernetes.Interface, namespace, name string) CertSource {
+	return &secretCertSource{client: client, namespace: namespace, name: name}
+}
+
+func (s *secretCertSource) Load() (*tls.Certificate, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(secret)
+}
+
+func (s *secretCertSource) parse(secret *corev1.Secret) (*tls.Certificate, error) {
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("%s has no key %q", s, key)
+		}
+	}
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: %v", s, secret.ResourceVersion, err)
+	}
+	return &pair, nil
+}
+
+func (s *secretCertSource) Watch(notify func(*tls.Certificate, error), stop <-chan struct{}) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}))
+
+	update := func(obj interface{}) {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			notify(s.parse(secret))
+		}
+	}
+	factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, obj interface{}) {
+			update(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			log.Warnf("%s was deleted, keeping the last key pair", s)
+		},
+	})
+	factory.Start(stop)
+	return nil
+}
+
+func (s *secretCertSource) String() string {
+	return fmt.Sprintf("Secret %s/%s", s.namespace, s.name)
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"sigs.k8s.io/yaml"
+)
+
+// reasonKindNotInjected is the reason of a check of an object the webhook doesn't mutate
+const reasonKindNotInjected = "kind_not_injected"
+
+// CheckResult is what the webhook would do with an object created now, answered by /check
+type CheckResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name,omitempty"`
+	// Inject tells whether the sidecar would be injected, Reason why not
+	Inject  bool   `json:"inject"`
+	Reason  string `json:"reason,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	// Status is the status annotation the object already has
+	Status     string   `json:"status,omitempty"`
+	ConfigHash string   `json:"configHash,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	// Patch is the JSON patch injecting the sidecar, redacted
+	Patch json.RawMessage `json:"patch,omitempty"`
+}
+
+// Check returns the decision of the webhook about the creation of the JSON object in namespace,
+// unless it has one, and the patch it would answer. Unlike a dry run nothing is counted, logged
+// to the audit trail nor recorded.
+func (wh *WebHookServer) Check(object []byte, namespace string) (*CheckResult, error) {
+	req, err := offlineRequest(object, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil {
+		var meta struct {
+			Kind string `json:"kind"`
+		}
+		_ = json.Unmarshal(object, &meta)
+		return &CheckResult{Kind: meta.Kind, Namespace: namespace, Reason: reasonKindNotInjected}, nil
+	}
+	pod, prefix, err := podOf(req)
+	if err != nil {
+		return nil, err
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+	result := &CheckResult{
+		Kind:      req.Kind.Kind,
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		Status:    pod.Annotations[webhookStatusKey],
+	}
+
+	required, profile, reason, warnings := wh.requiredMutation(&pod.ObjectMeta)
+	result.Inject, result.Profile, result.Reason, result.Warnings = required, profile, string(reason), warnings
+	if !required {
+		return result, nil
+	}
+
+	rootConfig := wh.SidecarConfig()
+	if rootConfig == nil {
+		return nil, fmt.Errorf("%s did not deliver a valid config yet", wh.source)
+	}
+	sidecar, profileWarnings, err := sidecarFor(rootConfig, pod, profile, wh.cniMode(pod.Namespace))
+	if err != nil {
+		return nil, fmt.Errorf("can't render the sidecar config: %v", err)
+	}
+	result.Warnings = append(result.Warnings, profileWarnings...)
+	patch, _, err := createpatch(pod, prefix, sidecar, wh.mutators)
+	if err != nil {
+		return nil, err
+	}
+	result.ConfigHash = rootConfig.Hash()
+	// the admin port is not authenticated, the sidecar may carry credentials in its env
+	result.Patch = json.RawMessage(wh.redactor.patch(patch))
+	return result, nil
+}
+
+// checkHandler serves POST /check, answering with the result of the Check of the YAML or JSON
+// object of the body in the namespace query parameter, default if empty
+func (wh *WebHookServer) checkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, wh.parms.MaxRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	object, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	result, err := wh.Check(object, namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
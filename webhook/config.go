@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config has container, volume and image information
+type Config struct {
+	Containers      []corev1.Container            `json:"containers,omitempty"`
+	InitContainers  []corev1.Container            `json:"initContainers,omitempty"`
+	Volumes         []corev1.Volume               `json:"volumes,omitempty"`
+	ImagePullSecret []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Annotations and Labels are set on the injected pods, without replacing the values the pods
+	// have unless the entry overrides them
+	Annotations []v1alpha1.PodMetadata `json:"annotations,omitempty"`
+	Labels      []v1alpha1.PodMetadata `json:"labels,omitempty"`
+	// PortsEnv names the env variable set in the sidecar containers to the ports of the app
+	// containers, comma separated, none if empty
+	PortsEnv string `json:"portsEnv,omitempty"`
+	// CNIAnnotations are set on the pods injected without the init containers, where the mesh CNI
+	// plugin intercepts the traffic
+	CNIAnnotations []v1alpha1.PodMetadata `json:"cniAnnotations,omitempty"`
+	Profiles       map[string]*Config     `json:"profiles,omitempty"`
+
+	// source is the document the config was decoded from, after templating and expansion
+	source []byte
+	// template renders the config for a given pod, nil when the config is not a template
+	template *configTemplate
+	// origin tells where the config was loaded from, generation which version of the source it
+	// is, such as the resourceVersion of the ConfigMap, empty for the files
+	origin     string
+	generation string
+	loadedAt   time.Time
+	hash       string
+	// legacy is set when a document of the config was converted from the legacy format
+	legacy bool
+}
+
+// stamp records where the config was loaded from, its generation there, when, and its content hash
+func (c *Config) stamp(origin, generation string) {
+	c.origin, c.generation = origin, generation
+	c.loadedAt = time.Now()
+	// the hash covers the decoded content, whatever the source and formatting
+	data, err := json.Marshal(c)
+	if err != nil {
+		log.Errorf("failed to hash the sidecar config: %v", err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	c.hash = hex.EncodeToString(sum[:])
+}
+
+// Hash returns the sha256 of the config content
+func (c *Config) Hash() string {
+	return c.hash
+}
+
+// constant values for the header of the config file
+const (
+	ConfigAPIVersion = "injector.mesher.io/v1"
+	ConfigKind       = "SidecarConfig"
+)
+
+// configHeader identifies the schema of a config file
+type configHeader struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+// configFile is the injector.mesher.io/v1 config file
+type configFile struct {
+	configHeader `json:",inline"`
+	Config       `json:",inline"`
+}
+
+// legacyConfig is the headerless config file, with the fields it had before the header: the
+// newer ones need the header
+type legacyConfig struct {
+	Containers       []corev1.Container            `json:"containers,omitempty"`
+	Volumes          []corev1.Volume               `json:"volumes,omitempty"`
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+func (l *legacyConfig) convert() *Config {
+	return &Config{
+		Containers:      l.Containers,
+		Volumes:         l.Volumes,
+		ImagePullSecret: l.ImagePullSecrets,
+		legacy:          true,
+	}
+}
+
+// warnLegacy warns once per load that the config, or one of its files, is in the legacy format
+func warnLegacy(cfg *Config, name string) {
+	if cfg.legacy {
+		log.Warnf("sidecar config %s has no apiVersion and kind, converted from the legacy format", name)
+	}
+}
+
+// loadConfig reads the config file, or merges the *.yaml and *.json files of a config directory
+// in name order. The files are templates of the values file if there is one.
+func loadConfig(cfgPath, valuesFile string) (*Config, error) {
+	files := []string{cfgPath}
+	info, err := os.Stat(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		if files, err = configFiles(cfgPath); err != nil {
+			return nil, err
+		}
+	}
+
+	docs := make([]document, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decryptSOPS(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		// expanded once, the templates are rendered for each pod from the expanded files
+		if data, err = expandEnv(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		docs = append(docs, document{name: file, data: data})
+	}
+
+	if valuesFile == "" {
+		cfg, err := buildConfig(docs)
+		if err != nil {
+			return nil, err
+		}
+		warnLegacy(cfg, cfgPath)
+		return cfg, nil
+	}
+
+	values, err := loadValues(valuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load values: %v", err)
+	}
+	// render for a pod without annotations nor ports to validate the template up front
+	t := &configTemplate{docs: docs, values: values}
+	cfg, err := t.render(&corev1.Pod{})
+	if err != nil {
+		return nil, err
+	}
+	cfg.template = t
+	// the renderings for the pods are not warned about again
+	warnLegacy(cfg, cfgPath)
+	return cfg, nil
+}
+
+// document is a config file and its content
+type document struct {
+	name string
+	data []byte
+}
+
+// buildConfig decodes and merges the documents, then validates the result
+func buildConfig(docs []document) (*Config, error) {
+	cfg := &Config{}
+	for _, doc := range docs {
+		c, err := decodeConfig(doc.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", doc.name, err)
+		}
+		cfg.merge(c)
+		cfg.legacy = cfg.legacy || c.legacy
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configFiles lists the config files of a directory in name order
+func configFiles(dir string) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.yaml or *.json file in config directory %s", dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// isJSON sniffs whether data is a JSON document rather than YAML
+func isJSON(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) != 0 && trimmed[0] == '{'
+}
+
+// unmarshalStrict decodes a YAML or JSON document, rejecting unknown fields
+func unmarshalStrict(data []byte, v interface{}) error {
+	if !isJSON(data) {
+		return yaml.UnmarshalStrict(data, v)
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
+
+// parseConfig decrypts, expands, decodes and validates a config
+func parseConfig(data []byte) (*Config, error) {
+	data, err := decryptSOPS(data)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = expandEnv(data); err != nil {
+		return nil, err
+	}
+	cfg, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	warnLegacy(cfg, "document")
+	return cfg, nil
+}
+
+// decodeConfig decodes an expanded config, rejecting unknown fields so typos fail loudly. It
+// doesn't log, it runs for each pod rendering a template.
+func decodeConfig(data []byte) (*Config, error) {
+	var header configHeader
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	var cfg *Config
+	switch {
+	case header.APIVersion == "" && header.Kind == "":
+		var legacy legacyConfig
+		if err := unmarshalStrict(data, &legacy); err != nil {
+			return nil, locateError(data, err)
+		}
+		cfg = legacy.convert()
+	case header.APIVersion == ConfigAPIVersion && header.Kind == ConfigKind:
+		var file configFile
+		if err := unmarshalStrict(data, &file); err != nil {
+			return nil, locateError(data, err)
+		}
+		cfg = &file.Config
+	default:
+		return nil, fmt.Errorf("unsupported sidecar config apiVersion %q kind %q, expected %s %s",
+			header.APIVersion, header.Kind, ConfigAPIVersion, ConfigKind)
+	}
+
+	cfg.source = data
+	return cfg, nil
+}
+
+var unknownField = regexp.MustCompile(`unknown field "([^"]+)"`)
+
+// locateError reports the line of the field a strict decoding error complains about
+func locateError(data []byte, err error) error {
+	m := unknownField.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t-{,")
+		if strings.HasPrefix(trimmed, m[1]+":") || strings.HasPrefix(trimmed, `"`+m[1]+`"`) {
+			return fmt.Errorf("line %d: unknown field %q", i+1, m[1])
+		}
+	}
+	return fmt.Errorf("unknown field %q", m[1])
+}
+
+// forProfile returns the config of the named profile, or the base config if there is none
+func (c *Config) forProfile(name string) *Config {
+	if name == "" {
+		return c
+	}
+	if p, ok := c.Profiles[name]; ok && p != nil {
+		return p
+	}
+	log.Warnf("profile %q is not configured, using the base config", name)
+	return c
+}
+
+// forPod renders the config for pod if it is a template, then selects the profile. The config
+// returned is defaulted, and must not be modified.
+func (c *Config) forPod(pod *corev1.Pod, profile string) (*Config, error) {
+	if c.template == nil {
+		return c.forProfile(profile), nil
+	}
+	rendered, err := c.template.render(pod)
+	if err != nil {
+		return nil, err
+	}
+	// the rendering is the pod's own, only the profile injected is defaulted
+	return rendered.forProfile(profile).defaulted(), nil
+}
+
+// defaulted returns a copy of c and its profiles with the sidecar defaulted as the API server
+// would, so the patches don't change the pods it stores. The copy shares nothing with c.
+func (c *Config) defaulted() *Config {
+	d := *c
+	spec := (&corev1.PodSpec{
+		Containers:       c.Containers,
+		InitContainers:   c.InitContainers,
+		Volumes:          c.Volumes,
+		ImagePullSecrets: c.ImagePullSecret,
+	}).DeepCopy()
+	// Workaround: https://github.com/kubernetes/kubernetes/issues/57982
+	applyDefaultsWorkaround(spec.Containers, spec.InitContainers, spec.Volumes, spec.ImagePullSecrets)
+	d.Containers, d.InitContainers, d.Volumes, d.ImagePullSecret = spec.Containers, spec.InitContainers, spec.Volumes, spec.ImagePullSecrets
+	d.Annotations = append([]v1alpha1.PodMetadata(nil), c.Annotations...)
+	d.Labels = append([]v1alpha1.PodMetadata(nil), c.Labels...)
+	d.CNIAnnotations = append([]v1alpha1.PodMetadata(nil), c.CNIAnnotations...)
+	if c.Profiles != nil {
+		d.Profiles = make(map[string]*Config, len(c.Profiles))
+		for name, p := range c.Profiles {
+			if p != nil {
+				d.Profiles[name] = p.defaulted()
+			}
+		}
+	}
+	return &d
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLegacyConfig(t *testing.T) {
+	legacy := `containers:
+- name: mesher
+  image: mesher:latest
+`
+	cfg, err := parseConfig([]byte(legacy))
+	if err != nil {
+		t.Fatalf("the legacy config is rejected: %v", err)
+	}
+	if !cfg.legacy || len(cfg.Containers) != 1 || cfg.Containers[0].Image != "mesher:latest" {
+		t.Errorf("the legacy config is converted to %+v", cfg)
+	}
+
+	// the fields added after the header are not part of the legacy format
+	for _, field := range []string{"initContainers", "profiles"} {
+		data := legacy + field + ":\n"
+		if _, err := parseConfig([]byte(data)); err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("expected %s rejected from the legacy format, got %v", field, err)
+		}
+	}
+
+	cfg, err = parseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.legacy {
+		t.Error("the config with a header is taken for a legacy one")
+	}
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultConfigMapKey is the ConfigMap key holding the sidecar config
+const DefaultConfigMapKey = "sidecarconfig.yaml"
+
+// configMapSource reads the config from a key of a ConfigMap through the API
+type configMapSource struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	key       string
+}
+
+// NewConfigMapSource returns a source reading the config from a key of a ConfigMap
+func NewConfigMapSource(client kubernetes.Interface, namespace, name, key string) ConfigSource {
+	return &configMapSource{client: client, namespace: namespace, name: name, key: key}
+}
+
+func (s *configMapSource) Load() (*Config, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(cm)
+}
+
+func (s *configMapSource) parse(cm *corev1.ConfigMap) (*Config, error) {
+	data, ok := cm.Data[s.key]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no key %q", s.namespace, s.name, s.key)
+	}
+	c, err := parseConfig([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+	c.stamp(fmt.Sprintf("%s@%s", s, cm.ResourceVersion), cm.ResourceVersion)
+	return c, nil
+}
+
+func (s *configMapSource) Watch(notify func(*Config, error), stop <-chan struct{}) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}))
+
+	update := func(obj interface{}) {
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			notify(s.parse(cm))
+		}
+	}
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, obj interface{}) {
+			update(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			log.Warnf("%s was deleted, keeping the last config", s)
+		},
+	})
+	factory.Start(stop)
+	return nil
+}
+
+func (s *configMapSource) String() string {
+	return fmt.Sprintf("ConfigMap %s/%s", s.namespace, s.name)
+}
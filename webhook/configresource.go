@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resourceSource reads the config from a SidecarConfiguration resource
+type resourceSource struct {
+	// reader reads from the API server, the cache is not started yet on the first load
+	reader client.Reader
+	client client.Client
+	name   types.NamespacedName
+
+	lock   sync.Mutex
+	notify func(*Config, error)
+}
+
+// NewResourceSource returns a source reading the config from a SidecarConfiguration,
+// its controller is registered with mgr
+func NewResourceSource(mgr ctrl.Manager, name types.NamespacedName) (ConfigSource, error) {
+	s := &resourceSource{reader: mgr.GetAPIReader(), client: mgr.GetClient(), name: name}
+	err := ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.SidecarConfiguration{}).
+		Complete(s)
+	return s, err
+}
+
+func (s *resourceSource) Load() (*Config, error) {
+	var sc v1alpha1.SidecarConfiguration
+	if err := s.reader.Get(context.TODO(), s.name, &sc); err != nil {
+		return nil, err
+	}
+	return s.config(&sc)
+}
+
+func (s *resourceSource) config(sc *v1alpha1.SidecarConfiguration) (*Config, error) {
+	c := configFromSpec(&sc.Spec)
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	c.stamp(s.String()+"@"+sc.ResourceVersion, strconv.FormatInt(sc.Generation, 10))
+	return c, nil
+}
+
+// Reconcile notifies the new config when the watched SidecarConfiguration changes
+func (s *resourceSource) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	s.lock.Lock()
+	notify := s.notify
+	s.lock.Unlock()
+	if req.NamespacedName != s.name || notify == nil {
+		return ctrl.Result{}, nil
+	}
+
+	var sc v1alpha1.SidecarConfiguration
+	if err := s.client.Get(ctx, req.NamespacedName, &sc); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Warnf("%s was deleted, keeping the last config", s)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	notify(s.config(&sc))
+	return ctrl.Result{}, nil
+}
+
+func (s *resourceSource) Watch(notify func(*Config, error), stop <-chan struct{}) error {
+	s.lock.Lock()
+	s.notify = notify
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *resourceSource) String() string {
+	return "SidecarConfiguration " + s.name.String()
+}
+
+func configFromTemplate(t *v1alpha1.SidecarTemplate) *Config {
+	return &Config{
+		Containers:      t.Containers,
+		InitContainers:  t.InitContainers,
+		Volumes:         t.Volumes,
+		ImagePullSecret: t.ImagePullSecrets,
+		Annotations:     t.Annotations,
+		Labels:          t.Labels,
+		PortsEnv:        t.PortsEnv,
+		CNIAnnotations:  t.CNIAnnotations,
+	}
+}
+
+func configFromSpec(spec *v1alpha1.SidecarConfigurationSpec) *Config {
+	spec = spec.DeepCopy()
+	c := configFromTemplate(&spec.SidecarTemplate)
+	if len(spec.Profiles) != 0 {
+		c.Profiles = make(map[string]*Config, len(spec.Profiles))
+		for name := range spec.Profiles {
+			t := spec.Profiles[name]
+			c.Profiles[name] = configFromTemplate(&t)
+		}
+	}
+	return c
+}
@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/certificate/csr"
+)
+
+// csrRetryInterval is the time before requesting a certificate again once a request failed
+const csrRetryInterval = time.Minute
+
+// csrCertSource requests the key pair through the certificates.k8s.io API: the private key never
+// leaves the process, the signer named in the request issues the certificate once approved
+type csrCertSource struct {
+	client     kubernetes.Interface
+	signerName string
+	dnsNames   []string
+	timeout    time.Duration
+
+	lock sync.Mutex
+	// current is the key pair issued last, loaded again until renewAt
+	current *tls.Certificate
+	// renewAt is when the certificate is requested again
+	renewAt time.Time
+	// renewing is set while a certificate is requested
+	renewing bool
+}
+
+// NewCSRCertSource returns a source requesting a certificate for the DNS names of the Service from
+// signerName, waiting up to timeout for it to be approved and issued. The certificate is requested
+// again once two thirds of its validity passed, the reloads until then load the same key pair.
+func NewCSRCertSource(client kubernetes.Interface, signerName, service, namespace string, timeout time.Duration) CertSource {
+	return &csrCertSource{
+		client:     client,
+		signerName: signerName,
+		dnsNames:   serviceDNSNames(service, namespace),
+		timeout:    timeout,
+	}
+}
+
+// Load returns the key pair issued last, a reload doesn't request a certificate and wait for it.
+// It is requested on the first load, and once the renewal is due and not in progress.
+func (s *csrCertSource) Load() (*tls.Certificate, error) {
+	s.lock.Lock()
+	current := s.current
+	keep := current != nil && (time.Now().Before(s.renewAt) || s.renewing)
+	s.lock.Unlock()
+	if keep {
+		return current, nil
+	}
+	return s.renew()
+}
+
+// renew requests a new key pair and loads it from now on, the renewal is retried a minute later
+// if it failed
+func (s *csrCertSource) renew() (*tls.Certificate, error) {
+	s.lock.Lock()
+	if s.renewing {
+		s.lock.Unlock()
+		return nil, errors.New("a certificate is being requested already")
+	}
+	s.renewing = true
+	s.lock.Unlock()
+
+	pair, err := s.request()
+	var leaf *x509.Certificate
+	if err == nil {
+		leaf, err = x509.ParseCertificate(pair.Certificate[0])
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.renewing = false
+	if err != nil {
+		s.renewAt = time.Now().Add(csrRetryInterval)
+		return nil, err
+	}
+	s.current = pair
+	s.renewAt = leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) * 2 / 3)
+	return pair, nil
+}
+
+// request creates a CertificateSigningRequest for a new key and waits for its certificate
+func (s *csrCertSource) request() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csrPEM, err := cert.MakeCSR(key, &pkix.Name{CommonName: s.dnsNames[len(s.dnsNames)-1]}, s.dnsNames, nil)
+	if err != nil {
+		return nil, err
+	}
+	usages := []certificatesv1.KeyUsage{
+		certificatesv1.UsageDigitalSignature,
+		certificatesv1.UsageKeyEncipherment,
+		certificatesv1.UsageServerAuth,
+	}
+	name, uid, err := csr.RequestCertificate(s.client, csrPEM, "", s.signerName, usages, key)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Waiting for CertificateSigningRequest %s to be approved and issued by %s", name, s.signerName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	certPEM, err := csr.WaitForCertificate(ctx, s.client, name, uid)
+	if err != nil {
+		return nil, fmt.Errorf("CertificateSigningRequest %s: %v", name, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+func (s *csrCertSource) Watch(notify func(*tls.Certificate, error), stop <-chan struct{}) error {
+	go func() {
+		for {
+			s.lock.Lock()
+			wait := time.Until(s.renewAt)
+			s.lock.Unlock()
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+				notify(s.renew())
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *csrCertSource) String() string {
+	return "CertificateSigningRequests to " + s.signerName
+}
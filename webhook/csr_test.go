@@ -0,0 +1,37 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+// TestCSRReloadKeepsPair checks a reload before the renewal loads the key pair issued last: the
+// source has no client, a CertificateSigningRequest would panic
+func TestCSRReloadKeepsPair(t *testing.T) {
+	certPEM, keyPEM, _ := testKeyPair(t, 1)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		renewAt  time.Time
+		renewing bool
+	}{
+		{name: "renewal not due", renewAt: time.Now().Add(time.Hour)},
+		{name: "renewal in progress", renewAt: time.Now().Add(-time.Minute), renewing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &csrCertSource{signerName: "example.com/webhooks", current: &pair, renewAt: tt.renewAt, renewing: tt.renewing}
+			got, err := s.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != &pair {
+				t.Error("Load returned another key pair than the current one")
+			}
+		})
+	}
+}
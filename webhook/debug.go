@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+)
+
+// expvar counters of the webhook, served with the runtime ones under /debug/vars
+var (
+	expvarReviews    = expvar.NewInt("admission_reviews")
+	expvarInjections = expvar.NewInt("injections")
+	expvarFailures   = expvar.NewInt("injection_failures")
+)
+
+// debugServer holds the *WebHookServer the expvar functions report on, the last one serving the
+// debug handlers. The variables can only be published once per process.
+var debugServer atomic.Value
+
+func init() {
+	expvar.Publish("inflight_requests", expvar.Func(func() interface{} {
+		if wh, _ := debugServer.Load().(*WebHookServer); wh != nil {
+			return len(wh.inflight)
+		}
+		return 0
+	}))
+	expvar.Publish("config_hash", expvar.Func(func() interface{} {
+		if wh, _ := debugServer.Load().(*WebHookServer); wh != nil {
+			if c := wh.SidecarConfig(); c != nil {
+				return c.Hash()
+			}
+		}
+		return ""
+	}))
+}
+
+// registerDebugHandlers serves the profiles of net/http/pprof under /debug/pprof/ and the expvar
+// variables at /debug/vars on h, reporting on wh. The CPU profile and trace block for their duration, the admin
+// server has no write timeout.
+func (wh *WebHookServer) registerDebugHandlers(h *http.ServeMux) {
+	debugServer.Store(wh)
+
+	h.HandleFunc("/debug/pprof/", pprof.Index)
+	h.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	h.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	h.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	h.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	h.Handle("/debug/vars", expvar.Handler())
+}
@@ -0,0 +1,22 @@
+package webhook
+
+import (
+	"expvar"
+	"net/http"
+	"testing"
+)
+
+func TestRegisterDebugHandlersTwice(t *testing.T) {
+	first, second := newTestWebhook(t), newTestWebhook(t)
+	// several servers in one process, as the fake ones of the tests, don't publish the variables again
+	first.registerDebugHandlers(http.NewServeMux())
+	second.registerDebugHandlers(http.NewServeMux())
+
+	v := expvar.Get("config_hash")
+	if v == nil {
+		t.Fatal("config_hash is not published")
+	}
+	if got, want := v.String(), `"`+second.SidecarConfig().Hash()+`"`; got != want {
+		t.Errorf("config_hash is %s, expected the one of the last server %s", got, want)
+	}
+}
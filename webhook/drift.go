@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/inject"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// statusDrifted is the state of an injected pod whose sidecar is gone, it has to be recreated
+const statusDrifted = "drifted"
+
+// repair checks an updated pod against its injection: a status annotation dropped by a
+// controller is restored, and a pod injected with the served config missing its sidecar
+// containers is flagged as drifted.
+// The containers themselves can't be added back, the pod spec is immutable.
+func (wh *WebHookServer) repair(req *admissionv1.AdmissionRequest, pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	var old corev1.Pod
+	if len(req.OldObject.Raw) != 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return wh.failure(http.StatusBadRequest, metav1.StatusReasonBadRequest, "can't decode the old object", err)
+		}
+	}
+
+	status := parseStatus(pod.Annotations[webhookStatusKey])
+	if status.State == "" {
+		// the annotation was removed by the update, keep the one of the injection
+		status = parseStatus(old.Annotations[webhookStatusKey])
+	}
+	if status.State != statusInjected && status.State != statusDrifted {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	rootConfig := wh.SidecarConfig()
+	if rootConfig == nil {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	var warnings []string
+	// the sidecar of a pod injected with another config can't be checked against the served one,
+	// its containers may have been renamed since: only the annotation is restored, the drift scan
+	// reports the pod stale
+	if status.ConfigHash == rootConfig.Hash() {
+		sidecarConfig, err := rootConfig.forPod(pod, status.Profile)
+		if err != nil {
+			// the config can't tell what the sidecar is, leave the pod alone
+			log.Warnf("Could not check %s/%s for drift: %v", pod.Namespace, pod.Name, err)
+			return &admissionv1.AdmissionResponse{Allowed: true}
+		}
+		status.State = statusInjected
+		if missing := missingSidecar(pod, sidecarConfig); missing != "" {
+			log.Warnf("Sidecar container %s of %s/%s is missing, marking it %s", missing, pod.Namespace, pod.Name, statusDrifted)
+			status.State = statusDrifted
+			warnings = append(warnings, fmt.Sprintf("sidecar container %s is missing, recreate the pod to inject it again", missing))
+		}
+	}
+
+	value := status.String()
+	if pod.Annotations[webhookStatusKey] == value {
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	}
+	log.Infof("Repairing status annotation of %s/%s: %s", pod.Namespace, pod.Name, value)
+	patch, err := json.Marshal(inject.Annotations(pod.Annotations, map[string]string{webhookStatusKey: value}, "/metadata/annotations"))
+	if err != nil {
+		return wh.failure(http.StatusInternalServerError, metav1.StatusReasonInternalError, "can't encode the patch", err)
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Patch:    patch,
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
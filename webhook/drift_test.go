@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestRepairChecksTheServedConfigOnly(t *testing.T) {
+	wh := newTestWebhook(t)
+	tests := []struct {
+		name        string
+		configHash  string
+		wantDrifted bool
+	}{
+		{name: "served config", configHash: wh.SidecarConfig().Hash(), wantDrifted: true},
+		// the sidecar may have been renamed since, the pod is the drift scan's
+		{name: "other config", configHash: "5f1c", wantDrifted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// an injected pod without its mesher container
+			pod := testPod("default", "web")
+			status := injectionStatus{State: statusInjected, ConfigHash: tt.configHash}
+			pod.Annotations[webhookStatusKey] = status.String()
+			req := podRequest(t, pod, false)
+			req.Operation = admissionv1.Update
+			req.OldObject.Raw, _ = json.Marshal(pod)
+
+			resp := wh.repair(req, pod)
+			if !resp.Allowed {
+				t.Fatalf("the update is denied: %+v", resp.Result)
+			}
+			drifted := strings.Contains(string(resp.Patch), statusDrifted)
+			if drifted != tt.wantDrifted {
+				t.Errorf("drifted is %v, expected %v, patch %s", drifted, tt.wantDrifted, resp.Patch)
+			}
+		})
+	}
+}
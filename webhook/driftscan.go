@@ -0,0 +1,245 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reasons of the stale pods
+const (
+	// driftMissing is a pod missing a container of its sidecar
+	driftMissing = "missing"
+	// driftImage is a pod whose sidecar runs another image than the config
+	driftImage = "image"
+	// driftConfig is a pod injected with another config, with the same images
+	driftConfig = "config"
+)
+
+// maxStaleWorkloads bounds the workloads listed in the status
+const maxStaleWorkloads = 100
+
+// DriftScanner compares the sidecar of the running injected pods with the config served, every
+// interval. The stale pods are annotated and counted by the metrics.
+type DriftScanner struct {
+	wh       *WebHookServer
+	client   kubernetes.Interface
+	interval time.Duration
+	// Report receives the result of each scan if not nil, such as to write the status of the
+	// SidecarConfiguration
+	Report func(ctx context.Context, status v1alpha1.SidecarConfigurationStatus) error
+	// Restarter rolls out the stale workloads after each scan if not nil
+	Restarter *Restarter
+}
+
+// NewDriftScanner returns a scanner of the pods of client against the config of wh
+func NewDriftScanner(wh *WebHookServer, client kubernetes.Interface, interval time.Duration) *DriftScanner {
+	return &DriftScanner{wh: wh, client: client, interval: interval}
+}
+
+// Run scans every interval until ctx is done. A single replica runs it, see package leader.
+func (d *DriftScanner) Run(ctx context.Context) {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		if err := d.Scan(ctx); err != nil {
+			log.Errorf("drift scan failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// workloadKey identifies a workload in a scan
+type workloadKey struct {
+	kind, namespace, name string
+}
+
+// Scan compares the running injected pods with the config served once, and returns the error
+// listing them or of Report
+func (d *DriftScanner) Scan(ctx context.Context) error {
+	rootConfig := d.wh.SidecarConfig()
+	if rootConfig == nil {
+		return errors.New("no sidecar config loaded")
+	}
+	hash := rootConfig.Hash()
+	status := v1alpha1.SidecarConfigurationStatus{ConfigHash: hash}
+	injected := map[string]int{}
+	stale := map[[2]string]int{}
+	workloads := map[workloadKey]int32{}
+	owners := map[string]*metav1.OwnerReference{}
+
+	opts := metav1.ListOptions{Limit: 500}
+	for {
+		pods, err := d.client.CoreV1().Pods("").List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			s := parseStatus(pod.Annotations[webhookStatusKey])
+			if s.State != statusInjected && s.State != statusDrifted {
+				continue
+			}
+			if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			injected[pod.Namespace]++
+			status.InjectedPods++
+
+			reason := podDrift(rootConfig, pod, s)
+			if err := d.annotate(ctx, pod, reason, hash); err != nil {
+				log.Warnf("failed to annotate the drift of %s/%s: %v", pod.Namespace, pod.Name, err)
+			}
+			if reason == "" {
+				continue
+			}
+			status.StalePods++
+			stale[[2]string{pod.Namespace, reason}]++
+			kind, name := d.workloadOf(ctx, pod, owners)
+			workloads[workloadKey{kind: kind, namespace: pod.Namespace, name: name}]++
+		}
+		if pods.Continue == "" {
+			break
+		}
+		opts.Continue = pods.Continue
+	}
+
+	metrics.InjectedPods.Reset()
+	for namespace, n := range injected {
+		metrics.InjectedPods.WithLabelValues(namespace).Set(float64(n))
+	}
+	metrics.StalePods.Reset()
+	for key, n := range stale {
+		metrics.StalePods.WithLabelValues(key[0], key[1]).Set(float64(n))
+	}
+
+	for key, pods := range workloads {
+		status.StaleWorkloads = append(status.StaleWorkloads, v1alpha1.StaleWorkload{Kind: key.kind, Namespace: key.namespace, Name: key.name, Pods: pods})
+	}
+	sort.Slice(status.StaleWorkloads, func(i, j int) bool {
+		a, b := status.StaleWorkloads[i], status.StaleWorkloads[j]
+		if a.Pods != b.Pods {
+			return a.Pods > b.Pods
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Kind+"/"+a.Name < b.Kind+"/"+b.Name
+	})
+	if d.Restarter != nil {
+		d.Restarter.Restart(ctx, hash, status.StaleWorkloads)
+	}
+	if len(status.StaleWorkloads) > maxStaleWorkloads {
+		status.StaleWorkloads = status.StaleWorkloads[:maxStaleWorkloads]
+	}
+	now := metav1.Now()
+	status.LastScanTime = &now
+	log.WithFields(log.Fields{"configHash": hash, "injected": status.InjectedPods, "stale": status.StalePods, "workloads": len(workloads)}).Info("Drift scan done")
+
+	if d.Report != nil {
+		return d.Report(ctx, status)
+	}
+	return nil
+}
+
+// podDrift returns why the sidecar of pod, injected as status tells, is stale for rootConfig,
+// empty if it is not
+func podDrift(rootConfig *Config, pod *corev1.Pod, status injectionStatus) string {
+	if status.State == statusDrifted {
+		return driftMissing
+	}
+	if status.ConfigHash == rootConfig.Hash() {
+		return ""
+	}
+	sidecarConfig, err := rootConfig.forPod(pod, status.Profile)
+	if err != nil {
+		return driftConfig
+	}
+	if missingSidecar(pod, sidecarConfig) != "" {
+		return driftMissing
+	}
+	images := map[string]string{}
+	// the pods of the informer cache and the served config are shared, their slices can't be
+	// appended to
+	for _, c := range allContainers(pod.Spec.InitContainers, pod.Spec.Containers) {
+		images[c.Name] = c.Image
+	}
+	for _, c := range allContainers(sidecarConfig.InitContainers, sidecarConfig.Containers) {
+		if image, ok := images[c.Name]; ok && image != c.Image {
+			return driftImage
+		}
+	}
+	return driftConfig
+}
+
+// annotate sets the outdated annotation of pod to hash if reason tells it is stale, and removes
+// it otherwise
+func (d *DriftScanner) annotate(ctx context.Context, pod *corev1.Pod, reason, hash string) error {
+	current, ok := pod.Annotations[webhookOutdatedKey]
+	var value interface{}
+	switch {
+	case reason != "" && current != hash:
+		value = hash
+	case reason == "" && ok:
+		// a null member of a merge patch removes it
+		value = nil
+	default:
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{webhookOutdatedKey: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// workloadOf returns the kind and name of the controller of pod, the Deployment of its ReplicaSet,
+// or the pod itself if it has none. The owners of the ReplicaSets are kept in owners.
+func (d *DriftScanner) workloadOf(ctx context.Context, pod *corev1.Pod, owners map[string]*metav1.OwnerReference) (string, string) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "Pod", pod.Name
+	}
+	if owner.Kind != "ReplicaSet" {
+		return owner.Kind, owner.Name
+	}
+	key := pod.Namespace + "/" + owner.Name
+	rsOwner, ok := owners[key]
+	if !ok {
+		rs, err := d.client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Debugf("failed to get ReplicaSet %s: %v", key, err)
+		} else {
+			rsOwner = metav1.GetControllerOf(rs)
+		}
+		owners[key] = rsOwner
+	}
+	if rsOwner != nil {
+		return rsOwner.Kind, rsOwner.Name
+	}
+	return owner.Kind, owner.Name
+}
+
+// allContainers returns the init containers followed by the containers, in a new slice
+func allContainers(initContainers, containers []corev1.Container) []corev1.Container {
+	return append(append([]corev1.Container(nil), initContainers...), containers...)
+}
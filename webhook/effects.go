@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sideEffects holds the side effects of an answer, its Events, audit records and counters, until
+// the answer is given to the API server: an answer given up on timeout must not record an
+// injection the API server never saw.
+type sideEffects struct {
+	lock    sync.Mutex
+	pending []func()
+	closed  bool
+}
+
+// sideEffectsKey is the context key of the side effects of an answer
+type sideEffectsKey struct{}
+
+// sideEffectsOf returns the side effects of the answer computed under ctx, nil outside of a review
+func sideEffectsOf(ctx context.Context) *sideEffects {
+	s, _ := ctx.Value(sideEffectsKey{}).(*sideEffects)
+	return s
+}
+
+// add defers f until the answer is given, f is run right away outside of a review. It is dropped
+// once the answer was given up.
+func (s *sideEffects) add(f func()) {
+	if s == nil {
+		f()
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.pending = append(s.pending, f)
+	}
+}
+
+// passTo hands the side effects over to parent, the answer they belong to is given there
+func (s *sideEffects) passTo(parent *sideEffects) {
+	s.lock.Lock()
+	pending := s.pending
+	s.pending, s.closed = nil, true
+	s.lock.Unlock()
+	for _, f := range pending {
+		parent.add(f)
+	}
+}
+
+// discard drops the side effects, and those added later
+func (s *sideEffects) discard() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending, s.closed = nil, true
+}
+
+// effect runs f, a side effect of the answer to req computed under ctx, once the answer is given.
+// Nothing is run for dry runs, the webhook is registered with sideEffects: NoneOnDryRun.
+func (wh *WebHookServer) effect(ctx context.Context, req *admissionv1.AdmissionRequest, f func()) {
+	if isDryRun(req) {
+		return
+	}
+	sideEffectsOf(ctx).add(f)
+}
+
+// givenUp answers req once its answer was given up under ctx, nil until then. The work stops
+// there, the answer is dropped anyway.
+func givenUp(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	requestLogger(req).WithField("error", err).Debug("Answer given up, stopping")
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusGatewayTimeout,
+			Reason:  metav1.StatusReasonTimeout,
+			Message: err.Error(),
+		},
+	}
+}
+
+// runWithin runs admit on req under ctx for timeout at most. The side effects of an answer given
+// in time are handed over to those of ctx, those of an answer given up are dropped, and admit is
+// cancelled through its context. It returns the error of ctx once timeout is over.
+func runWithin(ctx context.Context, timeout time.Duration, req *admissionv1.AdmissionRequest, admit admitFunc) (*admissionv1.AdmissionResponse, error) {
+	parent := sideEffectsOf(ctx)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	effects := &sideEffects{}
+	done := make(chan *admissionv1.AdmissionResponse, 1)
+	go func() {
+		done <- admit(context.WithValue(ctx, sideEffectsKey{}, effects), req)
+	}()
+	select {
+	case resp := <-done:
+		effects.passTo(parent)
+		return resp, nil
+	case <-ctx.Done():
+		effects.discard()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Events recorded about the injected objects
+const (
+	// EventInjected is a sidecar added to the object
+	EventInjected = "SidecarInjected"
+	// EventSkipped is an object opting out, or excluded by a policy or the kind of its controller
+	EventSkipped = "SidecarSkipped"
+	// EventRemoved is a sidecar removed from an object asking for it
+	EventRemoved = "SidecarRemoved"
+	// EventFailed is an object the injector failed on
+	EventFailed = "SidecarInjectionFailed"
+)
+
+// eventComponent is the source of the Events
+const eventComponent = "sidecar-injector"
+
+// NewEventRecorder returns a recorder creating the Events through client until stop is closed.
+// The broadcaster aggregates the repeated ones, a controller recreating its pods doesn't flood
+// the API server.
+func NewEventRecorder(client kubernetes.Interface, stop <-chan struct{}) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	go func() {
+		<-stop
+		broadcaster.Shutdown()
+	}()
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// event records an Event about the object of req, whose pod, or pod template, is pod. Nothing is
+// recorded without recorder, for dry runs, or when the object can't be referenced.
+func (wh *WebHookServer) event(req *admissionv1.AdmissionRequest, pod *corev1.Pod, eventtype, reason, messageFmt string, args ...interface{}) {
+	if wh.Events == nil || isDryRun(req) {
+		return
+	}
+	ref := eventReference(req, pod)
+	if ref == nil {
+		log.WithFields(log.Fields{"namespace": req.Namespace, "identity": podIdentity(req, pod), "reason": reason}).Debug("No object to record the Event on")
+		return
+	}
+	if req.Kind.Kind == podKind.Kind && ref.Kind != "Pod" {
+		// recorded on the controller, the message tells which of its pods
+		messageFmt = "Pod " + strings.Replace(podIdentity(req, pod), "%", "%%", -1) + ": " + messageFmt
+	}
+	wh.Events.Eventf(ref, eventtype, reason, messageFmt, args...)
+}
+
+// eventReference returns the object the Events about req are recorded on. The pods are not
+// created yet, those named by a controller are referenced through it: kubectl describe matches
+// the uid, which the pod only gets after admission, its controller has one already.
+func eventReference(req *admissionv1.AdmissionRequest, pod *corev1.Pod) *corev1.ObjectReference {
+	gk := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+	if gk != podKind {
+		// the workload itself, its uid is only known on update
+		if req.Name == "" {
+			return nil
+		}
+		var meta metav1.PartialObjectMetadata
+		_ = json.Unmarshal(req.Object.Raw, &meta)
+		return &corev1.ObjectReference{
+			APIVersion: schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}.String(),
+			Kind:       req.Kind.Kind,
+			Namespace:  req.Namespace,
+			Name:       req.Name,
+			UID:        meta.UID,
+		}
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return &corev1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Namespace:  pod.Namespace,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		}
+	}
+	if pod.Name == "" {
+		return nil
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
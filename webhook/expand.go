@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envReference matches ${VAR} and ${VAR:-fallback}, and their escaped form $${VAR}
+var envReference = regexp.MustCompile(`\$\$?\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces the environment variable references of data. The fallback of
+// ${VAR:-fallback} is used when VAR is unset or empty, a ${VAR} without fallback
+// must be set so a missing variable doesn't silently inject an empty value. $${VAR}
+// is written ${VAR}, for the shell scripts of the sidecar.
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	out := envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		if bytes.HasPrefix(ref, []byte("$$")) {
+			return ref[1:]
+		}
+		m := envReference.FindSubmatch(ref)
+		name := string(m[1])
+		value, ok := os.LookupEnv(name)
+		if value != "" || (ok && m[2] == nil) {
+			return []byte(value)
+		}
+		if m[2] != nil {
+			return m[3]
+		}
+		missing = append(missing, name)
+		return ref
+	})
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("undefined environment variables in sidecar config: %v", missing)
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("EXPAND_TEST_SET", "docker.io")
+	os.Setenv("EXPAND_TEST_EMPTY", "")
+	os.Unsetenv("EXPAND_TEST_UNSET")
+	defer os.Unsetenv("EXPAND_TEST_SET")
+	defer os.Unsetenv("EXPAND_TEST_EMPTY")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "image: ${EXPAND_TEST_SET}/mesher", want: "image: docker.io/mesher"},
+		{in: "image: ${EXPAND_TEST_UNSET:-quay.io}/mesher", want: "image: quay.io/mesher"},
+		{in: "image: ${EXPAND_TEST_EMPTY:-quay.io}/mesher", want: "image: quay.io/mesher"},
+		{in: "value: '${EXPAND_TEST_EMPTY}'", want: "value: ''"},
+		{in: "image: ${EXPAND_TEST_UNSET}/mesher", wantErr: true},
+		// escaped for the shell of the sidecar, even when unset
+		{in: "command: exec mesher --port $${EXPAND_TEST_UNSET}", want: "command: exec mesher --port ${EXPAND_TEST_UNSET}"},
+		{in: "command: echo $${EXPAND_TEST_SET:-x} ${EXPAND_TEST_SET}", want: "command: echo ${EXPAND_TEST_SET:-x} docker.io"},
+		{in: "command: echo $HOME", want: "command: echo $HOME"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv([]byte(tt.in))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expandEnv(%q) = %q, expected an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandEnv(%q): %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestTemplateExpandedOnce checks the references escaped in a template stay escaped once
+// rendered for a pod, the files are only expanded when loaded
+func TestTemplateExpandedOnce(t *testing.T) {
+	os.Unsetenv("EXPAND_TEST_UNSET")
+	dir := t.TempDir()
+	config := dir + "/sidecarconfig.yaml"
+	values := dir + "/values.yaml"
+	writeFile(t, config, `apiVersion: injector.mesher.io/v1
+kind: SidecarConfig
+containers:
+- name: mesher
+  image: {{ .Values.image }}
+  command: ["sh", "-c", "exec mesher --port $${EXPAND_TEST_UNSET}"]
+`)
+	writeFile(t, values, "image: mesher:latest\n")
+
+	c, err := loadConfig(config, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := c.forPod(testPod("default", "web"), "")
+	if err != nil {
+		t.Fatalf("the template rendered for the pod was expanded again: %v", err)
+	}
+	if got := rendered.Containers[0].Command[2]; got != "exec mesher --port ${EXPAND_TEST_UNSET}" {
+		t.Errorf("command rendered %q", got)
+	}
+}
+
+func writeFile(t *testing.T, file, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+)
+
+// configFence holds a reloaded config back until every replica loaded it, so they switch to it
+// together instead of injecting different sidecars meanwhile. The replicas tell each other the
+// config they staged through the SidecarInjector written by the StatusReporter.
+type configFence struct {
+	// timeout is how long a config is held back at most, so a replica that can't load it doesn't
+	// block the others forever
+	timeout time.Duration
+
+	mu       sync.Mutex
+	staged   *Config
+	stagedAt time.Time
+}
+
+// newConfigFence returns a fence holding the configs back for timeout at most, nil if disabled
+func newConfigFence(enabled bool, timeout time.Duration) *configFence {
+	if !enabled {
+		return nil
+	}
+	return &configFence{timeout: timeout}
+}
+
+// stage holds c back, replacing the config staged before
+func (f *configFence) stage(c *Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.staged != nil && f.staged.Hash() == c.Hash() {
+		return
+	}
+	f.staged, f.stagedAt = c, time.Now()
+	log.Infof("Staged config %s from %s until all the replicas loaded it", c.Hash(), c.origin)
+}
+
+// stagedHash returns the hash of the config held back, empty if none
+func (f *configFence) stagedHash() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.staged == nil {
+		return ""
+	}
+	return f.staged.Hash()
+}
+
+// release returns the config held back once each of replicas serves or staged it, or once it was
+// held back for timeout, and forgets it. It returns nil while it is held back.
+func (f *configFence) release(replicas []v1alpha1.InjectorReplica) *Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.staged == nil {
+		return nil
+	}
+	hash := f.staged.Hash()
+	if time.Since(f.stagedAt) < f.timeout {
+		for _, r := range replicas {
+			if r.ConfigHash != hash && r.StagedConfigHash != hash {
+				return nil
+			}
+		}
+	} else {
+		log.Warnf("Config %s staged for more than %v, switching to it without all the replicas", hash, f.timeout)
+	}
+	c := f.staged
+	f.staged = nil
+	return c
+}
+
+// clear forgets the config held back, when a config is served without fence
+func (f *configFence) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.staged = nil
+}
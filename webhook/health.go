@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// constant values for the names of the listeners
+const (
+	listenerWebhook  = "webhook"
+	listenerInsecure = "insecure"
+)
+
+// serveListener runs serve, the ListenAndServe of the listener named name, and records why it
+// stopped unless it was shut down
+func (wh *WebHookServer) serveListener(name string, serve func() error) {
+	err := serve()
+	if err == nil || err == http.ErrServerClosed {
+		return
+	}
+	log.Errorf("Failed to listen and serve %s server: %v", name, err)
+	wh.Lock.Lock()
+	defer wh.Lock.Unlock()
+	if wh.listenerErrors == nil {
+		wh.listenerErrors = map[string]error{}
+	}
+	wh.listenerErrors[name] = err
+}
+
+// healthChecks are the liveness conditions: a replica failing them can't recover by itself and
+// is better restarted. An invalid config is only fatal when none was ever loaded.
+func (wh *WebHookServer) healthChecks() []readyCheck {
+	return []readyCheck{
+		{name: "listeners", check: func() error {
+			wh.Lock.RLock()
+			defer wh.Lock.RUnlock()
+			for _, name := range []string{listenerWebhook, listenerInsecure} {
+				if err := wh.listenerErrors[name]; err != nil {
+					return fmt.Errorf("%s server stopped: %v", name, err)
+				}
+			}
+			return nil
+		}},
+		{name: "watcher", check: func() error {
+			wh.Lock.RLock()
+			defer wh.Lock.RUnlock()
+			if wh.watchError != nil {
+				return fmt.Errorf("%s not watched: %v", wh.source, wh.watchError)
+			}
+			return nil
+		}},
+		{name: "reload", check: func() error {
+			if wh.SidecarConfig() != nil {
+				return nil
+			}
+			wh.Lock.RLock()
+			defer wh.Lock.RUnlock()
+			if wh.configError != nil {
+				return fmt.Errorf("no config loaded, last reload failed: %v", wh.configError)
+			}
+			return nil
+		}},
+	}
+}
+
+// healthz tells whether the replica is alive: its listeners serve, its config source is watched
+// and a config was loaded once. Each check is listed in the body.
+func (wh *WebHookServer) healthz(w http.ResponseWriter, r *http.Request) {
+	writeChecks(w, wh.healthChecks())
+}
+
+// healthy runs the liveness checks, returning the first failure
+func (wh *WebHookServer) healthy() error {
+	for _, c := range wh.healthChecks() {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("%s: %v", c.name, err)
+		}
+	}
+	return nil
+}
+
+// writeHealthFile writes ok to file every interval while the replica is healthy, and removes it
+// when it is not, for the exec probes of older deployments, until stop is closed
+func (wh *WebHookServer) writeHealthFile(file string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := wh.healthy(); err != nil {
+			log.Warnf("Unhealthy, removing %s: %v", file, err)
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Errorf("failed to remove %s: %v", file, err)
+			}
+		} else if err := ioutil.WriteFile(file, []byte("ok"), 0644); err != nil {
+			log.Errorf("failed to write %s: %v", file, err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
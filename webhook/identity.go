@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"regexp"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// cronJobSuffix is the suffix of the Jobs of a CronJob, the minutes of their schedule since the epoch
+var cronJobSuffix = regexp.MustCompile(`-[0-9]{8,}$`)
+
+// templateHashSuffix is the suffix of a ReplicaSet named after the hash of its pod template,
+// encoded without vowels
+var templateHashSuffix = regexp.MustCompile(`-[bcdfghjklmnpqrstvwxz2456789]{5,10}$`)
+
+// appLabels are the labels naming the application of a pod without controller, in order
+var appLabels = []string{"app.kubernetes.io/name", "app"}
+
+// podIdentity names the pod of req, or the pod template, in the logs, events and audit records.
+// The pods of the controllers have no name yet at admission: they are named after their
+// generateName and workload, e.g. web-5d4f8b7c9d-* (Deployment/web).
+func podIdentity(req *admissionv1.AdmissionRequest, pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	workload := podWorkload(req, pod)
+	switch {
+	case pod.GenerateName != "" && workload != "":
+		return pod.GenerateName + "* (" + workload + ")"
+	case pod.GenerateName != "":
+		return pod.GenerateName + "*"
+	case workload != "":
+		return workload
+	}
+	return "<unnamed>"
+}
+
+// podWorkload returns the workload of the pod of req as kind/name: the object of req if it is a
+// workload, else the controller of the pod, the Deployment of its ReplicaSet. The pods without
+// controller are named after their application label, app=name, empty without one.
+func podWorkload(req *admissionv1.AdmissionRequest, pod *corev1.Pod) string {
+	if req.Kind.Kind != podKind.Kind && req.Name != "" {
+		return req.Kind.Kind + "/" + req.Name
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		// a ReplicaSet of a Deployment is named after it and the hash of the pod template
+		hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+		if owner.Kind == "ReplicaSet" && hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+		return owner.Kind + "/" + owner.Name
+	}
+	for _, label := range appLabels {
+		if app := pod.Labels[label]; app != "" {
+			return "app=" + app
+		}
+	}
+	return ""
+}
+
+// workloadLabel returns the workload of the pod of req as the label of the metrics: podWorkload,
+// with the names generated for each run or rollout trimmed so the series are bounded. The Jobs
+// of a CronJob are counted as CronJob/name, a ReplicaSet named after a template hash its pods
+// don't carry as ReplicaSet/name-*.
+func workloadLabel(req *admissionv1.AdmissionRequest, pod *corev1.Pod) string {
+	workload := podWorkload(req, pod)
+	switch {
+	case strings.HasPrefix(workload, "Job/") && cronJobSuffix.MatchString(workload):
+		return "CronJob/" + cronJobSuffix.ReplaceAllString(strings.TrimPrefix(workload, "Job/"), "")
+	case strings.HasPrefix(workload, "ReplicaSet/") && templateHashSuffix.MatchString(workload):
+		return templateHashSuffix.ReplaceAllString(workload, "-*")
+	}
+	return workload
+}
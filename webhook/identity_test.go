@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ownedPod returns a pod of the tests controlled by kind/name, with labels
+func ownedPod(kind, name string, labels map[string]string) *corev1.Pod {
+	controller := true
+	pod := testPod("default", "")
+	pod.Labels = labels
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+	return pod
+}
+
+func TestWorkloadLabel(t *testing.T) {
+	labelled := testPod("default", "web")
+	labelled.Labels = map[string]string{"app": "web"}
+	podReq := &admissionv1.AdmissionRequest{Kind: metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}}
+	tests := []struct {
+		name string
+		req  *admissionv1.AdmissionRequest
+		pod  *corev1.Pod
+		want string
+	}{
+		{
+			name: "Deployment",
+			req:  podReq,
+			pod:  ownedPod("ReplicaSet", "web-5d4f8b7c9d", map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "5d4f8b7c9d"}),
+			want: "Deployment/web",
+		},
+		{
+			name: "ReplicaSet without hash label",
+			req:  podReq,
+			pod:  ownedPod("ReplicaSet", "web-5d4f8b7c9d", nil),
+			want: "ReplicaSet/web-*",
+		},
+		{
+			name: "ReplicaSet named by hand",
+			req:  podReq,
+			pod:  ownedPod("ReplicaSet", "web-backend", nil),
+			want: "ReplicaSet/web-backend",
+		},
+		{
+			name: "Job of a CronJob",
+			req:  podReq,
+			pod:  ownedPod("Job", "backup-27890123", nil),
+			want: "CronJob/backup",
+		},
+		{
+			name: "Job",
+			req:  podReq,
+			pod:  ownedPod("Job", "migrate-v2", nil),
+			want: "Job/migrate-v2",
+		},
+		{
+			name: "Job created by a CronJob",
+			req:  &admissionv1.AdmissionRequest{Kind: metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, Name: "backup-27890124"},
+			pod:  testPod("default", ""),
+			want: "CronJob/backup",
+		},
+		{
+			name: "StatefulSet",
+			req:  podReq,
+			pod:  ownedPod("StatefulSet", "db", nil),
+			want: "StatefulSet/db",
+		},
+		{
+			name: "app label",
+			req:  podReq,
+			pod:  labelled,
+			want: "app=web",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workloadLabel(tt.req, tt.pod); got != tt.want {
+				t.Errorf("workloadLabel = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
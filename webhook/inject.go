@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Inject reads the YAML documents of a manifest from r and writes them to w, with the sidecar
+// injected in the pods and workloads as the webhook would do on their creation, in namespace
+// unless they have one. The other documents are written unchanged in the manifest format, and
+// left out of the patches.
+func (wh *WebHookServer) Inject(r io.Reader, w io.Writer, namespace string, format OutputFormat) error {
+	return patchManifest(r, w, namespace, wh.mutation, format)
+}
+
+// patchManifest reads the YAML documents of a manifest from r and writes them to w in format,
+// patched as admit answers the dry run creation of the pods and workloads, in namespace unless
+// they have one
+func patchManifest(r io.Reader, w io.Writer, namespace string, admit admitFunc, format OutputFormat) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		patched, err := patchDocument(doc, namespace, admit, format)
+		if err != nil {
+			return fmt.Errorf("document %d: %v", i, err)
+		}
+		if patched == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", patched); err != nil {
+			return err
+		}
+	}
+}
+
+// patchDocument returns the YAML document doc patched by admit, if it is a kind the webhook
+// mutates, in format. It returns nil for the documents left unchanged but in the manifest format.
+func patchDocument(doc []byte, namespace string, admit admitFunc, format OutputFormat) ([]byte, error) {
+	unchanged := doc
+	if format != OutputManifest {
+		unchanged = nil
+	}
+	object, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	req, err := offlineRequest(object, namespace)
+	if err != nil || req == nil {
+		return unchanged, err
+	}
+
+	resp := admit(context.Background(), req)
+	if !resp.Allowed {
+		return nil, fmt.Errorf("%s %s rejected: %s", req.Kind.Kind, req.Name, resp.Result.Message)
+	}
+	if len(resp.Patch) == 0 {
+		return unchanged, nil
+	}
+	patch, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch.Apply(object)
+	if err != nil {
+		return nil, err
+	}
+	if format != OutputManifest {
+		return objectPatch(req, object, patched, format)
+	}
+	return yaml.JSONToYAML(patched)
+}
+
+// offlineRequest returns the dry run creation request of the JSON object, in namespace unless it
+// has one, nil if it is not a kind the webhook mutates
+func offlineRequest(object []byte, namespace string) (*admissionv1.AdmissionRequest, error) {
+	var meta struct {
+		metav1.TypeMeta `json:",inline"`
+		Metadata        metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &meta); err != nil {
+		return nil, err
+	}
+	gvk := schema.FromAPIVersionAndKind(meta.APIVersion, meta.Kind)
+	if !supportedKind(gvk.GroupKind()) {
+		return nil, nil
+	}
+	if meta.Metadata.Namespace != "" {
+		namespace = meta.Metadata.Namespace
+	}
+
+	dryRun := true
+	return &admissionv1.AdmissionRequest{
+		UID:       types.UID("offline"),
+		Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		Namespace: namespace,
+		Name:      meta.Metadata.Name,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: object},
+		DryRun:    &dryRun,
+	}, nil
+}
+
+// NewReview returns the admission/v1 AdmissionReview of the dry run creation of the JSON object,
+// in namespace unless it has one, as the API server would send it to /mutate
+func NewReview(object []byte, namespace string) (*admissionv1.AdmissionReview, error) {
+	req, err := offlineRequest(object, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil {
+		return nil, errors.New("the object is not a kind the webhook mutates")
+	}
+	review := &admissionv1.AdmissionReview{Request: req}
+	review.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
+	return review, nil
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"fmt"
+
+	"github.com/go-chassis/sidecar-injector/inject"
+	"github.com/go-chassis/sidecar-injector/policy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// injector is the inject.Injector of a server without listener
+type injector struct {
+	wh *WebHookServer
+}
+
+// NewInjector returns an injector deciding with policies, all the pods are decided by their
+// annotations if nil, and patching with the config of source. The config is loaded now, and
+// reloaded on the changes of source until stop is closed, once only if stop is nil.
+func NewInjector(p WebHookParameters, source ConfigSource, policies *policy.Index, stop <-chan struct{}) (inject.Injector, error) {
+	wh, err := NewReplayer(p, source)
+	if err != nil {
+		return nil, err
+	}
+	wh.Policies = policies
+	if stop != nil {
+		if err := source.Watch(wh.reloadConfig, stop); err != nil {
+			return nil, fmt.Errorf("failed to watch %s: %v", source, err)
+		}
+	}
+	return &injector{wh: wh}, nil
+}
+
+func (i *injector) Decide(pod *corev1.Pod) inject.Decision {
+	required, profile, reason, warnings := i.wh.requiredMutation(&pod.ObjectMeta)
+	return inject.Decision{Inject: required, Profile: profile, Reason: string(reason), Warnings: warnings}
+}
+
+func (i *injector) Patch(pod *corev1.Pod) ([]inject.Operation, error) {
+	decision := i.Decide(pod)
+	if !decision.Inject {
+		return nil, nil
+	}
+	rootConfig := i.wh.SidecarConfig()
+	if rootConfig == nil {
+		return nil, fmt.Errorf("%s did not deliver a valid config yet", i.wh.source)
+	}
+	sidecar, _, err := sidecarFor(rootConfig, pod, decision.Profile, i.wh.cniMode(pod.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	return inject.Chain(pod, "", inject.Patch(pod, "", sidecar), i.wh.mutators)
+}
@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	"github.com/go-chassis/sidecar-injector/version"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// staleReplicaPeriods is the number of report intervals after which a replica that stopped
+// writing its state is dropped from the status
+const staleReplicaPeriods = 3
+
+// StatusReporter writes the state of the replica, and the sum of the replicas, into the status
+// of a SidecarInjector every interval. Every replica runs one.
+type StatusReporter struct {
+	wh *WebHookServer
+	// reader reads the resource from the API server, the cache may be behind the last update
+	reader   client.Reader
+	client   client.Client
+	name     string
+	identity string
+	interval time.Duration
+	// Leading tells whether the replica runs the controllers, if not nil
+	Leading func() bool
+}
+
+// NewStatusReporter returns a reporter writing the SidecarInjector name, with the clients of mgr,
+// as the replica identity
+func NewStatusReporter(wh *WebHookServer, mgr ctrl.Manager, name, identity string, interval time.Duration) *StatusReporter {
+	return &StatusReporter{wh: wh, reader: mgr.GetAPIReader(), client: mgr.GetClient(), name: name, identity: identity, interval: interval}
+}
+
+// Run reports every interval until ctx is done
+func (s *StatusReporter) Run(ctx context.Context) {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		if err := s.Report(ctx); err != nil {
+			log.Errorf("failed to report the status to SidecarInjector %s: %v", s.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Report writes the state of the replica once, creating the SidecarInjector if it is missing.
+// A config held back by the fence is served once all the replicas reported it.
+func (s *StatusReporter) Report(ctx context.Context) error {
+	replica := s.replica()
+	var replicas []v1alpha1.InjectorReplica
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var injector v1alpha1.SidecarInjector
+		err := s.reader.Get(ctx, types.NamespacedName{Name: s.name}, &injector)
+		if apierrors.IsNotFound(err) {
+			injector = v1alpha1.SidecarInjector{ObjectMeta: metav1.ObjectMeta{
+				Name:   s.name,
+				Labels: map[string]string{"app": "sidecar-injector"},
+			}}
+			err = s.client.Create(ctx, &injector)
+			if apierrors.IsAlreadyExists(err) {
+				// created by another replica meanwhile, read it again
+				return apierrors.NewConflict(v1alpha1.GroupVersion.WithResource("sidecarinjectors").GroupResource(), s.name, err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		cutoff := replica.LastUpdateTime.Add(-staleReplicaPeriods * s.interval)
+		injector.Status = sumReplicas(injector.Status.ReplicaStatuses, replica, cutoff)
+		replicas = injector.Status.ReplicaStatuses
+		return s.client.Status().Update(ctx, &injector)
+	})
+	if err != nil || s.wh.fence == nil {
+		return err
+	}
+	if c := s.wh.fence.release(replicas); c != nil {
+		log.Infof("All the replicas loaded config %s, serving it", c.Hash())
+		s.wh.SetConfig(c)
+	}
+	return nil
+}
+
+// replica returns the state of the replica
+func (s *StatusReporter) replica() v1alpha1.InjectorReplica {
+	r := v1alpha1.InjectorReplica{
+		Name:           s.identity,
+		Version:        version.Version,
+		Ready:          true,
+		LastUpdateTime: metav1.Now(),
+	}
+	if c := s.wh.SidecarConfig(); c != nil {
+		r.ConfigHash = c.Hash()
+	}
+	if s.wh.fence != nil {
+		r.StagedConfigHash = s.wh.fence.stagedHash()
+	}
+	s.wh.Lock.RLock()
+	if s.wh.configError != nil {
+		r.ConfigError = s.wh.configError.Error()
+	}
+	s.wh.Lock.RUnlock()
+	for _, c := range s.wh.checks() {
+		if err := c.check(); err != nil {
+			r.Ready = false
+			r.NotReady = append(r.NotReady, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	if s.Leading != nil {
+		r.Leader = s.Leading()
+	}
+
+	for _, n := range namespaceInjections() {
+		r.Namespaces = append(r.Namespaces, n.NamespaceInjections)
+		for reason, v := range n.ErrorReasons {
+			if r.Errors == nil {
+				r.Errors = map[string]int64{}
+			}
+			r.Errors[reason] += v
+		}
+	}
+	return r
+}
+
+// sumReplicas returns the status of the replicas, where replica replaces the former state of
+// the same name and those not updated since cutoff are dropped
+func sumReplicas(replicas []v1alpha1.InjectorReplica, replica v1alpha1.InjectorReplica, cutoff time.Time) v1alpha1.SidecarInjectorStatus {
+	status := v1alpha1.SidecarInjectorStatus{ReplicaStatuses: []v1alpha1.InjectorReplica{replica}}
+	for _, r := range replicas {
+		if r.Name != replica.Name && !r.LastUpdateTime.Time.Before(cutoff) {
+			status.ReplicaStatuses = append(status.ReplicaStatuses, r)
+		}
+	}
+	sort.Slice(status.ReplicaStatuses, func(i, j int) bool {
+		return status.ReplicaStatuses[i].Name < status.ReplicaStatuses[j].Name
+	})
+
+	versions, hashes := map[string]bool{}, map[string]bool{}
+	namespaces := map[string]*v1alpha1.NamespaceInjections{}
+	for _, r := range status.ReplicaStatuses {
+		status.Replicas++
+		if r.Ready {
+			status.ReadyReplicas++
+		}
+		if !versions[r.Version] {
+			versions[r.Version] = true
+			status.Versions = append(status.Versions, r.Version)
+		}
+		if r.ConfigHash != "" && !hashes[r.ConfigHash] {
+			hashes[r.ConfigHash] = true
+			status.ConfigHashes = append(status.ConfigHashes, r.ConfigHash)
+		}
+		for _, n := range r.Namespaces {
+			sum, ok := namespaces[n.Namespace]
+			if !ok {
+				sum = &v1alpha1.NamespaceInjections{Namespace: n.Namespace}
+				namespaces[n.Namespace] = sum
+			}
+			sum.Injected += n.Injected
+			sum.Skipped += n.Skipped
+			sum.Errors += n.Errors
+		}
+		for reason, v := range r.Errors {
+			if status.Errors == nil {
+				status.Errors = map[string]int64{}
+			}
+			status.Errors[reason] += v
+		}
+	}
+	for _, n := range namespaces {
+		status.Namespaces = append(status.Namespaces, *n)
+	}
+	sortNamespaces(status.Namespaces)
+	return status
+}
+
+// sortNamespaces sorts namespaces by name
+func sortNamespaces(namespaces []v1alpha1.NamespaceInjections) {
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Namespace < namespaces[j].Namespace })
+}
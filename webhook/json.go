@@ -0,0 +1,10 @@
+package webhook
+
+import "encoding/json"
+
+// decodeJSON decodes the objects of the admission requests and encodeJSON the patches answered,
+// with the standard library unless the binary is built with the jsoniter tag
+var (
+	decodeJSON = json.Unmarshal
+	encodeJSON = json.Marshal
+)
@@ -0,0 +1,12 @@
+//go:build jsoniter
+// +build jsoniter
+
+package webhook
+
+import jsoniter "github.com/json-iterator/go"
+
+func init() {
+	// the configuration following the standard library, the output of both is the same
+	api := jsoniter.ConfigCompatibleWithStandardLibrary
+	decodeJSON, encodeJSON = api.Unmarshal, api.Marshal
+}
@@ -0,0 +1,64 @@
+//go:build jsoniter
+// +build jsoniter
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-chassis/sidecar-injector/inject"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TestJSONIterMatchesStandardLibrary checks the binaries built with the jsoniter tag answer the
+// same patches as the standard library, and decode the pods the same way:
+// go test -tags jsoniter ./webhook
+func TestJSONIterMatchesStandardLibrary(t *testing.T) {
+	if reflect.ValueOf(encodeJSON).Pointer() == reflect.ValueOf(json.Marshal).Pointer() {
+		t.Fatal("encodeJSON is the standard library, the jsoniter tag is not applied")
+	}
+
+	pod := testPod("default", "web")
+	pod.Annotations["description"] = "<b>web</b> & \"api\"   café"
+	pod.Labels = map[string]string{"app.kubernetes.io/name": "web"}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var std, iter corev1.Pod
+	if err := json.Unmarshal(raw, &std); err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeJSON(raw, &iter); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(std, iter) {
+		t.Errorf("decoded pods differ:\nstandard library: %+v\njsoniter:         %+v", std, iter)
+	}
+
+	wh := newTestWebhook(t)
+	sidecar, _, err := sidecarFor(wh.SidecarConfig(), &std, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar.Annotations = map[string]string{webhookStatusKey: "{\"state\":\"injected\"}", "mesher.io/html": "<&>"}
+	ops, err := inject.Chain(&std, "", inject.Patch(&std, "", sidecar), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := encodeJSON(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("patches differ:\nstandard library: %s\njsoniter:         %s", want, got)
+	}
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultAnnotationPrefix is the prefix of the annotation keys of the injector
+const DefaultAnnotationPrefix = "sidecar-injector-mesher.io"
+
+// The annotation keys of the injector, under the prefix set by SetAnnotationPrefix
+var (
+	annotationPrefix string
+	webhookInjectKey string
+	webhookStatusKey string
+	// webhookOutdatedKey annotates the pods running a stale sidecar with the hash of the config served
+	webhookOutdatedKey string
+	// webhookRestartKey opts a namespace in the restart of its stale workloads when enabled
+	webhookRestartKey string
+	// webhookRestartedForKey annotates the pod template of a restarted workload with the hash of
+	// the config it was restarted for, so it is restarted once per config
+	webhookRestartedForKey string
+	// webhookCNIKey labels the namespaces where the mesh CNI plugin intercepts the traffic when
+	// enabled, webhookInterceptionKey and webhookInboundPortsKey annotate their pods for the plugin
+	webhookCNIKey          string
+	webhookInterceptionKey string
+	webhookInboundPortsKey string
+)
+
+func init() {
+	setAnnotationPrefix(DefaultAnnotationPrefix)
+}
+
+// SetAnnotationPrefix sets the prefix of the annotation keys read and written by the injector, a
+// DNS subdomain, so several injectors don't share their keys. It is set once before the webhook
+// and the controllers start.
+func SetAnnotationPrefix(prefix string) error {
+	if errs := validation.IsDNS1123Subdomain(prefix); len(errs) != 0 {
+		return fmt.Errorf("invalid annotation prefix %q: %s", prefix, strings.Join(errs, ", "))
+	}
+	setAnnotationPrefix(prefix)
+	return nil
+}
+
+func setAnnotationPrefix(prefix string) {
+	annotationPrefix = prefix
+	webhookInjectKey = prefix + "/inject"
+	webhookStatusKey = prefix + "/status"
+	webhookOutdatedKey = prefix + "/outdated"
+	webhookRestartKey = prefix + "/restart"
+	webhookRestartedForKey = prefix + "/restarted-for"
+	webhookCNIKey = prefix + "/cni"
+	webhookInterceptionKey = prefix + "/interception"
+	webhookInboundPortsKey = prefix + "/inbound-ports"
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// lastGoodSource keeps the last config loaded from its source in a local file, and serves
+// that file when the source is unreadable at startup
+type lastGoodSource struct {
+	ConfigSource
+	file string
+
+	lock sync.Mutex
+	// loaded is set once a config was served, later failures keep serving it instead of the file
+	loaded    bool
+	savedHash string
+}
+
+// WithLastGood returns source persisting each config it loads in file, which is used when
+// the first load of source fails, e.g. because of a broken push. Templates are persisted as
+// rendered for a pod without annotations nor ports.
+func WithLastGood(source ConfigSource, file string) ConfigSource {
+	return &lastGoodSource{ConfigSource: source, file: file}
+}
+
+func (s *lastGoodSource) Load() (*Config, error) {
+	c, err := s.ConfigSource.Load()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err != nil && !s.loaded {
+		log.Errorf("failed to load sidecar config from %s, using the last good config %s: %v", s.ConfigSource, s.file, err)
+		if c, err = loadConfig(s.file, ""); err != nil {
+			return nil, fmt.Errorf("failed to load the last good sidecar config: %v", err)
+		}
+		c.stamp("last good "+s.file, "")
+	} else if err == nil && c != nil {
+		s.save(c)
+	}
+	if c != nil {
+		s.loaded = true
+	}
+	return c, err
+}
+
+func (s *lastGoodSource) Watch(notify func(*Config, error), stop <-chan struct{}) error {
+	return s.ConfigSource.Watch(func(c *Config, err error) {
+		if err == nil && c != nil {
+			s.lock.Lock()
+			s.save(c)
+			s.loaded = true
+			s.lock.Unlock()
+		}
+		notify(c, err)
+	}, stop)
+}
+
+// save writes c to the file unless it is already there. The file is replaced by a rename
+// so a crash never leaves it half written.
+func (s *lastGoodSource) save(c *Config) {
+	if c.Hash() == s.savedHash {
+		return
+	}
+
+	data, err := json.Marshal(configFile{
+		configHeader: configHeader{APIVersion: ConfigAPIVersion, Kind: ConfigKind},
+		Config:       *c,
+	})
+	if err != nil {
+		log.Errorf("failed to encode the last good sidecar config: %v", err)
+		return
+	}
+	// the config may hold decrypted credentials, keep it private
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".tmp")
+	if err != nil {
+		log.Errorf("failed to save the last good sidecar config: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		log.Errorf("failed to save the last good sidecar config: %v", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.Errorf("failed to save the last good sidecar config: %v", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		log.Errorf("failed to save the last good sidecar config: %v", err)
+		return
+	}
+	s.savedHash = c.Hash()
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	log "github.com/Sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// constant values for the decision field of the admission logs
+const (
+	decisionPatched  = "patched"
+	decisionAllowed  = "allowed"
+	decisionRejected = "rejected"
+)
+
+// requestLogger returns the logger of the lines about req, carrying the fields identifying it
+func requestLogger(req *admissionv1.AdmissionRequest) *log.Entry {
+	return log.WithFields(log.Fields{
+		"uid":       req.UID,
+		"kind":      req.Kind.Kind,
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"operation": req.Operation,
+	})
+}
+
+// podLogger returns the logger of the lines about the pod of req, controllers only give it a
+// generateName when it is created, its identity and workload tell which pod it is
+func podLogger(req *admissionv1.AdmissionRequest, pod *corev1.Pod) *log.Entry {
+	return requestLogger(req).WithFields(log.Fields{
+		"pod":          pod.Name,
+		"generateName": pod.GenerateName,
+		"identity":     podIdentity(req, pod),
+		"workload":     podWorkload(req, pod),
+	})
+}
+
+// decisionOf tells what resp does to the object
+func decisionOf(resp *admissionv1.AdmissionResponse) string {
+	switch {
+	case !resp.Allowed:
+		return decisionRejected
+	case len(resp.Patch) > 0:
+		return decisionPatched
+	default:
+		return decisionAllowed
+	}
+}
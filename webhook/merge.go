@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"bytes"
+
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// merge overlays o on c: entries with the same name are replaced in place, new ones are appended
+func (c *Config) merge(o *Config) {
+	c.Containers = mergeContainers(c.Containers, o.Containers)
+	c.InitContainers = mergeContainers(c.InitContainers, o.InitContainers)
+	c.Volumes = mergeVolumes(c.Volumes, o.Volumes)
+	c.ImagePullSecret = mergeSecrets(c.ImagePullSecret, o.ImagePullSecret)
+	c.Annotations = mergeMetadata(c.Annotations, o.Annotations)
+	c.Labels = mergeMetadata(c.Labels, o.Labels)
+	c.CNIAnnotations = mergeMetadata(c.CNIAnnotations, o.CNIAnnotations)
+	if o.PortsEnv != "" {
+		c.PortsEnv = o.PortsEnv
+	}
+
+	for name, p := range o.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = map[string]*Config{}
+		}
+		c.Profiles[name] = p
+	}
+
+	if len(c.source) != 0 && len(o.source) != 0 {
+		if !bytes.HasSuffix(c.source, []byte("\n")) {
+			c.source = append(c.source, '\n')
+		}
+		c.source = append(c.source, "---\n"...)
+	}
+	c.source = append(c.source, o.source...)
+}
+
+func mergeContainers(dest, add []corev1.Container) []corev1.Container {
+	for _, a := range add {
+		replaced := false
+		for i := range dest {
+			if dest[i].Name == a.Name {
+				dest[i], replaced = a, true
+				break
+			}
+		}
+		if !replaced {
+			dest = append(dest, a)
+		}
+	}
+	return dest
+}
+
+func mergeVolumes(dest, add []corev1.Volume) []corev1.Volume {
+	for _, a := range add {
+		replaced := false
+		for i := range dest {
+			if dest[i].Name == a.Name {
+				dest[i], replaced = a, true
+				break
+			}
+		}
+		if !replaced {
+			dest = append(dest, a)
+		}
+	}
+	return dest
+}
+
+func mergeSecrets(dest, add []corev1.LocalObjectReference) []corev1.LocalObjectReference {
+	for _, a := range add {
+		replaced := false
+		for i := range dest {
+			if dest[i].Name == a.Name {
+				dest[i], replaced = a, true
+				break
+			}
+		}
+		if !replaced {
+			dest = append(dest, a)
+		}
+	}
+	return dest
+}
+
+func mergeMetadata(dest, add []v1alpha1.PodMetadata) []v1alpha1.PodMetadata {
+	for _, a := range add {
+		replaced := false
+		for i := range dest {
+			if dest[i].Key == a.Key {
+				dest[i], replaced = a, true
+				break
+			}
+		}
+		if !replaced {
+			dest = append(dest, a)
+		}
+	}
+	return dest
+}
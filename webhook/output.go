@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormat is how the offline injection writes the objects it patches
+type OutputFormat string
+
+// constant values for the output formats
+const (
+	// OutputManifest writes the whole manifest, the objects patched
+	OutputManifest OutputFormat = "manifest"
+	// OutputPatch writes the strategic merge patches of the objects patched, as kustomize takes them
+	OutputPatch OutputFormat = "patch"
+	// OutputApply writes the apply configurations of the fields patched, for server-side apply
+	OutputApply OutputFormat = "apply"
+)
+
+// errApplyRemoval is returned when the patch of an object removes fields, apply can't express it
+var errApplyRemoval = errors.New("an apply configuration can't remove fields, use the patch output")
+
+// ParseOutputFormat returns the output format named s
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(s); f {
+	case OutputManifest, OutputPatch, OutputApply:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output %q, one of %s, %s and %s", s, OutputManifest, OutputPatch, OutputApply)
+}
+
+// objectPatch returns the YAML document turning the JSON object of req into patched, in format:
+// its strategic merge patch or its apply configuration, identifying the object
+func objectPatch(req *admissionv1.AdmissionRequest, object, patched []byte, format OutputFormat) ([]byte, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("%s has no name to patch it with", req.Kind.Kind)
+	}
+	gk := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+	data, err := strategicpatch.CreateTwoWayMergePatch(object, patched, patchSchema(gk))
+	if err != nil {
+		return nil, err
+	}
+	var p map[string]interface{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if format == OutputApply {
+		c, err := applyConfiguration(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", req.Kind.Kind, req.Name, err)
+		}
+		p = c.(map[string]interface{})
+	}
+
+	// the namespace is the one of the object, the patch applies wherever it is deployed otherwise
+	var meta struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &meta); err != nil {
+		return nil, err
+	}
+	metadata, _ := p["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+		p["metadata"] = metadata
+	}
+	metadata["name"] = req.Name
+	if meta.Metadata.Namespace != "" {
+		metadata["namespace"] = meta.Metadata.Namespace
+	}
+	p["apiVersion"] = schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}.String()
+	p["kind"] = req.Kind.Kind
+	return yaml.Marshal(p)
+}
+
+// patchSchema returns an object of kind gk, whose struct tags tell the strategic merge patches
+// how to merge its lists. Workloads are their latest version, the pod template is the same.
+func patchSchema(gk schema.GroupKind) interface{} {
+	switch gk {
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		return &appsv1.Deployment{}
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		return &appsv1.StatefulSet{}
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+		return &appsv1.DaemonSet{}
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		return &batchv1.Job{}
+	case schema.GroupKind{Group: "batch", Kind: "CronJob"}:
+		return &batchv1beta1.CronJob{}
+	default:
+		return &corev1.Pod{}
+	}
+}
+
+// applyConfiguration returns the strategic merge patch p without its directives, the fields the
+// patch sets. It fails if p removes fields.
+func applyConfiguration(p interface{}) (interface{}, error) {
+	switch v := p.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			switch {
+			case value == nil, key == "$patch" && value == "delete", strings.HasPrefix(key, "$deleteFromPrimitiveList/"):
+				return nil, errApplyRemoval
+			case strings.HasPrefix(key, "$"):
+				// $setElementOrder and $retainKeys, apply keeps the order and the other fields
+				continue
+			}
+			var err error
+			if c[key], err = applyConfiguration(value); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	case []interface{}:
+		c := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			item, err := applyConfiguration(item)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, item)
+		}
+		return c, nil
+	default:
+		return p, nil
+	}
+}
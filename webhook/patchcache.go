@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+// patchCacheTTL is how long an unused patch is kept, those of a replaced config are never used again
+const patchCacheTTL = 10 * time.Minute
+
+// patchCache keeps the patches of the last objects injected. The pods of a workload are the same
+// object when they are created, their name is generated after the admission, so a rollout or a
+// scale up renders the sidecar and marshals its patch once.
+type patchCache struct {
+	lru *cache.LRUExpireCache
+}
+
+// cachedPatch is a patch, its number of operations and the warnings about its profile. It is
+// shared by the responses and never modified.
+type cachedPatch struct {
+	patch      []byte
+	operations int
+	warnings   []string
+}
+
+// newPatchCache returns a cache of size patches, nil if size is 0
+func newPatchCache(size int) *patchCache {
+	if size <= 0 {
+		return nil
+	}
+	return &patchCache{lru: cache.NewLRUExpireCache(size)}
+}
+
+// patchKey returns the key of the patch of the object of req injected with profile of the config
+// of hash configHash, for the CNI plugin if cni. The mutators are expected to depend on the object
+// only.
+func patchKey(configHash, profile string, cni bool, req *admissionv1.AdmissionRequest) string {
+	sum := sha256.Sum256(req.Object.Raw)
+	return configHash + "/" + profile + "/" + strconv.FormatBool(cni) + "/" + req.Namespace + "/" + hex.EncodeToString(sum[:])
+}
+
+func (c *patchCache) get(key string) (cachedPatch, bool) {
+	value, ok := c.lru.Get(key)
+	if !ok {
+		return cachedPatch{}, false
+	}
+	return value.(cachedPatch), true
+}
+
+func (c *patchCache) add(key string, p cachedPatch) {
+	c.lru.Add(key, p, patchCacheTTL)
+}
@@ -0,0 +1,33 @@
+package webhook
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a buffer is dropped instead of pooled, so a rare
+// big review doesn't keep its memory for good
+const maxPooledBuffer = 1 << 20
+
+// bufferPool holds the buffers the reviews are read into and encoded in, a pod burst would
+// otherwise allocate and collect two of them per request
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer returns an empty buffer of the pool
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer gives buf back to the pool, nothing it holds may be used afterwards
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
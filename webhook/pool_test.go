@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// benchBody is the body of the review of a usual pod
+func benchBody(b *testing.B) []byte {
+	review := admissionv1.AdmissionReview{Request: podRequest(b, testPod("bench", "web"), false)}
+	review.APIVersion, review.Kind = "admission.k8s.io/v1", "AdmissionReview"
+	body, err := json.Marshal(review)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return body
+}
+
+// BenchmarkBufferPooled reads the reviews into the buffers of the pool, as serve does
+func BenchmarkBufferPooled(b *testing.B) {
+	body := benchBody(b)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := getBuffer()
+			// a bytes.Reader never fails
+			_, _ = buf.ReadFrom(bytes.NewReader(body))
+			putBuffer(buf)
+		}
+	})
+}
+
+// BenchmarkBufferUnpooled reads the reviews into new buffers, what the pool saves
+func BenchmarkBufferUnpooled(b *testing.B) {
+	body := benchBody(b)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := new(bytes.Buffer)
+			_, _ = buf.ReadFrom(bytes.NewReader(body))
+		}
+	})
+}
+
+func TestPutBufferDropsBigBuffers(t *testing.T) {
+	buf := getBuffer()
+	buf.Grow(maxPooledBuffer + 1)
+	putBuffer(buf)
+	for i := 0; i < 10; i++ {
+		if got := getBuffer(); got == buf {
+			t.Fatal("a buffer over maxPooledBuffer was pooled")
+		}
+	}
+}
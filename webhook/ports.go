@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-chassis/sidecar-injector/inject"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// appPorts returns the container ports declared by the app containers of pod, those not injected
+// by c, comma separated in declaration order without duplicates, e.g. 8080,9090
+func appPorts(pod *corev1.Pod, c *Config) string {
+	seen := map[int32]bool{}
+	var ports []string
+	for _, container := range pod.Spec.Containers {
+		// reinvoked after the injection, the sidecar is not an app
+		if inject.HasContainer(c.Containers, container.Name) {
+			continue
+		}
+		for _, p := range container.Ports {
+			if !seen[p.ContainerPort] {
+				seen[p.ContainerPort] = true
+				ports = append(ports, strconv.Itoa(int(p.ContainerPort)))
+			}
+		}
+	}
+	return strings.Join(ports, ",")
+}
+
+// withEnv returns copies of containers with the env variable name set to value, except in those
+// setting it already. containers is left alone, it belongs to the config.
+func withEnv(containers []corev1.Container, name, value string) []corev1.Container {
+	if len(containers) == 0 {
+		return containers
+	}
+	copies := make([]corev1.Container, len(containers))
+	for i, c := range containers {
+		copies[i] = c
+		if hasEnv(c.Env, name) {
+			continue
+		}
+		copies[i].Env = append(append(make([]corev1.EnvVar, 0, len(c.Env)+1), c.Env...), corev1.EnvVar{Name: name, Value: value})
+	}
+	return copies
+}
+
+func hasEnv(env []corev1.EnvVar, name string) bool {
+	for _, e := range env {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"sync"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// namespaceLimiter holds a token bucket per namespace, so the pods churned in one namespace
+// don't slow down the admission of the others
+type namespaceLimiter struct {
+	qps   float32
+	burst int
+
+	lock     sync.Mutex
+	limiters map[string]flowcontrol.RateLimiter
+}
+
+// newNamespaceLimiter returns a limiter allowing qps mutations per second and namespace, with
+// bursts of burst. It is nil, allowing everything, when qps is not positive.
+func newNamespaceLimiter(qps float32, burst int) *namespaceLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &namespaceLimiter{qps: qps, burst: burst, limiters: map[string]flowcontrol.RateLimiter{}}
+}
+
+// allow takes a token of the bucket of namespace, it tells false when there is none left
+func (l *namespaceLimiter) allow(namespace string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	limiter, ok := l.limiters[namespace]
+	if !ok {
+		// buckets live as long as the process, there are no more than namespaces
+		limiter = flowcontrol.NewTokenBucketRateLimiter(l.qps, l.burst)
+		l.limiters[namespace] = limiter
+	}
+	l.lock.Unlock()
+	return limiter.TryAccept()
+}
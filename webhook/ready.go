@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// readyCheck is a named readiness condition, it returns why the replica is not ready
+type readyCheck struct {
+	name  string
+	check func() error
+}
+
+// AddReadyCheck adds a condition to /readyz, such as the sync of an informer the injection
+// depends on. It must be called before Run.
+func (wh *WebHookServer) AddReadyCheck(name string, check func() error) {
+	wh.readyChecks = append(wh.readyChecks, readyCheck{name: name, check: check})
+}
+
+// checks returns the readiness conditions, the built-in ones first
+func (wh *WebHookServer) checks() []readyCheck {
+	return append([]readyCheck{
+		{name: "shutdown", check: func() error {
+			if atomic.LoadInt32(&wh.draining) != 0 {
+				return errors.New("draining")
+			}
+			return nil
+		}},
+		{name: "config", check: func() error {
+			if wh.SidecarConfig() != nil {
+				return nil
+			}
+			wh.Lock.RLock()
+			defer wh.Lock.RUnlock()
+			if wh.configError != nil {
+				return wh.configError
+			}
+			return errors.New("not loaded")
+		}},
+		{name: "watchers", check: watchersError},
+		{name: "certificate", check: func() error {
+			// none when TLS is disabled
+			for _, p := range wh.allCerts() {
+				notAfter := p.NotAfter()
+				if time.Now().After(notAfter) {
+					return fmt.Errorf("%s expired at %s", p.source, notAfter.Format(time.RFC3339))
+				}
+				if w := wh.parms.CertExpiryUnready; w > 0 && time.Until(notAfter) < w {
+					return fmt.Errorf("%s expires at %s, in less than %s", p.source, notAfter.Format(time.RFC3339), w)
+				}
+			}
+			return nil
+		}},
+	}, wh.readyChecks...)
+}
+
+// readyz tells whether the replica can serve admission reviews: it has a valid config and
+// certificate, and the added checks pass. Each check is listed in the body.
+func (wh *WebHookServer) readyz(w http.ResponseWriter, r *http.Request) {
+	writeChecks(w, wh.checks())
+}
+
+// writeChecks runs checks and answers 200 if they all pass, 503 otherwise, listing them in the body
+func writeChecks(w http.ResponseWriter, checks []readyCheck) {
+	var body bytes.Buffer
+	status := http.StatusOK
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			status = http.StatusServiceUnavailable
+			fmt.Fprintf(&body, "[-]%s failed: %v\n", c.name, err)
+		} else {
+			fmt.Fprintf(&body, "[+]%s ok\n", c.name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Errorf("Can't write response: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// redacted replaces the sensitive values in the logs
+const redacted = "<redacted>"
+
+// DefaultRedactPatterns match the names of the env variables and the annotation keys whose
+// values are not logged
+var DefaultRedactPatterns = []string{`(?i)pass(word)?`, `(?i)secret`, `(?i)token`, `(?i)key`, `(?i)credential`}
+
+// redactor masks the sensitive values of the patches before they are logged: the env values and
+// annotations whose name matches one of its patterns, and the names of the Secrets referenced
+type redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// newRedactor returns a redactor of the values named after one of patterns
+func newRedactor(patterns []string) (*redactor, error) {
+	r := &redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// sensitive tells whether the value named name must be masked
+func (r *redactor) sensitive(name string) bool {
+	for _, re := range r.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// patch returns the JSON patch with the sensitive values masked
+func (r *redactor) patch(patch []byte) string {
+	var ops []map[string]interface{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return redacted
+	}
+	for _, op := range ops {
+		path, _ := op["path"].(string)
+		value, ok := op["value"]
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(path, "/metadata/annotations"):
+			r.annotations(value)
+		case strings.Contains(path, "/metadata/annotations/"):
+			key := path[strings.LastIndex(path, "/")+1:]
+			key = strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+			if r.sensitive(key) {
+				op["value"] = redacted
+			}
+		case strings.Contains(path, "/imagePullSecrets"):
+			maskField(value, "name")
+			if list, ok := value.([]interface{}); ok {
+				for _, v := range list {
+					maskField(v, "name")
+				}
+			}
+		default:
+			r.object(value)
+		}
+	}
+	masked, err := json.Marshal(ops)
+	if err != nil {
+		return redacted
+	}
+	return string(masked)
+}
+
+// annotations masks the values of the sensitive keys of an annotations map
+func (r *redactor) annotations(value interface{}) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k := range m {
+		if r.sensitive(k) {
+			m[k] = redacted
+		}
+	}
+}
+
+// object masks the sensitive env values and the Secret names found anywhere in value, such as
+// containers and volumes
+func (r *redactor) object(value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			r.object(item)
+		}
+	case map[string]interface{}:
+		if env, ok := v["env"].([]interface{}); ok {
+			for _, e := range env {
+				if e, ok := e.(map[string]interface{}); ok {
+					if name, _ := e["name"].(string); r.sensitive(name) && e["value"] != nil {
+						e["value"] = redacted
+					}
+				}
+			}
+		}
+		for key, item := range v {
+			switch key {
+			case "secretKeyRef", "secretRef":
+				maskField(item, "name")
+			case "secret":
+				maskField(item, "secretName")
+			}
+			r.object(item)
+		}
+	}
+}
+
+// maskField masks the field of value when it is an object holding it
+func maskField(value interface{}, field string) {
+	if m, ok := value.(map[string]interface{}); ok {
+		if _, ok := m[field]; ok {
+			m[field] = redacted
+		}
+	}
+}
+
+// document returns the JSON object raw with the sensitive env values, annotations and Secret
+// names masked, at any depth such as the pod template of a workload
+func (r *redactor) document(raw []byte) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil
+	}
+	r.object(doc)
+	r.allAnnotations(doc)
+	masked, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	return masked
+}
+
+// allAnnotations masks the sensitive keys of the annotations maps found anywhere in value
+func (r *redactor) allAnnotations(value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			r.allAnnotations(item)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			if key == "annotations" {
+				r.annotations(item)
+				continue
+			}
+			r.allAnnotations(item)
+		}
+	}
+}
+
+// config returns the JSON object raw of a sidecar config with all its env values masked, whatever
+// their name: the config may hold the secrets SOPS decrypted there. The annotations and Secret
+// names are masked as in the logs.
+func (r *redactor) config(raw []byte) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil
+	}
+	maskEnv(doc)
+	r.object(doc)
+	r.allAnnotations(doc)
+	masked, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	return masked
+}
+
+// source returns the YAML documents of a config source with their env values, annotations and
+// Secret names masked as by config. A document that can't be read is masked whole.
+func (r *redactor) source(data []byte) []byte {
+	var out bytes.Buffer
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return out.Bytes()
+		}
+		if err != nil {
+			out.WriteString("---\n# " + redacted + "\n")
+			return out.Bytes()
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		out.WriteString("---\n")
+		object, err := yaml.YAMLToJSON(doc)
+		if err == nil {
+			if masked := r.config(object); masked != nil {
+				if doc, err = yaml.JSONToYAML(masked); err == nil {
+					out.Write(doc)
+					continue
+				}
+			}
+		}
+		out.WriteString("# " + redacted + "\n")
+	}
+}
+
+// maskEnv masks the values of all the env variables found anywhere in value
+func maskEnv(value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			maskEnv(item)
+		}
+	case map[string]interface{}:
+		if env, ok := v["env"].([]interface{}); ok {
+			for _, e := range env {
+				if e, ok := e.(map[string]interface{}); ok && e["value"] != nil {
+					e["value"] = redacted
+				}
+			}
+		}
+		for _, item := range v {
+			maskEnv(item)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChecksumHeader is the response header carrying the hex sha256 of a remote config
+const ChecksumHeader = "X-Checksum-Sha256"
+
+// httpSource polls the sidecar config from an HTTPS URL
+type httpSource struct {
+	url          string
+	pollInterval time.Duration
+	client       *http.Client
+
+	// lock serializes the polls of the ticker and of explicit reloads
+	lock         sync.Mutex
+	etag         string
+	lastModified string
+}
+
+// NewHTTPSource returns a source polling the config from p.SidecarConfigURL
+func NewHTTPSource(p WebHookParameters) (ConfigSource, error) {
+	tlsConfig := &tls.Config{}
+	if p.SidecarConfigURLCAFile != "" {
+		ca, err := ioutil.ReadFile(p.SidecarConfigURLCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", p.SidecarConfigURLCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &httpSource{
+		url:          p.SidecarConfigURL,
+		pollInterval: p.SidecarConfigPollInterval,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// fetch returns the config document, or nil if it did not change since the last fetch
+func (s *httpSource) fetch() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if want := resp.Header.Get(ChecksumHeader); want != "" {
+		sum := sha256.Sum256(body)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, want) {
+			return nil, fmt.Errorf("checksum mismatch: got %s, %s announced %s", got, ChecksumHeader, want)
+		}
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	return body, nil
+}
+
+// Load fetches the config, it is nil if it did not change since the last fetch
+func (s *httpSource) Load() (*Config, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := s.fetch()
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	c, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	c.stamp(fmt.Sprintf("%s@%s", s.url, s.etag), s.etag)
+	return c, nil
+}
+
+func (s *httpSource) Watch(notify func(*Config, error), stop <-chan struct{}) error {
+	go func() {
+		t := time.NewTicker(s.pollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				notify(s.Load())
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *httpSource) String() string {
+	return s.url
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/inject"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// injectRemove is the value of the inject annotation asking for the removal of the sidecar
+const injectRemove = "remove"
+
+// removal answers the request of an object leaving the mesh with the operations removing the
+// sidecar of the current config, of the profile recorded in its status annotation, along with
+// the annotations of the injector. The pod is found at prefix in the object, the side effects
+// are those of ctx.
+func (wh *WebHookServer) removal(ctx context.Context, req *admissionv1.AdmissionRequest, pod *corev1.Pod, prefix string) *admissionv1.AdmissionResponse {
+	failure := func(code int32, reason metav1.StatusReason, message string, err error) *admissionv1.AdmissionResponse {
+		wh.effect(ctx, req, func() {
+			metrics.InjectionErrors.WithLabelValues(req.Namespace, string(reason)).Inc()
+			wh.event(req, pod, corev1.EventTypeWarning, EventFailed, "%s: %v", message, err)
+		})
+		return wh.failure(code, reason, message, err)
+	}
+	rootConfig := wh.SidecarConfig()
+	if rootConfig == nil {
+		return failure(http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable,
+			"no sidecar config loaded", fmt.Errorf("%s did not deliver a valid config yet", wh.source))
+	}
+	status := parseStatus(pod.Annotations[webhookStatusKey])
+	// the init containers are removed if the pod has them, whatever the interception
+	sidecar, _, err := sidecarFor(rootConfig, pod, status.Profile, false)
+	if err != nil {
+		return failure(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid,
+			fmt.Sprintf("can't render the sidecar config for %s/%s to remove it", pod.Namespace, podIdentity(req, pod)), err)
+	}
+	// the annotations and labels of the config are kept, they can't be told from those of the user
+	sidecar.Annotations = map[string]string{webhookStatusKey: "", webhookOutdatedKey: "", webhookInterceptionKey: "", webhookInboundPortsKey: ""}
+	sidecar.Labels = nil
+
+	if resp := givenUp(ctx, req); resp != nil {
+		return resp
+	}
+	logger := podLogger(req, pod)
+	p := inject.Unpatch(pod, prefix, sidecar)
+	if len(p) == 0 {
+		logger.Info("No sidecar to remove")
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	patch, err := encodeJSON(p)
+	if err != nil {
+		return failure(http.StatusInternalServerError, metav1.StatusReasonInternalError, "can't encode the patch", err)
+	}
+	logger.WithFields(log.Fields{"profile": status.Profile, "operations": len(p)}).Info("Removing the sidecar")
+	wh.effect(ctx, req, func() {
+		metrics.Removals.WithLabelValues(req.Namespace, status.Profile).Inc()
+		wh.event(req, pod, corev1.EventTypeNormal, EventRemoved, "Sidecar of profile %q removed", status.Profile)
+	})
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Patch:   patch,
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
+// Uninject reads the YAML documents of a manifest from r and writes them to w, with the sidecar
+// of the config removed from the pods and workloads, whatever their annotations, in namespace
+// unless they have one, in format as Inject.
+func (wh *WebHookServer) Uninject(r io.Reader, w io.Writer, namespace string, format OutputFormat) error {
+	remove := func(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		pod, prefix, err := podOf(req)
+		if err != nil {
+			return wh.failure(http.StatusBadRequest, metav1.StatusReasonBadRequest, "can't decode the object", err)
+		}
+		if pod.Namespace == "" {
+			pod.Namespace = req.Namespace
+		}
+		return wh.removal(ctx, req, pod, prefix)
+	}
+	return patchManifest(r, w, namespace, remove, format)
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Restarter rolls out the Deployments and StatefulSets running a stale sidecar, in the namespaces
+// opting in, by annotating their pod template. The workloads roll with their own strategy and
+// maxUnavailable; one is only restarted while its PodDisruptionBudgets allow a disruption.
+type Restarter struct {
+	client kubernetes.Interface
+	// maxInProgress bounds the stale workloads rolling out at once
+	maxInProgress int
+}
+
+// NewRestarter returns a restarter rolling out up to maxInProgress workloads at once
+func NewRestarter(client kubernetes.Interface, maxInProgress int) *Restarter {
+	return &Restarter{client: client, maxInProgress: maxInProgress}
+}
+
+// Restart rolls out the workloads of stale, most stale pods first, that were not restarted for
+// the config of hash yet, while fewer than maxInProgress of them are rolling out
+func (r *Restarter) Restart(ctx context.Context, hash string, stale []v1alpha1.StaleWorkload) {
+	enabled := map[string]bool{}
+	var candidates []*restartable
+	inProgress := 0
+	for _, w := range stale {
+		if w.Kind != "Deployment" && w.Kind != "StatefulSet" {
+			continue
+		}
+		optedIn, ok := enabled[w.Namespace]
+		if !ok {
+			ns, err := r.client.CoreV1().Namespaces().Get(ctx, w.Namespace, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("failed to get namespace %s: %v", w.Namespace, err)
+			}
+			optedIn = err == nil && ns.Annotations[webhookRestartKey] == "enabled"
+			enabled[w.Namespace] = optedIn
+		}
+		if !optedIn {
+			continue
+		}
+		workload, err := r.get(ctx, w)
+		if err != nil {
+			log.Warnf("failed to get %s %s/%s: %v", w.Kind, w.Namespace, w.Name, err)
+			continue
+		}
+		switch {
+		case !workload.rolledOut:
+			inProgress++
+		case workload.template.Annotations[webhookRestartedForKey] != hash:
+			candidates = append(candidates, workload)
+		}
+	}
+
+	for i, workload := range candidates {
+		if inProgress >= r.maxInProgress {
+			log.Infof("%d stale workloads rolling out, %d left to restart", inProgress, len(candidates)-i)
+			return
+		}
+		disrupted, err := r.disrupted(ctx, workload)
+		if err != nil {
+			log.Warnf("failed to check the PodDisruptionBudgets of %s: %v", workload, err)
+			continue
+		}
+		if disrupted != "" {
+			log.Infof("Not restarting %s: PodDisruptionBudget %s allows no disruption", workload, disrupted)
+			continue
+		}
+		if err := r.restart(ctx, workload, hash); err != nil {
+			log.Errorf("failed to restart %s: %v", workload, err)
+			continue
+		}
+		log.Infof("Restarted %s for the sidecar of config %s", workload, hash)
+		inProgress++
+	}
+}
+
+// restartable is a workload whose pods can be restarted by annotating their template
+type restartable struct {
+	kind, namespace, name string
+	template              *metav1.ObjectMeta
+	// rolledOut tells that all the replicas run the current template and are available
+	rolledOut bool
+}
+
+func (w *restartable) String() string {
+	return fmt.Sprintf("%s %s/%s", w.kind, w.namespace, w.name)
+}
+
+// get returns the Deployment or StatefulSet of w
+func (r *Restarter) get(ctx context.Context, w v1alpha1.StaleWorkload) (*restartable, error) {
+	workload := &restartable{kind: w.Kind, namespace: w.Namespace, name: w.Name}
+	if w.Kind == "Deployment" {
+		d, err := r.client.AppsV1().Deployments(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		workload.template = &d.Spec.Template.ObjectMeta
+		workload.rolledOut = d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+			d.Status.Replicas == replicas && d.Status.AvailableReplicas == replicas
+		return workload, nil
+	}
+
+	s, err := r.client.AppsV1().StatefulSets(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if s.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return nil, fmt.Errorf("update strategy %s doesn't roll out its pods", appsv1.OnDeleteStatefulSetStrategyType)
+	}
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	workload.template = &s.Spec.Template.ObjectMeta
+	workload.rolledOut = s.Status.ObservedGeneration >= s.Generation && s.Status.UpdateRevision == s.Status.CurrentRevision &&
+		s.Status.ReadyReplicas == replicas
+	return workload, nil
+}
+
+// disrupted returns the name of a PodDisruptionBudget of the pods of workload allowing no
+// disruption, empty if there is none
+func (r *Restarter) disrupted(ctx context.Context, workload *restartable) (string, error) {
+	pdbs, err := r.client.PolicyV1beta1().PodDisruptionBudgets(workload.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	podLabels := labels.Set(workload.template.Labels)
+	for _, pdb := range pdbs.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(podLabels) {
+			continue
+		}
+		if pdb.Status.DisruptionsAllowed <= 0 {
+			return pdb.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// restart annotates the pod template of workload with hash, its controller rolls its pods out
+func (r *Restarter) restart(ctx context.Context, workload *restartable, hash string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{webhookRestartedForKey: hash},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if workload.kind == "Deployment" {
+		_, err = r.client.AppsV1().Deployments(workload.namespace).Patch(ctx, workload.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	} else {
+		_, err = r.client.AppsV1().StatefulSets(workload.namespace).Patch(ctx, workload.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package webhook
+
+import (
+	"fmt"
+
+	"go.mozilla.org/sops/decrypt"
+	"sigs.k8s.io/yaml"
+)
+
+// sopsDocument is enough of a document to tell whether SOPS encrypted it
+type sopsDocument struct {
+	Sops map[string]interface{} `json:"sops"`
+}
+
+// decryptSOPS decrypts data if it is a SOPS encrypted document and returns it unchanged otherwise.
+// The keys (age, KMS, PGP...) are found the way the sops CLI finds them, e.g. SOPS_AGE_KEY_FILE.
+func decryptSOPS(data []byte) ([]byte, error) {
+	var doc sopsDocument
+	// templates are not always valid YAML, they can't be encrypted documents either
+	if err := yaml.Unmarshal(data, &doc); err != nil || doc.Sops == nil {
+		return data, nil
+	}
+
+	format := "yaml"
+	if isJSON(data) {
+		format = "json"
+	}
+	clear, err := decrypt.Data(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SOPS document: %v", err)
+	}
+	return clear, nil
+}
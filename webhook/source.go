@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"time"
+)
+
+// ConfigSource provides the sidecar config served by the webhook. New sources only have
+// to implement it, the server loop doesn't know where the config comes from.
+type ConfigSource interface {
+	// Load loads the config now. It returns a nil config and no error when the source
+	// knows the config did not change since the last load.
+	Load() (*Config, error)
+	// Watch calls notify with the result of each load following a change of the source,
+	// until stop is closed
+	Watch(notify func(*Config, error), stop <-chan struct{}) error
+	// String describes the source in logs
+	String() string
+}
+
+// fileSource reads the config from a file or a directory, see loadConfig
+type fileSource struct {
+	path       string
+	valuesFile string
+	debounce   time.Duration
+}
+
+// NewFileSource returns a source reading the config from path, rendered with valuesFile if
+// it is not empty, and reloading it debounce after the files stopped changing
+func NewFileSource(path, valuesFile string, debounce time.Duration) ConfigSource {
+	return &fileSource{path: path, valuesFile: valuesFile, debounce: debounce}
+}
+
+func (s *fileSource) Load() (*Config, error) {
+	c, err := loadConfig(s.path, s.valuesFile)
+	if err != nil {
+		return nil, err
+	}
+	c.stamp(s.String(), "")
+	return c, nil
+}
+
+func (s *fileSource) Watch(notify func(*Config, error), stop <-chan struct{}) error {
+	files := []string{s.path}
+	if s.valuesFile != "" {
+		files = append(files, s.valuesFile)
+	}
+	fw, err := NewFileWatcher(files...)
+	if err != nil {
+		return err
+	}
+
+	go fw.Run(s.debounce, func() {
+		notify(s.Load())
+	}, stop)
+	return nil
+}
+
+func (s *fileSource) String() string {
+	return "file " + s.path
+}
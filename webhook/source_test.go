@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// sourceConfig returns a config of the tests with the sidecar image image
+func sourceConfig(image string) []byte {
+	return []byte(strings.Replace(testConfig, "mesher:latest", image, 1))
+}
+
+// invalidSourceConfig is a config every source rejects, its container has no name
+var invalidSourceConfig = []byte(`apiVersion: injector.mesher.io/v1
+kind: SidecarConfig
+containers:
+- image: mesher:latest
+`)
+
+// sourceCase returns a source holding data, and the function replacing its data
+type sourceCase func(t *testing.T, data []byte) (ConfigSource, func(data []byte))
+
+func fileSourceCase(t *testing.T, data []byte) (ConfigSource, func([]byte)) {
+	file := filepath.Join(t.TempDir(), "sidecarconfig.yaml")
+	write := func(data []byte) {
+		// replaced as editors and kubelet do, never seen half written
+		tmp := file + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(data)
+	return NewFileSource(file, "", 50*time.Millisecond), write
+}
+
+func configMapSourceCase(t *testing.T, data []byte) (ConfigSource, func([]byte)) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "mesh", Name: "sidecar-injector", ResourceVersion: "1"},
+		Data:       map[string]string{DefaultConfigMapKey: string(data)},
+	}
+	client := fake.NewSimpleClientset(cm)
+	version := 1
+	update := func(data []byte) {
+		version++
+		cm := cm.DeepCopy()
+		cm.ResourceVersion = strconv.Itoa(version)
+		cm.Data[DefaultConfigMapKey] = string(data)
+		if _, err := client.CoreV1().ConfigMaps("mesh").Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewConfigMapSource(client, "mesh", "sidecar-injector", DefaultConfigMapKey), update
+}
+
+func resourceSourceCase(t *testing.T, data []byte) (ConfigSource, func([]byte)) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	name := types.NamespacedName{Name: "default"}
+	sc := &v1alpha1.SidecarConfiguration{ObjectMeta: metav1.ObjectMeta{Name: name.Name}}
+	if err := yaml.Unmarshal(data, &sc.Spec); err != nil {
+		t.Fatal(err)
+	}
+	client := fakeclient.NewFakeClientWithScheme(scheme, sc)
+	s := &resourceSource{reader: client, client: client, name: name}
+	update := func(data []byte) {
+		var current v1alpha1.SidecarConfiguration
+		if err := client.Get(context.TODO(), name, &current); err != nil {
+			t.Fatal(err)
+		}
+		current.Spec = v1alpha1.SidecarConfigurationSpec{}
+		if err := yaml.Unmarshal(data, &current.Spec); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Update(context.TODO(), &current); err != nil {
+			t.Fatal(err)
+		}
+		// the controller of the manager reconciles the changes
+		if _, err := s.Reconcile(context.TODO(), ctrl.Request{NamespacedName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, update
+}
+
+func httpSourceCase(t *testing.T, data []byte) (ConfigSource, func([]byte)) {
+	var lock sync.Mutex
+	current := data
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Write(current)
+	}))
+	t.Cleanup(srv.Close)
+
+	ca := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewHTTPSource(WebHookParameters{
+		SidecarConfigURL:          srv.URL + "/sidecarconfig.yaml",
+		SidecarConfigURLCAFile:    ca,
+		SidecarConfigPollInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func(data []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		current = data
+	}
+}
+
+func staticSourceCase(t *testing.T, data []byte) (ConfigSource, func([]byte)) {
+	s := NewStaticSource(data)
+	return s, func(data []byte) {
+		// the error is the one notified, checked by the contract
+		_ = s.Set(data)
+	}
+}
+
+// TestConfigSourceContract runs every ConfigSource through what the server loop expects of them
+func TestConfigSourceContract(t *testing.T) {
+	sources := map[string]sourceCase{
+		"file":      fileSourceCase,
+		"ConfigMap": configMapSourceCase,
+		"resource":  resourceSourceCase,
+		"HTTP":      httpSourceCase,
+		"static":    staticSourceCase,
+	}
+	for name, newSource := range sources {
+		t.Run(name, func(t *testing.T) {
+			s, update := newSource(t, sourceConfig("mesher:1"))
+
+			if s.String() == "" {
+				t.Error("String is empty, the logs can't tell the source")
+			}
+
+			c, err := s.Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if c == nil || len(c.Containers) != 1 || c.Containers[0].Image != "mesher:1" {
+				t.Fatalf("Load returned %+v, expected the sidecar mesher:1", c)
+			}
+			if c.Hash() == "" {
+				t.Error("the loaded config has no hash")
+			}
+
+			type result struct {
+				c   *Config
+				err error
+			}
+			// the polling sources notify every load, the stale ones are dropped rather than blocking them
+			notified := make(chan result, 100)
+			notify := func(c *Config, err error) {
+				select {
+				case notified <- result{c, err}:
+				default:
+				}
+			}
+			stop := make(chan struct{})
+			defer close(stop)
+			if err := s.Watch(notify, stop); err != nil {
+				t.Fatalf("Watch: %v", err)
+			}
+			// the informers and watches start asynchronously
+			time.Sleep(200 * time.Millisecond)
+
+			// next waits for the notification of the update matching done, skipping the unchanged loads
+			next := func(done func(result) bool) {
+				t.Helper()
+				timeout := time.After(10 * time.Second)
+				for {
+					select {
+					case r := <-notified:
+						if done(r) {
+							return
+						}
+					case <-timeout:
+						t.Fatal("no notification of the change")
+					}
+				}
+			}
+
+			update(sourceConfig("mesher:2"))
+			next(func(r result) bool {
+				return r.err == nil && r.c != nil && len(r.c.Containers) == 1 && r.c.Containers[0].Image == "mesher:2"
+			})
+
+			update(invalidSourceConfig)
+			next(func(r result) bool { return r.err != nil })
+
+			if c, err := s.Load(); err == nil {
+				t.Errorf("Load of the invalid config returned %+v", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// spiffeFetchTimeout bounds the fetch of an SVID from the Workload API
+const spiffeFetchTimeout = 30 * time.Second
+
+// spiffeCertSource reads the key pair from the X.509 SVID the SPIFFE Workload API, such as the
+// SPIRE agent, issues to the injector. The agent rotates it, each rotation is streamed.
+type spiffeCertSource struct {
+	addr string
+}
+
+// NewSPIFFECertSource returns a source serving the default X.509 SVID of the Workload API
+// listening at addr, e.g. unix:///run/spire/sockets/agent.sock
+func NewSPIFFECertSource(addr string) CertSource {
+	return &spiffeCertSource{addr: addr}
+}
+
+func (s *spiffeCertSource) Load() (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), spiffeFetchTimeout)
+	defer cancel()
+	svid, err := workloadapi.FetchX509SVID(ctx, workloadapi.WithAddr(s.addr))
+	if err != nil {
+		return nil, err
+	}
+	return svidPair(svid)
+}
+
+func (s *spiffeCertSource) Watch(notify func(*tls.Certificate, error), stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		// reconnects by itself until the context is canceled
+		err := workloadapi.WatchX509Context(ctx, &svidWatcher{notify: notify}, workloadapi.WithAddr(s.addr))
+		if err != nil && ctx.Err() == nil {
+			notify(nil, err)
+		}
+	}()
+	return nil
+}
+
+func (s *spiffeCertSource) String() string {
+	return "SPIFFE Workload API " + s.addr
+}
+
+// svidWatcher passes the default SVID of each update of the Workload API to notify
+type svidWatcher struct {
+	notify func(*tls.Certificate, error)
+}
+
+func (w *svidWatcher) OnX509ContextUpdate(c *workloadapi.X509Context) {
+	svid := c.DefaultSVID()
+	if svid == nil {
+		w.notify(nil, errors.New("no X.509 SVID issued"))
+		return
+	}
+	w.notify(svidPair(svid))
+}
+
+func (w *svidWatcher) OnX509ContextWatchError(err error) {
+	w.notify(nil, err)
+}
+
+// svidPair returns the key pair of svid
+func svidPair(svid *x509svid.SVID) (*tls.Certificate, error) {
+	if len(svid.Certificates) == 0 {
+		return nil, errors.New("no certificate in the X.509 SVID " + svid.ID.String())
+	}
+	pair := &tls.Certificate{PrivateKey: svid.PrivateKey}
+	for _, c := range svid.Certificates {
+		pair.Certificate = append(pair.Certificate, c.Raw)
+	}
+	return pair, nil
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// StaticSource is a config held in memory and replaced by Set, such as in the tests of clients
+type StaticSource struct {
+	lock     sync.Mutex
+	data     []byte
+	version  int
+	watchers []staticWatcher
+}
+
+// staticWatcher is a notify function of Watch and the channel stopping it
+type staticWatcher struct {
+	notify func(*Config, error)
+	stop   <-chan struct{}
+}
+
+// NewStaticSource returns a source of the config data, as it would be in the ConfigMap
+func NewStaticSource(data []byte) *StaticSource {
+	return &StaticSource{data: data}
+}
+
+func (s *StaticSource) Load() (*Config, error) {
+	s.lock.Lock()
+	data, version := s.data, s.version
+	s.lock.Unlock()
+	c, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	c.stamp(fmt.Sprintf("%s@%d", s, version), strconv.Itoa(version))
+	return c, nil
+}
+
+// Set replaces the config by data and notifies the watchers, it returns why data is invalid
+func (s *StaticSource) Set(data []byte) error {
+	s.lock.Lock()
+	s.data = data
+	s.version++
+	watchers := append([]staticWatcher(nil), s.watchers...)
+	s.lock.Unlock()
+
+	c, err := s.Load()
+	for _, w := range watchers {
+		select {
+		case <-w.stop:
+		default:
+			w.notify(c, err)
+		}
+	}
+	return err
+}
+
+func (s *StaticSource) Watch(notify func(*Config, error), stop <-chan struct{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.watchers = append(s.watchers, staticWatcher{notify: notify, stop: stop})
+	return nil
+}
+
+func (s *StaticSource) String() string {
+	return "static config"
+}
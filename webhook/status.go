@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// statusInjected is the state of an injected pod, it was the whole status annotation of older injectors
+const statusInjected = "injected"
+
+// statusSkipped is the state of a pod created without sidecar, its Reason tells why
+const statusSkipped = "skipped"
+
+// injectionStatus is the value of the status annotation, recording what was injected so pods
+// running a stale sidecar template can be found and rolled out again
+type injectionStatus struct {
+	State      string `json:"state"`
+	ConfigHash string `json:"configHash,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	Version    string `json:"version,omitempty"`
+	// Reason is the metrics.SkipReason of a skipped pod
+	Reason string `json:"reason,omitempty"`
+}
+
+// parseStatus reads a status annotation, accepting the plain "injected" of older injectors
+func parseStatus(value string) injectionStatus {
+	var s injectionStatus
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		s.State = strings.ToLower(value)
+		return s
+	}
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		log.Warnf("invalid status annotation %q: %v", value, err)
+	}
+	return s
+}
+
+func (s injectionStatus) injected() bool {
+	return s.State == statusInjected
+}
+
+func (s injectionStatus) String() string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return s.State
+	}
+	return string(data)
+}
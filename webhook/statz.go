@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	"github.com/go-chassis/sidecar-injector/metrics"
+)
+
+// started is when the replica started counting the admissions
+var started = time.Now()
+
+// namespaceStats is the admissions of the objects of a namespace since the start, with the
+// reasons of the skips and errors
+type namespaceStats struct {
+	v1alpha1.NamespaceInjections `json:",inline"`
+	// SkipReasons counts the skipped objects by skip reason, ErrorReasons the failed mutations by
+	// status reason
+	SkipReasons  map[string]int64 `json:"skipReasons,omitempty"`
+	ErrorReasons map[string]int64 `json:"errorReasons,omitempty"`
+}
+
+// namespaceInjections returns the admissions of the replica by namespace, sorted by name, from the
+// counters of the metrics
+func namespaceInjections() []namespaceStats {
+	namespaces := map[string]*namespaceStats{}
+	count := func(vec map[string]map[string]float64, add func(n *namespaceStats, reason string, v int64)) {
+		for namespace, values := range vec {
+			n, ok := namespaces[namespace]
+			if !ok {
+				n = &namespaceStats{NamespaceInjections: v1alpha1.NamespaceInjections{Namespace: namespace}}
+				namespaces[namespace] = n
+			}
+			for reason, v := range values {
+				add(n, reason, int64(v))
+			}
+		}
+	}
+	count(metrics.Totals(metrics.Injections, "namespace", "profile"), func(n *namespaceStats, _ string, v int64) {
+		n.Injected += v
+	})
+	count(metrics.Totals(metrics.InjectionSkips, "namespace", "reason"), func(n *namespaceStats, reason string, v int64) {
+		n.Skipped += v
+		if n.SkipReasons == nil {
+			n.SkipReasons = map[string]int64{}
+		}
+		n.SkipReasons[reason] += v
+	})
+	count(metrics.Totals(metrics.InjectionErrors, "namespace", "reason"), func(n *namespaceStats, reason string, v int64) {
+		n.Errors += v
+		if n.ErrorReasons == nil {
+			n.ErrorReasons = map[string]int64{}
+		}
+		n.ErrorReasons[reason] += v
+	})
+
+	stats := make([]namespaceStats, 0, len(namespaces))
+	for _, n := range namespaces {
+		stats = append(stats, *n)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Namespace < stats[j].Namespace })
+	return stats
+}
+
+// statz serves the admissions of the replica by namespace since it started, those of the
+// namespace query parameter if given
+func (wh *WebHookServer) statz(w http.ResponseWriter, r *http.Request) {
+	stats := namespaceInjections()
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		filtered := []namespaceStats{}
+		for _, n := range stats {
+			if n.Namespace == namespace {
+				filtered = append(filtered, n)
+			}
+		}
+		stats = filtered
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Since      time.Time        `json:"since"`
+		Namespaces []namespaceStats `json:"namespaces"`
+	}{Since: started, Namespaces: stats})
+}
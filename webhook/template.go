@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// templateData is what the config template is executed with
+type templateData struct {
+	Values map[string]interface{}
+	Pod    *corev1.Pod
+}
+
+// configTemplate is a config rendered for each pod from its documents and values
+type configTemplate struct {
+	docs   []document
+	values map[string]interface{}
+}
+
+func loadValues(valuesFile string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(valuesFile)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = decryptSOPS(data); err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// templateFuncs returns the sprig functions plus the helpers computing values from the pod
+func templateFuncs(pod *corev1.Pod) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	// annotationOrDefault returns the pod annotation key, or def if the pod doesn't have it
+	funcs["annotationOrDefault"] = func(key, def string) string {
+		if v, ok := pod.Annotations[key]; ok {
+			return v
+		}
+		return def
+	}
+	// portListFromContainers returns the comma separated container ports of the pod
+	funcs["portListFromContainers"] = func() string {
+		var ports []string
+		for _, c := range pod.Spec.Containers {
+			for _, p := range c.Ports {
+				ports = append(ports, strconv.Itoa(int(p.ContainerPort)))
+			}
+		}
+		return strings.Join(ports, ",")
+	}
+	return funcs
+}
+
+// renderDocument executes a config template, a value missing from the values file is an error
+func renderDocument(doc document, data templateData) ([]byte, error) {
+	t, err := template.New(doc.name).Funcs(templateFuncs(data.Pod)).Option("missingkey=error").Parse(string(doc.data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// render builds the config for pod
+func (t *configTemplate) render(pod *corev1.Pod) (*Config, error) {
+	data := templateData{Values: t.values, Pod: pod}
+	docs := make([]document, 0, len(t.docs))
+	for _, doc := range t.docs {
+		rendered, err := renderDocument(doc, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render config template %s: %v", doc.name, err)
+		}
+		docs = append(docs, document{name: doc.name, data: rendered})
+	}
+	return buildConfig(docs)
+}
@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// DefaultTLSMinVersion is the oldest TLS version served by default
+const DefaultTLSMinVersion = "1.2"
+
+// tlsVersions are the TLS versions by name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the elliptic curves by name
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// configureTLS sets the minimum version, cipher suites and curves of the parameters on config,
+// an empty list keeps the defaults of crypto/tls
+func configureTLS(config *tls.Config, p WebHookParameters) error {
+	minVersion := p.TLSMinVersion
+	if minVersion == "" {
+		minVersion = DefaultTLSMinVersion
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", minVersion)
+	}
+	config.MinVersion = version
+
+	// the insecure suites can be asked for by name, a compliance baseline may list them
+	suites := map[string]uint16{}
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[s.Name] = s.ID
+	}
+	for _, name := range p.TLSCipherSuites {
+		id, ok := suites[name]
+		if !ok {
+			return fmt.Errorf("unknown cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	for _, name := range p.TLSCurvePreferences {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return fmt.Errorf("unknown curve %q, expected X25519, P256, P384 or P521", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Validate checks the config beyond what decoding catches, resource quantities
+// are already parsed by then
+func (c *Config) Validate() error {
+	return utilerrors.NewAggregate(c.validate(""))
+}
+
+func (c *Config) validate(prefix string) []error {
+	var errs []error
+
+	volumes := map[string]bool{}
+	for i, v := range c.Volumes {
+		switch {
+		case v.Name == "":
+			errs = append(errs, fmt.Errorf("%svolumes[%d]: name is required", prefix, i))
+		case volumes[v.Name]:
+			errs = append(errs, fmt.Errorf("%svolumes[%d]: duplicate name %q", prefix, i, v.Name))
+		}
+		volumes[v.Name] = true
+	}
+
+	for i, s := range c.ImagePullSecret {
+		if s.Name == "" {
+			errs = append(errs, fmt.Errorf("%simagePullSecrets[%d]: name is required", prefix, i))
+		}
+	}
+
+	// container names must be unique across containers and init containers
+	names := map[string]bool{}
+	errs = append(errs, validateContainers(prefix+"containers", c.Containers, names, volumes)...)
+	errs = append(errs, validateContainers(prefix+"initContainers", c.InitContainers, names, volumes)...)
+
+	errs = append(errs, validateMetadata(prefix+"annotations", c.Annotations, false)...)
+	errs = append(errs, validateMetadata(prefix+"labels", c.Labels, true)...)
+	errs = append(errs, validateMetadata(prefix+"cniAnnotations", c.CNIAnnotations, false)...)
+	if c.PortsEnv != "" {
+		for _, msg := range validation.IsEnvVarName(c.PortsEnv) {
+			errs = append(errs, fmt.Errorf("%sportsEnv: invalid env variable name %q: %s", prefix, c.PortsEnv, msg))
+		}
+	}
+
+	profiles := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	for _, name := range profiles {
+		if p := c.Profiles[name]; p != nil {
+			errs = append(errs, p.validate(fmt.Sprintf("%sprofiles[%s].", prefix, name))...)
+		}
+	}
+	return errs
+}
+
+func validateContainers(field string, containers []corev1.Container, names, volumes map[string]bool) []error {
+	var errs []error
+	for i, c := range containers {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		switch {
+		case c.Name == "":
+			errs = append(errs, fmt.Errorf("%s: name is required", path))
+		case names[c.Name]:
+			errs = append(errs, fmt.Errorf("%s: duplicate container name %q", path, c.Name))
+		}
+		names[c.Name] = true
+
+		if _, err := reference.ParseNormalizedNamed(c.Image); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid image %q: %v", path, c.Image, err))
+		}
+		for _, m := range c.VolumeMounts {
+			if !volumes[m.Name] {
+				errs = append(errs, fmt.Errorf("%s: volume mount %q refers to an undefined volume", path, m.Name))
+			}
+		}
+		for name, limit := range c.Resources.Limits {
+			if request, ok := c.Resources.Requests[name]; ok && request.Cmp(limit) > 0 {
+				errs = append(errs, fmt.Errorf("%s: %s request %s exceeds its limit %s", path, name, request.String(), limit.String()))
+			}
+		}
+	}
+	return errs
+}
+
+// validateMetadata checks the keys of the annotations or labels set on the pods, and the values of
+// the labels. The keys of the injector are its own.
+func validateMetadata(field string, entries []v1alpha1.PodMetadata, labels bool) []error {
+	var errs []error
+	keys := map[string]bool{}
+	for i, e := range entries {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		switch {
+		case keys[e.Key]:
+			errs = append(errs, fmt.Errorf("%s: duplicate key %q", path, e.Key))
+		case strings.HasPrefix(e.Key, annotationPrefix+"/"):
+			errs = append(errs, fmt.Errorf("%s: key %q is reserved to the injector", path, e.Key))
+		}
+		keys[e.Key] = true
+
+		for _, msg := range validation.IsQualifiedName(e.Key) {
+			errs = append(errs, fmt.Errorf("%s: invalid key %q: %s", path, e.Key, msg))
+		}
+		if labels {
+			for _, msg := range validation.IsValidLabelValue(e.Value) {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q: %s", path, e.Value, msg))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/inject"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validation checks the invariants of the injection once all the mutating webhooks ran: an
+// object the policies require the sidecar for has it, and its status annotation tells the truth
+func (wh *WebHookServer) validation(_ context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	pod, _, err := podOf(req)
+	if err != nil {
+		return wh.failure(http.StatusBadRequest, metav1.StatusReasonBadRequest, "can't decode the object", err)
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+
+	required, profile, _, warnings := wh.decide(&pod.ObjectMeta)
+	value := pod.Annotations[webhookStatusKey]
+	status := parseStatus(value)
+	switch {
+	case value != "" && !status.injected() && status.State != statusDrifted && status.State != statusSkipped:
+		return wh.violation(warnings, "annotation %s value %q is not a valid status", webhookStatusKey, value)
+	case status.State == statusDrifted:
+		// flagged when the sidecar went missing, the pod has to be recreated but can still be updated
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: append(warnings, "the sidecar is missing, recreate the pod")}
+	case status.injected():
+		profile = status.Profile
+	case status.State == statusSkipped && status.Reason == string(metrics.SkipError):
+		// admitted without sidecar by the mutation, failing open or over the injection budget
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	case required && req.Operation == admissionv1.Create:
+		return wh.violation(warnings, "%s %s/%s requires the sidecar but was not injected", req.Kind.Kind, pod.Namespace, podIdentity(req, pod))
+	default:
+		// pods created before the injector or without sidecar are left alone
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	}
+
+	rootConfig := wh.SidecarConfig()
+	if rootConfig == nil {
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	}
+	sidecarConfig, err := rootConfig.forPod(pod, profile)
+	if err != nil {
+		log.Warnf("Could not check the sidecar of %s/%s: %v", pod.Namespace, podIdentity(req, pod), err)
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	}
+	if missing := missingSidecar(pod, sidecarConfig); missing != "" {
+		return wh.violation(warnings, "annotation %s says injected but the sidecar container %s is missing", webhookStatusKey, missing)
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+}
+
+// missingSidecar returns the first container of the sidecar missing from pod, empty if none is
+func missingSidecar(pod *corev1.Pod, sidecarConfig *Config) string {
+	for _, c := range sidecarConfig.Containers {
+		if !inject.HasContainer(pod.Spec.Containers, c.Name) {
+			return c.Name
+		}
+	}
+	if pod.Annotations[webhookInterceptionKey] == "cni" {
+		// injected without the init containers, the CNI plugin intercepts the traffic
+		return ""
+	}
+	for _, c := range sidecarConfig.InitContainers {
+		if !inject.HasContainer(pod.Spec.InitContainers, c.Name) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// violation rejects an object breaking an invariant of the injection, or only warns about it
+// when the injector fails open: the mutation may have admitted it without sidecar on purpose
+func (wh *WebHookServer) violation(warnings []string, format string, args ...interface{}) *admissionv1.AdmissionResponse {
+	message := fmt.Sprintf(format, args...)
+	if wh.parms.FailOpen {
+		log.Warnf("%s, admitting", message)
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: append(warnings, message)}
+	}
+
+	log.Errorf("%s, rejecting", message)
+	return &admissionv1.AdmissionResponse{
+		Allowed:  false,
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,249 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	"github.com/howeyc/fsnotify"
+)
+
+// Backoff of the attempts to watch the files again once the watch broke
+const (
+	rewatchMinBackoff = time.Second
+	rewatchMaxBackoff = time.Minute
+)
+
+// watchers are the running FileWatchers, the readiness fails while one of them is broken
+var watchers sync.Map
+
+// FileWatcher watches the directories of a set of files and tells which events change them.
+// Mounted ConfigMaps and Secrets are symlinks into a "..data" directory that kubelet swaps
+// atomically, so a file changes when the path it resolves to changes as well.
+// When one of the files is a directory any event inside it is a change.
+type FileWatcher struct {
+	*fsnotify.Watcher
+	// files maps the watched files to the path they resolve to
+	files map[string]string
+	dirs  map[string]bool
+	// trees are the watched directories whose whole content matters
+	trees map[string]bool
+	// name labels the watcher in the metrics
+	name string
+
+	lock sync.Mutex
+	// err is why the files are not watched, nil while they are
+	err error
+}
+
+// NewFileWatcher starts watching the directories of files
+func NewFileWatcher(files ...string) (*FileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &FileWatcher{
+		Watcher: watcher,
+		files:   map[string]string{},
+		dirs:    map[string]bool{},
+		trees:   map[string]bool{},
+		name:    strings.Join(files, ","),
+	}
+	for _, file := range files {
+		file = filepath.Clean(file)
+		dir := filepath.Dir(file)
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			fw.trees[file] = true
+			dir = file
+		} else {
+			fw.files[file] = resolve(file)
+		}
+		if fw.dirs[dir] {
+			continue
+		}
+		if err := watcher.Watch(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("could not watch %v: %v", file, err)
+		}
+		fw.dirs[dir] = true
+	}
+	metrics.WatcherHealthy.WithLabelValues(fw.name).Set(1)
+	return fw, nil
+}
+
+// Err tells why the files are not watched, nil while they are
+func (fw *FileWatcher) Err() error {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	return fw.err
+}
+
+// setErr records whether the files are watched
+func (fw *FileWatcher) setErr(err error) {
+	fw.lock.Lock()
+	fw.err = err
+	fw.lock.Unlock()
+	healthy := 1.0
+	if err != nil {
+		healthy = 0
+	}
+	metrics.WatcherHealthy.WithLabelValues(fw.name).Set(healthy)
+}
+
+// watchersError returns the error of a broken FileWatcher, nil if they all watch their files
+func watchersError() error {
+	var err error
+	watchers.Range(func(key, _ interface{}) bool {
+		fw := key.(*FileWatcher)
+		if e := fw.Err(); e != nil {
+			err = fmt.Errorf("%s not watched: %v", fw.name, e)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// resolve returns the real path of file, or an empty string if it does not exist
+func resolve(file string) string {
+	p, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// Changed tells whether ev changes one of the watched files, and watches again a
+// directory whose watch was dropped by a remove or rename
+func (fw *FileWatcher) Changed(ev *fsnotify.FileEvent) bool {
+	name := filepath.Clean(ev.Name)
+	changed := false
+
+	if fw.dirs[name] && (ev.IsDelete() || ev.IsRename()) {
+		fw.rewatch(name)
+		changed = true
+	}
+	if fw.trees[filepath.Dir(name)] {
+		changed = true
+	}
+
+	for file, real := range fw.files {
+		current := resolve(file)
+		if current != real {
+			// the symlink was swapped, or the file was removed or created
+			fw.files[file] = current
+			changed = true
+			continue
+		}
+		if (name == file || name == real) && (ev.IsModify() || ev.IsCreate()) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (fw *FileWatcher) rewatch(dir string) {
+	_ = fw.RemoveWatch(dir)
+	if err := fw.Watch(dir); err != nil {
+		log.Errorf("failed to watch %s again: %v", dir, err)
+		fw.setErr(err)
+		return
+	}
+	log.Infof("watching %s again", dir)
+}
+
+// restart replaces the fsnotify watcher by a new one watching all the directories
+func (fw *FileWatcher) restart() error {
+	fw.Watcher.Close()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for dir := range fw.dirs {
+		if err := watcher.Watch(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("could not watch %v: %v", dir, err)
+		}
+	}
+	fw.Watcher = watcher
+	return nil
+}
+
+// repair restarts the watch with backoff until it succeeds, or stop is closed. It tells
+// whether the files are watched again.
+func (fw *FileWatcher) repair(stop <-chan struct{}) bool {
+	backoff := rewatchMinBackoff
+	for {
+		err := fw.restart()
+		fw.setErr(err)
+		if err == nil {
+			log.Infof("watching %s again", fw.name)
+			return true
+		}
+		log.Errorf("failed to watch %s again, retrying in %s: %v", fw.name, backoff, err)
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > rewatchMaxBackoff {
+			backoff = rewatchMaxBackoff
+		}
+	}
+}
+
+// Run calls changed once the watched files stopped changing for debounce, until stop is closed.
+// A broken watch is restarted, and changed called as the events in between are lost.
+func (fw *FileWatcher) Run(debounce time.Duration, changed func(), stop <-chan struct{}) {
+	watchers.Store(fw, struct{}{})
+	defer func() {
+		watchers.Delete(fw)
+		fw.Close()
+	}()
+
+	// bursts of events, like kubelet updating several files, end up in a single call
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	reset := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(debounce)
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			changed()
+		case event, ok := <-fw.Event:
+			if !ok {
+				fw.setErr(fmt.Errorf("watch of %s closed", fw.name))
+			} else if fw.Changed(event) {
+				reset()
+			}
+		case err := <-fw.Error:
+			log.Errorf("watcher error: %v", err)
+			fw.setErr(err)
+		case <-stop:
+			return
+		}
+
+		if fw.Err() != nil {
+			if !fw.repair(stop) {
+				return
+			}
+			reset()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mountConfigMap writes data in dir as kubelet mounts a ConfigMap key, see mountVolume
+func mountConfigMap(t *testing.T, dir, file, version string, data []byte) {
+	t.Helper()
+	mountVolume(t, dir, version, map[string][]byte{file: data})
+}
+
+// mountVolume writes files in dir as kubelet mounts the keys of a ConfigMap or Secret: each
+// file is a symlink to ..data/file, ..data a symlink to the timestamped directory holding them,
+// swapped atomically
+func mountVolume(t *testing.T, dir, version string, files map[string][]byte) {
+	t.Helper()
+	ts := filepath.Join(dir, "..2021_01_01_00_00_"+version)
+	if err := os.Mkdir(ts, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(ts, file), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDir := filepath.Join(dir, "..data")
+	old, _ := os.Readlink(dataDir)
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(ts), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if old != "" {
+		if err := os.RemoveAll(filepath.Join(dir, old)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file := range files {
+		link := filepath.Join(dir, file)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join("..data", file), link); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// expectCalls fails unless calls receives n values within timeout, and no more until quiet is over
+func expectCalls(t *testing.T, calls <-chan struct{}, n int, timeout, quiet time.Duration) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for i := 0; i < n; i++ {
+		select {
+		case <-calls:
+		case <-deadline:
+			t.Fatalf("expected %d reloads, got %d", n, i)
+		}
+	}
+	select {
+	case <-calls:
+		t.Fatalf("expected %d reloads, got more", n)
+	case <-time.After(quiet):
+	}
+}
+
+func TestFileWatcherSymlinkSwap(t *testing.T) {
+	dir := t.TempDir()
+	mountConfigMap(t, dir, "sidecarconfig.yaml", "1", []byte(testConfig))
+	file := filepath.Join(dir, "sidecarconfig.yaml")
+
+	fw, err := NewFileWatcher(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go fw.Run(50*time.Millisecond, func() { calls <- struct{}{} }, stop)
+
+	// kubelet creates, swaps and removes several entries, the debounce makes a single reload of them
+	mountConfigMap(t, dir, "sidecarconfig.yaml", "2", []byte(testConfig+"# v2\n"))
+	expectCalls(t, calls, 1, 5*time.Second, 300*time.Millisecond)
+	if err := fw.Err(); err != nil {
+		t.Fatalf("the watch broke on the swap: %v", err)
+	}
+
+	// the directory is still watched after the swap
+	mountConfigMap(t, dir, "sidecarconfig.yaml", "3", []byte(testConfig+"# v3\n"))
+	expectCalls(t, calls, 1, 5*time.Second, 300*time.Millisecond)
+	if err := fw.Err(); err != nil {
+		t.Fatalf("the watch broke on the second swap: %v", err)
+	}
+}
+
+func TestFileWatcherIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	mountConfigMap(t, dir, "sidecarconfig.yaml", "1", []byte(testConfig))
+
+	fw, err := NewFileWatcher(filepath.Join(dir, "sidecarconfig.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go fw.Run(50*time.Millisecond, func() { calls <- struct{}{} }, stop)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "unrelated.yaml"), []byte("a: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, calls, 0, time.Second, 300*time.Millisecond)
+}
@@ -1,25 +1,38 @@
 package webhook
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"path/filepath"
+	"plugin"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/ghodss/yaml"
-	"github.com/howeyc/fsnotify"
+	"github.com/go-chassis/sidecar-injector/apis/v1alpha1"
+	"github.com/go-chassis/sidecar-injector/inject"
+	"github.com/go-chassis/sidecar-injector/metrics"
+	"github.com/go-chassis/sidecar-injector/policy"
+	"github.com/go-chassis/sidecar-injector/version"
+	"golang.org/x/net/http2"
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
 	admissionregistration "k8s.io/api/admissionregistration/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/kubernetes/pkg/apis/core/v1"
 )
 
@@ -32,395 +45,1164 @@ var (
 	defaulter = runtime.ObjectDefaulter(runtimeScheme)
 )
 
-const (
-	webhookInjectKey = "sidecar-injector-mesher.io/inject"
-	webhookStatusKey = "sidecar-injector-mesher.io/status"
-)
-
-//WebHookServer which has config contents
+// WebHookServer which has config contents
 type WebHookServer struct {
-	SidecarConfig *Config
-	Server        *http.Server
-	Watch         *fsnotify.Watcher
-	Lock          sync.RWMutex
-}
+	// sidecarConfig holds the *Config snapshot requests are served with, it is never modified once stored
+	sidecarConfig atomic.Value
+	// Server serves the webhook over TLS, nil if disabled
+	Server *http.Server
+	// InsecureServer serves the webhook over plain HTTP on localhost, nil if disabled
+	InsecureServer *http.Server
+	// AdminServer serves the probes, metrics and config status, nil if disabled
+	AdminServer *http.Server
+	Lock        sync.RWMutex
+	// configError is the error of the last config reload, guarded by Lock
+	configError error
+	// listenerErrors are the errors stopping the listeners by name, watchError the one of the
+	// watch of the config source, guarded by Lock
+	listenerErrors map[string]error
+	watchError     error
+	// certs serves the key pair to the TLS handshakes, nil if TLS is disabled, unless one of
+	// sniCerts is valid for the server name asked for
+	certs    *certProvider
+	sniCerts []*certProvider
+	// readyChecks are the readiness conditions besides the config and certificate
+	readyChecks []readyCheck
+	// draining is set once the server is shutting down
+	draining int32
+	// inflight holds a token per admission request being served, nil if unbounded
+	inflight chan struct{}
+	// namespaces rate limits the mutations of each namespace, nil if unlimited
+	namespaces *namespaceLimiter
+	// redactor masks the sensitive values of the patches logged
+	redactor *redactor
+	// audit records the mutations, nil if disabled
+	audit *auditSink
+	// capture dumps a sample of the mutation reviews, nil if disabled
+	capture *captureSink
+	// mutators add their operations to the patches after the sidecar
+	mutators []inject.Mutator
+	// patches keeps the last patches by config, profile and object, nil if disabled
+	patches *patchCache
+	// fence holds the reloaded configs back until all the replicas loaded them, nil if disabled
+	fence *configFence
+	// aliases are the annotation keys honored as those of the injector
+	aliases []annotationAlias
+	// Policies is consulted for pods without an explicit decision, nil disables it
+	Policies *policy.Index
+	// Events records the injections, skips and failures on the objects, nil disables them
+	Events record.EventRecorder
 
-//WebHookParameters contains Server parameters
-type WebHookParameters struct {
-	Port                int
-	CertFile            string
-	KeyFile             string
-	SidecarConfigFile   string
-	HealthCheckInterval time.Duration
-	HealthCheckFile     string
+	source       ConfigSource
+	parms        WebHookParameters
+	adminToken   []byte
+	webhookToken []byte
 }
 
-//Config has container, volume and image information
-type Config struct {
-	Containers      []corev1.Container            `yaml:"containers"`
-	Volumes         []corev1.Volume               `yaml:"volumes"`
-	ImagePullSecret []corev1.LocalObjectReference `yaml:"imagePullSecrets"`
+// readToken reads a token from file, nil if file is empty
+func readToken(file string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	token, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("no token in %s", file)
+	}
+	return token, nil
 }
 
-type operation struct {
-	Operation string      `json:"op"`
-	Path      string      `json:"path"`
-	Value     interface{} `json:"value,omitempty"`
+// WebHookParameters contains Server parameters
+type WebHookParameters struct {
+	Port int
+	// BindAddress is the IP the webhook listens on, all the interfaces if empty
+	BindAddress string
+	CertFile    string
+	KeyFile     string
+	// SNICerts are key pairs served instead of the default one to the clients asking for a server
+	// name they are valid for, e.g. the DNS name of another Service
+	SNICerts []CertKeyFiles
+	// CertSecret is the namespace/name of the Secret holding the key pair instead of CertFile and KeyFile
+	CertSecret string
+	// BootstrapCerts issues a self-signed key pair into CertSecret and patches the caBundle of the
+	// webhook configurations, for the Service ServiceName
+	BootstrapCerts                 bool
+	ServiceName                    string
+	MutatingWebhookConfiguration   string
+	ValidatingWebhookConfiguration string
+	CertValidity                   time.Duration
+	// SPIFFESocket is the address of the SPIFFE Workload API issuing the key pair
+	SPIFFESocket string
+	// CSRSignerName requests the key pair through CertificateSigningRequests to this signer, for
+	// the Service ServiceName in ServiceNamespace, waiting up to CSRTimeout for each
+	CSRSignerName     string
+	ServiceNamespace  string
+	CSRTimeout        time.Duration
+	SidecarConfigFile string
+	// SidecarValuesFile turns SidecarConfigFile into a template of its values
+	SidecarValuesFile string
+	// ClientCAFile makes the TLS port require client certificates signed by its CA certificates, such
+	// as the one of the API server, with a common name of AllowedClientCNs if it is not empty
+	ClientCAFile     string
+	AllowedClientCNs []string
+	// CertExpiryWarning is how long before the expiry of the certificate warnings are logged, and
+	// CertExpiryUnready how long before it the replica is unready, 0 disables them
+	CertExpiryWarning time.Duration
+	CertExpiryUnready time.Duration
+	// TLSMinVersion is the oldest TLS version accepted, DefaultTLSMinVersion if empty, and
+	// TLSCipherSuites and TLSCurvePreferences the names of the suites and curves allowed, in order
+	// of preference. The TLS 1.3 suites are not configurable.
+	TLSMinVersion       string
+	TLSCipherSuites     []string
+	TLSCurvePreferences []string
+	// DisableHTTP2 serves HTTP/1.1 only, for intermediaries breaking HTTP/2
+	DisableHTTP2 bool
+	// HTTP2MaxConcurrentStreams bounds the streams of an HTTP/2 connection
+	HTTP2MaxConcurrentStreams uint32
+	// InsecurePort serves the webhook over plain HTTP on localhost, for development
+	InsecurePort int
+	// DisableTLS only serves the webhook on InsecurePort, no certificate is needed
+	DisableTLS bool
+	// AdminPort is the plain HTTP port of the probes, metrics and config status, 0 disables it
+	AdminPort        int
+	AdminBindAddress string
+	// EnableDebugHandlers serves pprof and expvar on the admin port
+	EnableDebugHandlers bool
+	// HealthCheckFile is written with ok every HealthCheckInterval while /healthz passes, for
+	// exec probes, none if empty
+	HealthCheckFile     string
+	HealthCheckInterval time.Duration
+	// ReloadDebounce is how long file events are coalesced before reloading
+	ReloadDebounce  time.Duration
+	EnablePolicyCRD bool
+	// SidecarConfigResource is the namespace/name of the SidecarConfiguration to use instead of SidecarConfigFile
+	SidecarConfigResource string
+	// SidecarConfigMap is the namespace/name of the ConfigMap to use instead of SidecarConfigFile
+	SidecarConfigMap    string
+	SidecarConfigMapKey string
+	// SidecarConfigURL is the HTTPS URL to poll instead of reading SidecarConfigFile
+	SidecarConfigURL          string
+	SidecarConfigURLCAFile    string
+	SidecarConfigPollInterval time.Duration
+	// SidecarConfigCache keeps the last good config, served when the source is unreadable at startup
+	SidecarConfigCache string
+	// ReadTimeout, WriteTimeout and IdleTimeout bound the connections of the server
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// RequestTimeout bounds the mutation of requests not telling the timeout of the API server
+	RequestTimeout time.Duration
+	// ShutdownDelay is how long the server keeps serving after being asked to stop,
+	// ShutdownTimeout how long it then waits for the in-flight requests
+	ShutdownDelay   time.Duration
+	ShutdownTimeout time.Duration
+	// MaxInflightRequests bounds the admission requests served at once, 0 for no bound
+	MaxInflightRequests int
+	// NamespaceQPS and NamespaceBurst rate limit the mutations of each namespace, 0 QPS for no limit
+	NamespaceQPS   float32
+	NamespaceBurst int
+	// MaxRequestBytes bounds the size of the AdmissionReviews read
+	MaxRequestBytes int64
+	// FailOpen admits the objects the injector fails on without sidecar instead of rejecting them
+	FailOpen bool
+	// InjectionBudget bounds the time of a mutation, below the timeout of the API server, 0 for no
+	// budget. BudgetAction tells what is done with the objects not injected in time: BudgetAllow
+	// or BudgetDeny.
+	InjectionBudget time.Duration
+	BudgetAction    string
+	// ExcludedOwnerKinds are the kinds of the controllers whose pods are never injected
+	ExcludedOwnerKinds []string
+	// CNIEnabled tells that the mesh CNI plugin intercepts the traffic of all the namespaces, not
+	// only those labeled for it: the pods are injected without the init containers
+	CNIEnabled bool
+	// AnnotationAliases maps annotation keys, such as those of another mesh, to the keys of the
+	// injector they stand for, or prefixes to prefixes when they end with a /
+	AnnotationAliases map[string]string
+	// Mutators are the names of the inject.Mutators adding their operations to the patches, run
+	// in their registration order. MutatorPlugins are the Go plugins registering more of them.
+	Mutators       []string
+	MutatorPlugins []string
+	// PatchCacheSize is the number of patches kept by config, profile and object, so the pods of a
+	// workload are rendered once, 0 disables the cache. The mutators must depend on the pod only.
+	PatchCacheSize int
+	// ConfigFencing holds a reloaded config back until all the replicas reporting to the
+	// SidecarInjector loaded it, or for ConfigFenceTimeout at most, so they switch together
+	ConfigFencing      bool
+	ConfigFenceTimeout time.Duration
+	// AccessLogFile receives a JSON line per request of the webhook port, "-" for the standard
+	// output, none if empty. AccessLogSampling is the share of the successful requests logged.
+	AccessLogFile     string
+	AccessLogSampling float64
+	// AuditFile receives a JSON line per mutation with its full patch, "-" for the standard output,
+	// none if empty. It is rotated once it reaches AuditMaxSizeMB, keeping AuditMaxBackups files.
+	AuditFile       string
+	AuditMaxSizeMB  int
+	AuditMaxBackups int
+	// CaptureDir receives a sample of the reviews of the mutations, sanitized, to replay them,
+	// none if empty. CaptureSampling is the share of them written, up to CaptureMaxFiles.
+	CaptureDir      string
+	CaptureSampling float64
+	CaptureMaxFiles int
+	// LogRedactPatterns match the env variables and annotations whose values are masked in the
+	// logged patches
+	LogRedactPatterns []string
+	// AdminTokenFile holds the bearer token of the admin endpoints, they are disabled without it
+	AdminTokenFile string
+	// WebhookTokenFile holds the token the admission requests must carry in WebhookTokenHeader,
+	// a bearer token when it is Authorization. The requests are not authenticated without it.
+	WebhookTokenFile   string
+	WebhookTokenHeader string
 }
 
 func init() {
 	_ = corev1.AddToScheme(runtimeScheme)
 	_ = admissionregistration.AddToScheme(runtimeScheme)
+	_ = admissionv1.AddToScheme(runtimeScheme)
+	_ = v1beta1.AddToScheme(runtimeScheme)
 	// https://github.com/kubernetes/kubernetes/issues/57982
 	_ = v1.AddToScheme(runtimeScheme)
 }
 
-//NewWebhook will load the configuration and create a server
-func NewWebhook(p WebHookParameters) (*WebHookServer, error) {
-	sidecarConfig, err := loadConfig(p.SidecarConfigFile)
+// NewWebhook will load the configuration from source and create a server serving the key pair
+// of certSource, the files of the parameters if it is nil
+func NewWebhook(p WebHookParameters, source ConfigSource, certSource CertSource) (*WebHookServer, error) {
+	if p.DisableTLS && p.InsecurePort == 0 {
+		return nil, errors.New("disabling TLS needs an insecure port to serve the webhook")
+	}
+	for _, addr := range []string{p.BindAddress, p.AdminBindAddress} {
+		if addr != "" && net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid bind address %q, expected an IP", addr)
+		}
+	}
+
+	if p.InjectionBudget > 0 {
+		if err := validateBudgetAction(p.BudgetAction); err != nil {
+			return nil, err
+		}
+	}
+
+	adminToken, err := readToken(p.AdminTokenFile)
 	if err != nil {
-		log.Errorf("Filed to load configuration: %v", err)
+		log.Errorf("failed to read the admin token: %v", err)
 		return nil, err
 	}
-
-	crt, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+	webhookToken, err := readToken(p.WebhookTokenFile)
 	if err != nil {
-		log.Errorf("Filed to load key pair: %v", err)
+		log.Errorf("failed to read the webhook token: %v", err)
 		return nil, err
 	}
+	if p.WebhookTokenHeader == "" {
+		p.WebhookTokenHeader = "Authorization"
+	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Errorf("failed to create a watcher object: %v", err)
+	wh := &WebHookServer{
+		source:       source,
+		parms:        p,
+		adminToken:   adminToken,
+		webhookToken: webhookToken,
+	}
+	if p.MaxInflightRequests > 0 {
+		wh.inflight = make(chan struct{}, p.MaxInflightRequests)
+	}
+	wh.namespaces = newNamespaceLimiter(p.NamespaceQPS, p.NamespaceBurst)
+	if wh.redactor, err = newRedactor(p.LogRedactPatterns); err != nil {
+		return nil, fmt.Errorf("invalid log redaction pattern: %v", err)
+	}
+	if wh.mutators, err = loadMutators(p); err != nil {
 		return nil, err
 	}
+	if wh.aliases, err = newAnnotationAliases(p.AnnotationAliases); err != nil {
+		return nil, err
+	}
+	wh.patches = newPatchCache(p.PatchCacheSize)
+	wh.fence = newConfigFence(p.ConfigFencing, p.ConfigFenceTimeout)
+	if p.AuditFile != "" {
+		if wh.audit, err = newAuditSink(p.AuditFile, p.AuditMaxSizeMB, p.AuditMaxBackups); err != nil {
+			log.Errorf("failed to open the audit file: %v", err)
+			return nil, err
+		}
+	}
+	if p.CaptureDir != "" {
+		if wh.capture, err = newCaptureSink(p.CaptureDir, p.CaptureSampling, p.CaptureMaxFiles, wh.redactor); err != nil {
+			log.Errorf("failed to create the capture directory: %v", err)
+			return nil, err
+		}
+	}
 
-	for _, file := range []string{p.SidecarConfigFile, p.CertFile, p.KeyFile} {
-		watchFile, _ := filepath.Split(file)
-		if err := watcher.Watch(watchFile); err != nil {
-			log.Errorf("failed to watch the files: %v", err)
-			return nil, fmt.Errorf("could not watch %v: %v", file, err)
+	// define http server and server handler
+	handler := wh.Handler()
+	if p.AccessLogFile != "" {
+		access, err := newAccessLog(p.AccessLogFile, p.AccessLogSampling)
+		if err != nil {
+			log.Errorf("failed to open the access log: %v", err)
+			return nil, err
 		}
+		handler = access.wrap(handler)
 	}
 
-	wh := &WebHookServer{
-		SidecarConfig: sidecarConfig,
-		Server: &http.Server{
-			Addr:      fmt.Sprintf(":%v", p.Port),
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{crt}},
-		},
-		Watch: watcher,
+	if !p.DisableTLS {
+		if certSource == nil {
+			certSource = NewFileCertSource(p.CertFile, p.KeyFile, p.ReloadDebounce)
+		}
+		wh.certs = newCertProvider(certSource)
+		for _, f := range p.SNICerts {
+			wh.sniCerts = append(wh.sniCerts, newCertProvider(NewFileCertSource(f.CertFile, f.KeyFile, p.ReloadDebounce)))
+		}
+		for _, c := range wh.allCerts() {
+			if err := c.reload(); err != nil {
+				return nil, err
+			}
+		}
+
+		wh.Server = &http.Server{
+			Addr:    net.JoinHostPort(p.BindAddress, strconv.Itoa(p.Port)),
+			Handler: handler,
+			// handshakes ask for the certificate, so the reloaded one is served at once
+			TLSConfig:    &tls.Config{GetCertificate: wh.getCertificate},
+			ReadTimeout:  p.ReadTimeout,
+			WriteTimeout: p.WriteTimeout,
+			IdleTimeout:  p.IdleTimeout,
+		}
+		if err := configureTLS(wh.Server.TLSConfig, p); err != nil {
+			log.Errorf("invalid TLS settings: %v", err)
+			return nil, err
+		}
+		if p.ClientCAFile != "" {
+			if err := requireClientCert(wh.Server.TLSConfig, p.ClientCAFile, p.AllowedClientCNs); err != nil {
+				log.Errorf("failed to load the client CA: %v", err)
+				return nil, err
+			}
+		}
+		if err := configureHTTP2(wh.Server, p); err != nil {
+			log.Errorf("failed to configure HTTP/2: %v", err)
+			return nil, err
+		}
 	}
 
-	// define http server and server handler
-	h := http.NewServeMux()
-	h.HandleFunc("/webhookmutation", wh.webhookMutation)
-	wh.Server.Handler = h
+	if p.InsecurePort != 0 {
+		// plain HTTP is for local development, it is never reachable from outside the host
+		wh.InsecureServer = &http.Server{
+			Addr:         net.JoinHostPort("127.0.0.1", strconv.Itoa(p.InsecurePort)),
+			Handler:      handler,
+			ReadTimeout:  p.ReadTimeout,
+			WriteTimeout: p.WriteTimeout,
+			IdleTimeout:  p.IdleTimeout,
+		}
+	}
+
+	if p.AdminPort != 0 {
+		wh.AdminServer = &http.Server{
+			Addr:        net.JoinHostPort(p.AdminBindAddress, strconv.Itoa(p.AdminPort)),
+			Handler:     wh.AdminHandler(),
+			ReadTimeout: p.ReadTimeout,
+			IdleTimeout: p.IdleTimeout,
+		}
+	}
+
+	// without config the server starts unready, the source may deliver one later
+	wh.reloadConfig(source.Load())
 
 	return wh, nil
 }
 
+// Handler returns the handler of the webhook endpoints, the one of the webhook port without its
+// access log
+func (wh *WebHookServer) Handler() http.Handler {
+	h := http.NewServeMux()
+	mutate := wh.withinBudget(wh.mutate)
+	h.HandleFunc("/mutate", wh.serveReview(metrics.WebhookMutate, mutate))
+	// the path of the first webhook configurations
+	h.HandleFunc("/webhookmutation", wh.serveReview(metrics.WebhookMutate, mutate))
+	h.HandleFunc("/validate", wh.serveReview(metrics.WebhookValidate, wh.validation))
+	// the admin token must not travel in plain text, reloads stay behind TLS or on localhost
+	h.HandleFunc("/-/reload", wh.reloadHandler)
+	h.HandleFunc("/-/loglevel", wh.logLevelHandler)
+	return h
+}
+
+// Reload loads the config and the certificates again and returns why it failed, if it did
+func (wh *WebHookServer) Reload() error {
+	sidecarConfig, err := wh.source.Load()
+	wh.reloadConfig(sidecarConfig, err)
+	return utilerrors.NewAggregate([]error{err, wh.reloadCert()})
+}
+
+// reloadCert loads the certificates again
+func (wh *WebHookServer) reloadCert() error {
+	var errs []error
+	for _, c := range wh.allCerts() {
+		errs = append(errs, c.reload())
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
 // (https://github.com/kubernetes/kubernetes/issues/57982)
-func applyDefaultsWorkaround(containers []corev1.Container, volumes []corev1.Volume, secrets []corev1.LocalObjectReference) {
+func applyDefaultsWorkaround(containers, initContainers []corev1.Container, volumes []corev1.Volume, secrets []corev1.LocalObjectReference) {
 	defaulter.Default(&corev1.Pod{
 		Spec: corev1.PodSpec{
 			Containers:       containers,
+			InitContainers:   initContainers,
 			Volumes:          volumes,
 			ImagePullSecrets: secrets,
 		},
 	})
 }
 
-func loadConfig(cfgFile string) (*Config, error) {
-	data, err := ioutil.ReadFile(cfgFile)
+// SetConfig replaces the sidecar config served by the webhook, c must not be modified afterwards.
+// A defaulted copy is served, the requests never default nor modify it.
+func (wh *WebHookServer) SetConfig(c *Config) {
+	wh.sidecarConfig.Store(c.defaulted())
+	// a single series, for the replicas to be compared by hash
+	metrics.ConfigInfo.Reset()
+	metrics.ConfigInfo.WithLabelValues(c.Hash()).Set(1)
+}
+
+// SidecarConfig returns the config snapshot currently served
+func (wh *WebHookServer) SidecarConfig() *Config {
+	c, _ := wh.sidecarConfig.Load().(*Config)
+	return c
+}
+
+// reloadConfig applies a config reloaded from the source, or records the failure to load it and
+// keeps serving the last known good config. A nil config without error means it did not change.
+func (wh *WebHookServer) reloadConfig(c *Config, err error) {
+	if c == nil && err == nil {
+		return
+	}
 	if err != nil {
-		return nil, err
+		log.Errorf("update error from %s: %v", wh.source, err)
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	wh.Lock.Lock()
+	wh.configError = err
+	wh.Lock.Unlock()
+
+	if err != nil {
+		errCount := 1
+		if agg, ok := err.(utilerrors.Aggregate); ok {
+			errCount = len(agg.Errors())
+		}
+		metrics.ConfigValidationErrors.Set(float64(errCount))
+		metrics.ConfigReloads.WithLabelValues(metrics.ResultFailure).Inc()
+		return
+	}
+	metrics.ConfigValidationErrors.Set(0)
+	metrics.ConfigReloads.WithLabelValues(metrics.ResultSuccess).Inc()
+	metrics.ConfigLastReloadSuccess.SetToCurrentTime()
+	// the first config is served right away, the replica has nothing else to serve
+	if current := wh.SidecarConfig(); wh.fence != nil && current != nil {
+		if current.Hash() != c.Hash() {
+			wh.fence.stage(c)
+			return
+		}
+		wh.fence.clear()
 	}
+	wh.SetConfig(c)
+}
 
-	return &cfg, nil
+// debugConfig serves the document the active config was decoded from, with its env values, annotations
+// and Secret names masked: the admin port has no authentication
+func (wh *WebHookServer) debugConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	c := wh.SidecarConfig()
+	if c == nil {
+		http.Error(w, "no sidecar config loaded", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := w.Write(wh.redactor.source(c.source)); err != nil {
+		log.Errorf("Can't write debug config response: %v", err)
+	}
 }
 
-func requiredMutation(metaData *metav1.ObjectMeta) bool {
+// configz serves the active config, where and when it was loaded from, and the error of the last
+// reload. The config is masked as by debugConfig.
+func (wh *WebHookServer) configz(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Config    json.RawMessage `json:"config"`
+		Source    string          `json:"source"`
+		LoadedAt  time.Time       `json:"loadedAt"`
+		Hash      string          `json:"hash"`
+		LastError string          `json:"lastError,omitempty"`
+		// StagedHash is the config held back until all the replicas loaded it
+		StagedHash string `json:"stagedHash,omitempty"`
+	}{}
+	if c := wh.SidecarConfig(); c != nil {
+		status.Source, status.LoadedAt, status.Hash = c.origin, c.loadedAt, c.hash
+		if data, err := json.Marshal(c); err == nil {
+			status.Config = wh.redactor.config(data)
+		}
+	}
+	if wh.fence != nil {
+		status.StagedHash = wh.fence.stagedHash()
+	}
+
+	wh.Lock.RLock()
+	if wh.configError != nil {
+		status.LastError = wh.configError.Error()
+	}
+	wh.Lock.RUnlock()
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// requiredMutation decides whether to inject and which profile to use. The warnings tell the
+// user why a pod asking for the sidecar doesn't get it.
+func (wh *WebHookServer) requiredMutation(metaData *metav1.ObjectMeta) (bool, string, metrics.SkipReason, []string) {
+	status := metaData.GetAnnotations()[webhookStatusKey]
+	mRequired, profile, reason, warnings := wh.decide(metaData)
+	if parseStatus(status).injected() {
+		mRequired, reason = false, metrics.SkipInjected
+	}
+
+	log.WithFields(log.Fields{"namespace": metaData.Namespace, "name": metaData.Name, "status": status, "required": mRequired}).Info("Mutation policy")
+	return mRequired, profile, reason, warnings
+}
+
+// decide tells whether the policies and annotations ask for the sidecar, whatever was injected
+// already, and with which profile
+func (wh *WebHookServer) decide(metaData *metav1.ObjectMeta) (bool, string, metrics.SkipReason, []string) {
 	annotations := metaData.GetAnnotations()
 	if annotations == nil {
 		annotations = map[string]string{}
 	}
 
-	status := annotations[webhookStatusKey]
+	var decision policy.Decision
+	if wh.Policies != nil {
+		decision = wh.Policies.Evaluate(metaData.Namespace, metaData.Labels)
+	}
 
 	// determine whether to perform mutation based on annotation for the destination resource
 	var mRequired bool
-	if strings.ToLower(status) == "injected" {
-		mRequired = false
-	} else {
-		switch strings.ToLower(annotations[webhookInjectKey]) {
+	var reason metrics.SkipReason
+	var warnings []string
+	inject, injectKey := wh.annotation(annotations, webhookInjectKey)
+	value := strings.ToLower(inject)
+	if injectKey != webhookInjectKey {
+		// the keys of other meshes, such as sidecar.istio.io/inject, take true and false
+		switch value {
+		case "true":
+			value = "yes"
+		case "false":
+			value = "no"
+		}
+	}
+	switch value {
+	case "", "y", "yes", "n", "no", injectRemove:
+	default:
+		expected := "yes, no or " + injectRemove
+		if injectKey != webhookInjectKey {
+			expected = "yes, no, true, false or " + injectRemove
+		}
+		warnings = append(warnings, fmt.Sprintf("annotation %s value %q not recognized, expected %s", injectKey, inject, expected))
+	}
+	owner := metav1.GetControllerOf(metaData)
+	switch {
+	case decision.Excluded:
+		reason = metrics.SkipPolicy
+		if decision.Reason == policy.ReasonNamespaceExcluded {
+			reason = metrics.SkipNamespace
+		}
+		warnings = append(warnings, skipWarning(reason, "%s by SidecarInjectionPolicy %s", decision.Reason, decision.Policy))
+	case owner != nil && wh.excludedOwnerKind(owner.Kind):
+		reason = metrics.SkipOwnerKind
+		warnings = append(warnings, skipWarning(reason, "the pods of a %s are not injected", owner.Kind))
+	default:
+		switch value {
 		default:
-			mRequired = false
+			mRequired = decision.DefaultPolicy == v1alpha1.InjectionPolicyEnabled
+			if !mRequired {
+				reason = metrics.SkipDisabled
+			}
 		case "y", "yes":
 			mRequired = true
+		case "n", "no", injectRemove:
+			mRequired, reason = false, metrics.SkipAnnotation
 		}
 	}
 
-	log.Infof("Mutation policy for %v/%v: status: %q required:%v", metaData.Namespace, metaData.Name, status, mRequired)
-	return mRequired
+	log.WithFields(log.Fields{"namespace": metaData.Namespace, "name": metaData.Name, "policy": decision.Policy, "required": mRequired, "reason": reason}).Debug("Injection policy")
+	return mRequired, decision.Profile, reason, warnings
+}
+
+// skipWarning returns the warning telling the user why the sidecar is not injected
+func skipWarning(reason metrics.SkipReason, format string, args ...interface{}) string {
+	return fmt.Sprintf("sidecar injection skipped (%s): %s", reason, fmt.Sprintf(format, args...))
 }
 
-func insertContainer(dest, add []corev1.Container, path string) (p []operation) {
-	f := len(dest) == 0
-	var val interface{}
-	for _, add := range add {
-		val = add
-		path := path
-		if f {
-			f = false
-			val = []corev1.Container{add}
-		} else {
-			path = path + "/-"
+// cniMode tells whether the mesh CNI plugin intercepts the traffic of the pods of namespace: with
+// CNIEnabled, or where the namespace is labeled for it. The labels are those of the policy index.
+func (wh *WebHookServer) cniMode(namespace string) bool {
+	if wh.parms.CNIEnabled {
+		return true
+	}
+	return wh.Policies != nil && wh.Policies.NamespaceLabels(namespace)[webhookCNIKey] == "enabled"
+}
+
+// excludedOwnerKind tells whether the pods of the controllers of kind are never injected
+func (wh *WebHookServer) excludedOwnerKind(kind string) bool {
+	for _, k := range wh.parms.ExcludedOwnerKinds {
+		if strings.EqualFold(k, kind) {
+			return true
 		}
-		p = append(p, operation{
-			Operation: "add",
-			Path:      path,
-			Value:     val,
-		})
 	}
-	return p
-}
-
-func insertVolume(dest, add []corev1.Volume, path string) (p []operation) {
-	f := len(dest) == 0
-	var val interface{}
-	for _, add := range add {
-		val = add
-		path := path
-		if f {
-			f = false
-			val = []corev1.Volume{add}
-		} else {
-			path = path + "/-"
-		}
-		p = append(p, operation{
-			Operation: "add",
-			Path:      path,
-			Value:     val,
-		})
+	return false
+}
+
+// skipped answers a request whose object is left without sidecar for reason. A pod being
+// created records the reason in its status annotation, the workloads are left unchanged so
+// their pods are not rolled out for it.
+func (wh *WebHookServer) skipped(req *admissionv1.AdmissionRequest, pod *corev1.Pod, prefix string, reason metrics.SkipReason, warnings []string) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
 	}
-	return p
-}
-
-func insertImagePullSecrets(dest, add []corev1.LocalObjectReference, path string) (p []operation) {
-	f := len(dest) == 0
-	var val interface{}
-	for _, add := range add {
-		val = add
-		path := path
-		if f {
-			f = false
-			val = []corev1.LocalObjectReference{add}
-		} else {
-			path = path + "/-"
-		}
-		p = append(p, operation{
-			Operation: "add",
-			Path:      path,
-			Value:     val,
-		})
+	if reason == metrics.SkipInjected || prefix != "" || req.Operation != admissionv1.Create {
+		return resp
+	}
+
+	status := injectionStatus{State: statusSkipped, Reason: string(reason), Version: version.Version}
+	// the other annotations are kept, an "add" of a member replaces its value
+	op := inject.Operation{
+		Op:    "add",
+		Path:  "/metadata/annotations/" + inject.EscapePointer(webhookStatusKey),
+		Value: status.String(),
+	}
+	if pod.Annotations == nil {
+		op.Path, op.Value = "/metadata/annotations", map[string]string{webhookStatusKey: status.String()}
+	}
+	patch, err := encodeJSON([]inject.Operation{op})
+	if err != nil {
+		log.Errorf("Can't encode the status patch: %v", err)
+		return resp
+	}
+	pt := admissionv1.PatchTypeJSONPatch
+	resp.Patch, resp.PatchType = patch, &pt
+	return resp
+}
+
+// sidecarFor returns the sidecar of rootConfig for pod with profile, with the status annotation
+// of its injection, and the warnings about the profile
+func sidecarFor(rootConfig *Config, pod *corev1.Pod, profile string, cni bool) (inject.Sidecar, []string, error) {
+	sidecarConfig, err := rootConfig.forPod(pod, profile)
+	if err != nil {
+		return inject.Sidecar{}, nil, err
+	}
+	var warnings []string
+	if _, ok := rootConfig.Profiles[profile]; profile != "" && !ok {
+		warnings = append(warnings, fmt.Sprintf("sidecar profile %q is not configured, the base sidecar is injected", profile))
+	}
+
+	status := injectionStatus{
+		State:      statusInjected,
+		ConfigHash: rootConfig.Hash(),
+		Profile:    profile,
+		Version:    version.Version,
+	}
+	annotations := podMetadata(sidecarConfig.Annotations, pod.Annotations)
+	annotations[webhookStatusKey] = status.String()
+	containers, initContainers := sidecarConfig.Containers, sidecarConfig.InitContainers
+	if cni {
+		// the CNI plugin intercepts the traffic, it only needs the annotations
+		initContainers = nil
+		for key, value := range podMetadata(sidecarConfig.CNIAnnotations, pod.Annotations) {
+			annotations[key] = value
+		}
+		annotations[webhookInterceptionKey] = "cni"
+		annotations[webhookInboundPortsKey] = appPorts(pod, sidecarConfig)
+	}
+	if sidecarConfig.PortsEnv != "" {
+		ports := appPorts(pod, sidecarConfig)
+		containers = withEnv(containers, sidecarConfig.PortsEnv, ports)
+		initContainers = withEnv(initContainers, sidecarConfig.PortsEnv, ports)
 	}
-	return p
+	return inject.Sidecar{
+		Containers:       containers,
+		InitContainers:   initContainers,
+		Volumes:          sidecarConfig.Volumes,
+		ImagePullSecrets: sidecarConfig.ImagePullSecret,
+		Annotations:      annotations,
+		Labels:           podMetadata(sidecarConfig.Labels, pod.Labels),
+	}, warnings, nil
 }
 
-func annotationUpdate(dest map[string]string, add map[string]string) (p []operation) {
-	for key, value := range add {
-		if dest == nil || dest[key] == "" {
-			dest = map[string]string{}
-			p = append(p, operation{
-				Operation: "add",
-				Path:      "/metadata/annotations",
-				Value: map[string]string{
-					key: value,
-				},
-			})
-		} else {
-			p = append(p, operation{
-				Operation: "replace",
-				Path:      "/metadata/annotations/" + key,
-				Value:     value,
-			})
+// podMetadata returns the annotations or labels of entries to set on a pod having set: those it
+// doesn't have, and those overriding its value
+func podMetadata(entries []v1alpha1.PodMetadata, set map[string]string) map[string]string {
+	m := make(map[string]string, len(entries)+1)
+	for _, e := range entries {
+		if _, ok := set[e.Key]; !ok || e.Override {
+			m[e.Key] = e.Value
 		}
 	}
-	return p
+	return m
 }
 
-// create mutation patch for resoures
-func createpatch(pod *corev1.Pod, sidecarConfig *Config, annotations map[string]string) ([]byte, error) {
-	var p []operation
+// loadMutators loads the mutator plugins of the parameters and returns their mutators in order
+func loadMutators(p WebHookParameters) ([]inject.Mutator, error) {
+	for _, path := range p.MutatorPlugins {
+		if err := loadPlugin(path); err != nil {
+			return nil, err
+		}
+	}
+	return inject.Lookup(p.Mutators)
+}
 
-	p = append(p, insertContainer(pod.Spec.Containers, sidecarConfig.Containers, "/spec/containers")...)
-	p = append(p, insertVolume(pod.Spec.Volumes, sidecarConfig.Volumes, "/spec/volumes")...)
-	p = append(p, insertImagePullSecrets(pod.Spec.ImagePullSecrets, sidecarConfig.ImagePullSecret, "/spec/imagePullSecrets")...)
+// loadPlugin opens the Go plugin of path, whose init registers its mutators. Plugins need a
+// binary built with cgo, and the same versions of Go and of the inject package. The plugin
+// support stays out of the inject library, its users don't all build with cgo.
+func loadPlugin(path string) error {
+	if _, err := plugin.Open(path); err != nil {
+		return fmt.Errorf("failed to load mutator plugin %s: %v", path, err)
+	}
+	return nil
+}
 
-	p = append(p, annotationUpdate(pod.Annotations, annotations)...)
+// create mutation patch for resoures, the sidecar then the operations of mutators, the pod is
+// found at prefix in the patched object
+func createpatch(pod *corev1.Pod, prefix string, sidecar inject.Sidecar, mutators []inject.Mutator) ([]byte, int, error) {
+	p, err := inject.Chain(pod, prefix, inject.Patch(pod, "", sidecar), mutators)
+	if err != nil {
+		return nil, 0, err
+	}
+	patch, err := encodeJSON(p)
+	return patch, len(p), err
+}
 
-	return json.Marshal(p)
+// isDryRun tells whether the API server will not persist the result of req
+func isDryRun(req *admissionv1.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
 }
 
-// main mutation process
-func (wh *WebHookServer) mutation(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
-	req := ar.Request
-	var pod corev1.Pod
-	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
-		log.Errorf("Could not unmarshal raw object: %v", err)
-		return &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
+// main mutation process. The webhook is registered with sideEffects: NoneOnDryRun, so anything
+// touching the outside world has to be skipped for dry runs.
+// The Events, audit records and counters are side effects of ctx, recorded once the answer is given.
+func (wh *WebHookServer) mutation(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	// the errors are counted by namespace, the API server may retry them
+	var pod *corev1.Pod
+	var prefix string
+	failure := func(code int32, reason metav1.StatusReason, message string, err error) *admissionv1.AdmissionResponse {
+		resp := wh.failure(code, reason, message, err)
+		wh.effect(ctx, req, func() {
+			metrics.InjectionErrors.WithLabelValues(req.Namespace, string(reason)).Inc()
+			expvarFailures.Add(1)
+			if pod != nil {
+				wh.event(req, pod, corev1.EventTypeWarning, EventFailed, "%s: %v", message, err)
+			}
+			if resp.Allowed && pod != nil {
+				metrics.InjectionSkips.WithLabelValues(req.Namespace, string(metrics.SkipError)).Inc()
+			}
+		})
+		if resp.Allowed && pod != nil {
+			// failing open, the pod is skipped
+			return wh.skipped(req, pod, prefix, metrics.SkipError, resp.Warnings)
 		}
+		return resp
 	}
 
-	log.Infof("AdmissionReview for Kind=%v, Namespace=%v Name=%v (%v) UID=%v patchOperation=%v UserInfo=%v",
-		req.Kind, req.Namespace, req.Name, pod.Name, req.UID, req.Operation, req.UserInfo)
+	pod, prefix, err := podOf(req)
+	if err != nil {
+		return failure(http.StatusBadRequest, metav1.StatusReasonBadRequest, "can't decode the object", err)
+	}
+
+	logger := podLogger(req, pod)
+	logger.WithFields(log.Fields{"user": req.UserInfo.Username, "dryRun": isDryRun(req)}).Info("AdmissionReview received")
+	logger.WithField("userInfo", req.UserInfo).Debug("AdmissionReview user")
+
+	// the pod namespace is not set yet when it comes from a controller
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+
+	// containers can't be added to a running pod, its updates are only checked for drift.
+	// Pod templates of workloads can still be changed.
+	if req.Operation == admissionv1.Update && prefix == "" {
+		return wh.repair(req, pod)
+	}
+
+	// an object leaving the mesh has its sidecar removed, whatever the policies
+	if inject, _ := wh.annotation(pod.Annotations, webhookInjectKey); strings.EqualFold(inject, injectRemove) {
+		return wh.removal(ctx, req, pod, prefix)
+	}
 
 	// determine whether to perform mutation
-	if !requiredMutation(&pod.ObjectMeta) {
-		log.Infof("Skipping mutation for %s/%s due to policy check", pod.Namespace, pod.Name)
-		return &v1beta1.AdmissionResponse{
-			Allowed: true,
-		}
+	required, profile, reason, warnings := wh.requiredMutation(&pod.ObjectMeta)
+	if !required {
+		logger.WithField("reason", reason).Info("Skipping mutation due to policy check")
+		wh.effect(ctx, req, func() {
+			metrics.InjectionSkips.WithLabelValues(req.Namespace, string(reason)).Inc()
+			// the pods left alone where injection is off by default would flood the namespace
+			if reason != metrics.SkipInjected && reason != metrics.SkipDisabled {
+				wh.event(req, pod, corev1.EventTypeNormal, EventSkipped, "Sidecar not injected: %s", reason)
+			}
+		})
+		return wh.skipped(req, pod, prefix, reason, warnings)
 	}
 
-	// Workaround: https://github.com/kubernetes/kubernetes/issues/57982
-	applyDefaultsWorkaround(wh.SidecarConfig.Containers, wh.SidecarConfig.Volumes, wh.SidecarConfig.ImagePullSecret)
-	annotations := map[string]string{webhookStatusKey: "injected"}
-	patch, err := createpatch(&pod, wh.SidecarConfig, annotations)
-	if err != nil {
-		return &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
+	if resp := givenUp(ctx, req); resp != nil {
+		return resp
+	}
+	// take one snapshot so a reload in the middle of the request can't mix two configs
+	rootConfig := wh.SidecarConfig()
+	if rootConfig == nil {
+		return failure(http.StatusServiceUnavailable, metav1.StatusReasonServiceUnavailable,
+			"no sidecar config loaded", fmt.Errorf("%s did not deliver a valid config yet", wh.source))
+	}
+	start := time.Now()
+	configHash := rootConfig.Hash()
+	cni := wh.cniMode(pod.Namespace)
+	var key string
+	var cached cachedPatch
+	hit := false
+	if wh.patches != nil {
+		key = patchKey(configHash, profile, cni, req)
+		cached, hit = wh.patches.get(key)
+		wh.effect(ctx, req, func() {
+			metrics.PatchCacheLookups.WithLabelValues(strconv.FormatBool(hit)).Inc()
+		})
+	}
+	if !hit {
+		sidecar, profileWarnings, err := sidecarFor(rootConfig, pod, profile, cni)
+		if err != nil {
+			// the template depends on the pod, its annotations or ports may be what has to be fixed
+			return failure(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid,
+				fmt.Sprintf("can't render the sidecar config for %s/%s, check its annotations and ports", pod.Namespace, podIdentity(req, pod)), err)
+		}
+		if resp := givenUp(ctx, req); resp != nil {
+			return resp
+		}
+		patch, operations, err := createpatch(pod, prefix, sidecar, wh.mutators)
+		if err != nil {
+			return failure(http.StatusInternalServerError, metav1.StatusReasonInternalError, "can't create the patch", err)
+		}
+		cached = cachedPatch{patch: patch, operations: operations, warnings: profileWarnings}
+		if wh.patches != nil {
+			wh.patches.add(key, cached)
 		}
 	}
-
-	log.Infof("Response %v\n", string(patch))
-	return &v1beta1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patch,
-		PatchType: func() *v1beta1.PatchType {
-			pt := v1beta1.PatchTypeJSONPatch
+	patch, operations := cached.patch, cached.operations
+	warnings = append(warnings, cached.warnings...)
+	duration := time.Since(start)
+	logger.WithFields(log.Fields{"profile": profile, "operations": operations}).Info("Injecting the sidecar")
+	wh.effect(ctx, req, func() {
+		metrics.PatchDuration.WithLabelValues(req.Namespace, profile).Observe(duration.Seconds())
+		metrics.PatchOperations.WithLabelValues(req.Namespace, profile).Add(float64(operations))
+		metrics.Injections.WithLabelValues(req.Namespace, profile).Inc()
+		metrics.WorkloadInjections.WithLabelValues(req.Namespace, workloadLabel(req, pod)).Inc()
+		expvarInjections.Add(1)
+		if wh.audit != nil {
+			// the audit keeps the patch unredacted, what was injected has to be proven
+			if err := wh.audit.record(req, pod, profile, configHash, patch); err != nil {
+				logger.Errorf("failed to audit the patch: %v", err)
+			}
+		}
+		wh.event(req, pod, corev1.EventTypeNormal, EventInjected, "Sidecar injected with profile %q, config %s", profile, configHash)
+	})
+	if log.GetLevel() >= log.DebugLevel {
+		// the sidecar may carry credentials in its env
+		logger.WithField("patch", wh.redactor.patch(patch)).Debug("Injection patch")
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Patch:    patch,
+		PatchType: func() *admissionv1.PatchType {
+			pt := admissionv1.PatchTypeJSONPatch
 			return &pt
 		}(),
 	}
 }
 
-// Serve method for webhook server
-func (wh *WebHookServer) webhookMutation(w http.ResponseWriter, r *http.Request) {
-	var body []byte
-	if r.Body != nil {
-		if data, err := ioutil.ReadAll(r.Body); err == nil {
-			body = data
+// acquire reserves a slot for an admission request, it fails when all are taken
+func (wh *WebHookServer) acquire() bool {
+	if wh.inflight != nil {
+		select {
+		case wh.inflight <- struct{}{}:
+		default:
+			return false
 		}
 	}
+	metrics.InflightRequests.Inc()
+	return true
+}
 
-	if len(body) == 0 {
-		log.Errorf("empty request body")
+// release frees the slot of an admission request
+func (wh *WebHookServer) release() {
+	metrics.InflightRequests.Dec()
+	if wh.inflight != nil {
+		<-wh.inflight
+	}
+}
+
+// requestTimeout returns the time left to answer r: the timeout the API server sends along with
+// the request, the timeoutSeconds of the webhook, or RequestTimeout
+func (wh *WebHookServer) requestTimeout(r *http.Request) time.Duration {
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		if d, err := time.ParseDuration(t); err == nil && d > 0 {
+			return d
+		}
+		log.Warnf("invalid timeout %q in the request, using %v", t, wh.parms.RequestTimeout)
+	}
+	return wh.parms.RequestTimeout
+}
+
+// admitWithin runs admit on req under ctx, the one of the HTTP request, failing it once timeout
+// is over so a slow rendering doesn't keep the API server waiting past its own deadline. The
+// work stops as well when the API server goes away. The side effects of the answer are recorded
+// once it is given, none of an answer given up.
+func (wh *WebHookServer) admitWithin(ctx context.Context, timeout time.Duration, req *admissionv1.AdmissionRequest, admit admitFunc) *admissionv1.AdmissionResponse {
+	resp, err := runWithin(ctx, timeout, req, admit)
+	if err != nil {
+		if ctx.Err() != nil {
+			// nobody reads the answer, it only ends up in the logs
+			return wh.failure(http.StatusGatewayTimeout, metav1.StatusReasonTimeout, "the client went away", err)
+		}
+		return wh.failure(http.StatusGatewayTimeout, metav1.StatusReasonTimeout,
+			fmt.Sprintf("no answer within %v", timeout), err)
+	}
+	return resp
+}
+
+// admitFunc answers an admission request, mutating or validating it. Its side effects are
+// deferred through ctx until the answer is given, ctx is done once the answer is given up.
+type admitFunc func(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+
+// review answers a decoded admission request with admit
+func (wh *WebHookServer) review(r *http.Request, req *admissionv1.AdmissionRequest, admit admitFunc) *admissionv1.AdmissionResponse {
+	if err := validateRequest(req); err != nil {
+		return wh.failure(http.StatusBadRequest, metav1.StatusReasonBadRequest, "invalid AdmissionReview", err)
+	}
+
+	start := time.Now()
+	resp := wh.admitWithin(r.Context(), wh.requestTimeout(r), req, admit)
+	// one line per review, with what was decided
+	fields := log.Fields{
+		"path":       r.URL.Path,
+		"decision":   decisionOf(resp),
+		"patchBytes": len(resp.Patch),
+		"warnings":   len(resp.Warnings),
+		"duration":   time.Since(start).String(),
+	}
+	if resp.Result != nil {
+		fields["reason"] = resp.Result.Reason
+	}
+	requestLogger(req).WithFields(fields).Info("AdmissionReview answered")
+	return resp
+}
+
+// mutate rate limits the mutations of each namespace before running them
+func (wh *WebHookServer) mutate(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if !wh.namespaces.allow(req.Namespace) {
+		wh.effect(ctx, req, func() {
+			metrics.ThrottledRequests.WithLabelValues(metrics.ReasonRateLimited).Inc()
+		})
+		return wh.failure(http.StatusTooManyRequests, metav1.StatusReasonTooManyRequests,
+			fmt.Sprintf("namespace %s exceeds %v mutations per second", req.Namespace, wh.parms.NamespaceQPS),
+			errors.New("rate limited"))
+	}
+	return wh.mutation(ctx, req)
+}
+
+// serveReview returns the handler decoding the AdmissionReviews, answering them with admit. The
+// latency of the answers is observed under the webhook name.
+func (wh *WebHookServer) serveReview(webhook string, admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wh.serve(w, r, webhook, admit)
+	}
+}
+
+// errTooManyRequests is why a request is not reviewed when the replica is saturated
+var errTooManyRequests = errors.New("too many in-flight admission requests")
+
+// Serve method for webhook server
+func (wh *WebHookServer) serve(w http.ResponseWriter, r *http.Request, webhook string, admit admitFunc) {
+	start := time.Now()
+	// the API server only POSTs reviews, tell anything else what went wrong
+	if r.Method != http.MethodPost {
+		log.Errorf("method %s not allowed", r.Method)
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, fmt.Sprintf("method %s not allowed, expect POST", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	// a proxy in front of the injector adds the token, anything else did not go through it
+	if len(wh.webhookToken) > 0 && !tokenMatches(r, wh.parms.WebhookTokenHeader, wh.webhookToken) {
+		log.Warnf("unauthenticated admission request from %s", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	// turn requests away before reading them once the replica is saturated. Failing open they
+	// are admitted without sidecar instead, a 429 would fail the pods under failurePolicy: Fail.
+	saturated := !wh.acquire()
+	if saturated {
+		metrics.ThrottledRequests.WithLabelValues(metrics.ReasonOverload).Inc()
+		if !wh.parms.FailOpen {
+			log.Warnf("too many in-flight admission requests, rejecting one from %s", r.RemoteAddr)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many in-flight admission requests", http.StatusTooManyRequests)
+			return
+		}
+		log.Warnf("too many in-flight admission requests, admitting one from %s without review", r.RemoteAddr)
+	} else {
+		defer wh.release()
+	}
+
 	// verify the content type is accurate
 	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		log.Errorf("Content-Type=%s, expect application/json", contentType)
+	mediaType, ok := reviewMediaType(contentType)
+	if !ok {
+		log.Errorf("Content-Type=%s, expect one of %v", contentType, reviewMediaTypes)
+		http.Error(w, fmt.Sprintf("Content-Type %q not supported, expect one of %v", contentType, reviewMediaTypes), http.StatusUnsupportedMediaType)
 		return
 	}
+	// answer in the encoding asked for, the one of the request by default
+	if accepted, ok := reviewMediaType(r.Header.Get("Accept")); ok {
+		mediaType = accepted
+	}
 
-	var aResponse *v1beta1.AdmissionResponse
-	aRequest := v1beta1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &aRequest); err != nil {
-		log.Errorf("Can't decode body: %v", err)
-		aResponse = &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
+	// pods are bounded by the API server, anything much bigger is not an AdmissionReview
+	// the buffers are reused by the next requests, the decoded review copies what it keeps
+	in := getBuffer()
+	defer putBuffer(in)
+	if r.Body != nil {
+		limit := wh.parms.MaxRequestBytes
+		if _, err := in.ReadFrom(http.MaxBytesReader(w, r.Body, limit)); err != nil {
+			if int64(in.Len()) >= limit {
+				log.Errorf("request body exceeds %d bytes", limit)
+				http.Error(w, fmt.Sprintf("request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Errorf("failed to read request body: %v", err)
+			http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+			return
 		}
-	} else {
-		aResponse = wh.mutation(&aRequest)
 	}
+	body := in.Bytes()
 
-	admissionReview := v1beta1.AdmissionReview{}
-	if aResponse != nil {
-		admissionReview.Response = aResponse
-		if aRequest.Request != nil {
-			admissionReview.Response.UID = aRequest.Request.UID
-		}
+	if len(body) == 0 {
+		log.Errorf("empty request body")
+		http.Error(w, "empty request body, expect an AdmissionReview", http.StatusBadRequest)
+		return
 	}
 
-	resp, err := json.Marshal(admissionReview)
+	expvarReviews.Add(1)
+	// answer with the AdmissionReview version the API server sent
+	var aResponse *admissionv1.AdmissionResponse
+	aRequest, gvk, err := decodeReview(body)
 	if err != nil {
+		aResponse = wh.failure(http.StatusBadRequest, metav1.StatusReasonBadRequest, "can't decode the AdmissionReview", err)
+	} else if saturated {
+		// failing open, allowed with the skip warning
+		aResponse = wh.failure(http.StatusTooManyRequests, metav1.StatusReasonTooManyRequests,
+			"no review", errTooManyRequests)
+		aResponse.UID = aRequest.UID
+	} else {
+		aResponse = wh.review(r, aRequest, admit)
+		// the API server discards the answers not matching the UID of its request
+		aResponse.UID = aRequest.UID
+		if wh.capture != nil && webhook == metrics.WebhookMutate && !isDryRun(aRequest) {
+			if err := wh.capture.record(aRequest, aResponse); err != nil {
+				log.Errorf("failed to capture AdmissionReview %s: %v", aRequest.UID, err)
+			}
+		}
+	}
+
+	out := getBuffer()
+	defer putBuffer(out)
+	if err := encodeReview(out, gvk, mediaType, aResponse); err != nil {
 		log.Errorf("Can't encode response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	log.Infof("Ready to write reponse ...")
-	if _, err := w.Write(resp); err != nil {
+	w.Header().Set("Content-Type", mediaType)
+	if _, err := w.Write(out.Bytes()); err != nil {
 		log.Errorf("Can't write response: %v", err)
 	}
+	if aRequest != nil {
+		metrics.AdmissionDuration.WithLabelValues(webhook, aRequest.Namespace).Observe(time.Since(start).Seconds())
+	}
 }
 
-//Run will run the server
+// Run will run the server
 func (wh *WebHookServer) Run(stop <-chan struct{}, p WebHookParameters) {
-	var healthChan <-chan time.Time
-
-	if p.HealthCheckInterval != 0 && p.HealthCheckFile != "" {
-		t := time.NewTicker(p.HealthCheckInterval)
-		healthChan = t.C
-		defer t.Stop()
-	}
-
-	go func() {
-		if err := wh.Server.ListenAndServeTLS("", ""); err != nil {
-			log.Errorf("Filed to listen and serve webhook server: %v", err)
+	if wh.Server != nil {
+		go wh.serveListener(listenerWebhook, func() error {
+			return wh.Server.ListenAndServeTLS("", "")
+		})
+		// each certificate is reloaded on its own
+		for _, c := range wh.allCerts() {
+			c.watch(stop)
 		}
-	}()
-
-	defer wh.Server.Close()
-	defer wh.Watch.Close()
+		go wh.monitorCert(stop)
+	}
+	if wh.InsecureServer != nil {
+		log.Warnf("Serving the webhook over plain HTTP on %s", wh.InsecureServer.Addr)
+		go wh.serveListener(listenerInsecure, wh.InsecureServer.ListenAndServe)
+	}
+	if wh.AdminServer != nil {
+		go func() {
+			if err := wh.AdminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Filed to listen and serve admin server: %v", err)
+			}
+		}()
+	}
 
-	var timerChan <-chan time.Time
+	if err := wh.source.Watch(wh.reloadConfig, stop); err != nil {
+		log.Errorf("failed to watch %s: %v", wh.source, err)
+		wh.Lock.Lock()
+		wh.watchError = err
+		wh.Lock.Unlock()
+	}
+	if p.HealthCheckFile != "" && p.HealthCheckInterval > 0 {
+		go wh.writeHealthFile(p.HealthCheckFile, p.HealthCheckInterval, stop)
+	}
 
-	for {
-		select {
-		case <-timerChan:
-			sidecarConfig, err := loadConfig(p.SidecarConfigFile)
-			if err != nil {
-				log.Errorf("update error: %v", err)
-				break
-			}
-			pair, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
-			if err != nil {
-				log.Errorf("reload cert error: %v", err)
-				break
-			}
+	<-stop
+}
 
-			wh.Lock.Lock()
-			wh.SidecarConfig = sidecarConfig
-			wh.Server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
-			wh.Lock.Unlock()
-		case event := <-wh.Watch.Event:
-			if event.IsModify() || event.IsCreate() {
-				timerChan = time.After(100 * time.Microsecond)
-			}
-		case err := <-wh.Watch.Error:
-			log.Errorf("watcher error: %v", err)
-		case <-healthChan:
-			content := []byte(`ok`)
-			if err := ioutil.WriteFile(p.HealthCheckFile, content, 0644); err != nil {
-				log.Errorf("health check update of %q failed: %v", p.HealthCheckFile, err)
-			}
+// Shutdown keeps serving for delay, the time needed for the endpoint of the replica to be removed
+// everywhere, then stops accepting connections and waits up to timeout for the in-flight reviews
+func (wh *WebHookServer) Shutdown(delay, timeout time.Duration) error {
+	// readiness fails from now on, so the replica leaves the Service endpoints
+	atomic.StoreInt32(&wh.draining, 1)
+	if delay > 0 {
+		log.Infof("Draining for %v before shutting down", delay)
+		time.Sleep(delay)
+	}
 
-		case <-stop:
-			return
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var errs []error
+	for _, server := range []*http.Server{wh.Server, wh.InsecureServer} {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			// the reviews still running are lost, the API server retries them on another replica
+			server.Close()
+			errs = append(errs, err)
 		}
 	}
+	// probes and scrapes can stop with the reviews
+	if wh.AdminServer != nil {
+		wh.AdminServer.Close()
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// configureHTTP2 enables HTTP/2, which the API server prefers, on the TLS server, or disables it
+func configureHTTP2(server *http.Server, p WebHookParameters) error {
+	if p.DisableHTTP2 {
+		// a non-nil empty map turns off the automatic HTTP/2 of net/http
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		server.TLSConfig.NextProtos = []string{"http/1.1"}
+		return nil
+	}
+	return http2.ConfigureServer(server, &http2.Server{
+		MaxConcurrentStreams: p.HTTP2MaxConcurrentStreams,
+		IdleTimeout:          p.IdleTimeout,
+	})
 }
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/sidecar-injector/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// testConfig is the sidecar config of the tests
+const testConfig = `apiVersion: injector.mesher.io/v1
+kind: SidecarConfig
+containers:
+- name: mesher
+  image: mesher:latest
+  env:
+  - name: CSE_REGISTRY_ADDR
+    value: http://registry:30100
+`
+
+// newTestWebhook returns a webhook serving testConfig
+func newTestWebhook(t testing.TB) *WebHookServer {
+	wh, err := NewReplayer(WebHookParameters{}, NewStaticSource([]byte(testConfig)))
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	return wh
+}
+
+// testPod returns a pod of namespace asking for the sidecar
+func testPod(namespace, name string) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: map[string]string{webhookInjectKey: "yes"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "app",
+				Image: "app:latest",
+				Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+			}},
+		},
+	}
+}
+
+// podRequest returns the creation request of pod
+func podRequest(t testing.TB, pod *corev1.Pod, dryRun bool) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("can't encode the pod: %v", err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       "test",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+		DryRun:    &dryRun,
+	}
+}
+
+func TestDryRunHasNoSideEffects(t *testing.T) {
+	tests := []struct {
+		namespace string
+		dryRun    bool
+	}{
+		{namespace: "dry-run", dryRun: true},
+		// the same request for real records them, the test would pass without effects otherwise
+		{namespace: "wet-run", dryRun: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			wh := newTestWebhook(t)
+			events := record.NewFakeRecorder(10)
+			wh.Events = events
+			auditFile := filepath.Join(t.TempDir(), "audit.log")
+			var err error
+			if wh.audit, err = newAuditSink(auditFile, 0, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			resp := wh.admitWithin(context.Background(), time.Minute, podRequest(t, testPod(tt.namespace, "web"), tt.dryRun), wh.mutation)
+			if !resp.Allowed || len(resp.Patch) == 0 {
+				t.Fatalf("expected an allowed answer with a patch, got %+v", resp)
+			}
+
+			var recorded []string
+			for len(events.Events) > 0 {
+				recorded = append(recorded, <-events.Events)
+			}
+			audited, err := ioutil.ReadFile(auditFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			injections := metrics.Totals(metrics.Injections, "namespace", "profile")[tt.namespace][""]
+			workloads := metrics.Totals(metrics.WorkloadInjections, "namespace", "workload")[tt.namespace]
+
+			if tt.dryRun {
+				if len(recorded) != 0 {
+					t.Errorf("expected no Event, got %v", recorded)
+				}
+				if len(audited) != 0 {
+					t.Errorf("expected no audit record, got %s", audited)
+				}
+				if injections != 0 || len(workloads) != 0 {
+					t.Errorf("expected no injection counted, got %v and %v", injections, workloads)
+				}
+				return
+			}
+			if len(recorded) != 1 {
+				t.Errorf("expected the Event of the injection, got %v", recorded)
+			}
+			if len(audited) == 0 {
+				t.Error("expected the audit record of the injection")
+			}
+			if injections != 1 {
+				t.Errorf("expected the injection counted once, got %v", injections)
+			}
+		})
+	}
+}
+
+func BenchmarkMutation(b *testing.B) {
+	wh := newTestWebhook(b)
+	req := podRequest(b, testPod("bench", "web"), false)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp := wh.mutation(context.Background(), req); len(resp.Patch) == 0 {
+			b.Fatalf("no patch: %+v", resp)
+		}
+	}
+}
+
+func BenchmarkCreatePatch(b *testing.B) {
+	wh := newTestWebhook(b)
+	pod := testPod("bench", "web")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sidecar, _, err := sidecarFor(wh.SidecarConfig(), pod, "", false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := createpatch(pod, "", sidecar, wh.mutators); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
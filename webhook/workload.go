@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Pod template paths of the workloads, in their JSON document
+const (
+	workloadTemplatePath = "/spec/template"
+	cronJobTemplatePath  = "/spec/jobTemplate/spec/template"
+)
+
+// podKind is the kind of the pods
+var podKind = schema.GroupKind{Kind: "Pod"}
+
+// workloadKinds are the kinds whose pod template is injected
+var workloadKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "Deployment"}:  true,
+	{Group: "apps", Kind: "StatefulSet"}: true,
+	{Group: "apps", Kind: "DaemonSet"}:   true,
+	{Group: "batch", Kind: "Job"}:        true,
+	{Group: "batch", Kind: "CronJob"}:    true,
+}
+
+// supportedKind tells whether objects of gk can be injected
+func supportedKind(gk schema.GroupKind) bool {
+	return gk == podKind || workloadKinds[gk]
+}
+
+// podOf returns the pod of a request, or the pod template of a workload, and the JSON pointer
+// of that pod in the object, empty for a pod. Workloads are decoded as their latest version,
+// the pod template is at the same place in the older ones.
+func podOf(req *admissionv1.AdmissionRequest) (*corev1.Pod, string, error) {
+	raw := req.Object.Raw
+	gk := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+	switch gk {
+	case podKind:
+		var pod corev1.Pod
+		if err := decodeJSON(raw, &pod); err != nil {
+			return nil, "", err
+		}
+		return &pod, "", nil
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		var d appsv1.Deployment
+		if err := decodeJSON(raw, &d); err != nil {
+			return nil, "", err
+		}
+		return templatePod(&d.ObjectMeta, &d.Spec.Template), workloadTemplatePath, nil
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		var s appsv1.StatefulSet
+		if err := decodeJSON(raw, &s); err != nil {
+			return nil, "", err
+		}
+		return templatePod(&s.ObjectMeta, &s.Spec.Template), workloadTemplatePath, nil
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+		var d appsv1.DaemonSet
+		if err := decodeJSON(raw, &d); err != nil {
+			return nil, "", err
+		}
+		return templatePod(&d.ObjectMeta, &d.Spec.Template), workloadTemplatePath, nil
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		var j batchv1.Job
+		if err := decodeJSON(raw, &j); err != nil {
+			return nil, "", err
+		}
+		return templatePod(&j.ObjectMeta, &j.Spec.Template), workloadTemplatePath, nil
+	case schema.GroupKind{Group: "batch", Kind: "CronJob"}:
+		var c batchv1beta1.CronJob
+		if err := decodeJSON(raw, &c); err != nil {
+			return nil, "", err
+		}
+		return templatePod(&c.ObjectMeta, &c.Spec.JobTemplate.Spec.Template), cronJobTemplatePath, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported kind %s", gk)
+	}
+}
+
+// templatePod returns the pod described by the template of a workload, named after the workload
+func templatePod(workload *metav1.ObjectMeta, template *corev1.PodTemplateSpec) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+	pod.Namespace = workload.Namespace
+	if pod.Name == "" {
+		pod.Name = workload.Name
+	}
+	return pod
+}